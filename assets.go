@@ -0,0 +1,33 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// The page templates and static files are built into the binary, so that it
+// runs from any directory. Only the files pages load are embedded; the Jest
+// tests beside them in static are not served.
+var (
+	//go:embed templates/*.html
+	embeddedTemplates embed.FS
+
+	//go:embed static/outliner.js static/style.css
+	embeddedStatic embed.FS
+)
+
+// assets returns the file system to serve a directory of assets from: dir
+// on disk if it is set, for development, otherwise the copy of embedded
+// under root.
+func assets(embedded embed.FS, root, dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	sub, err := fs.Sub(embedded, root)
+	if err != nil {
+		// Only possible if root is not a valid path
+		panic(err)
+	}
+	return sub
+}
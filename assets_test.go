@@ -0,0 +1,50 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	templates := assets(embeddedTemplates, "templates", "")
+	tmpl, err := template.ParseFS(templates, "*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse embedded templates: %v", err)
+	}
+	for _, name := range []string{"login.html", "outlines.html", "editor.html"} {
+		if tmpl.Lookup(name) == nil {
+			t.Errorf("Expected embedded template %s", name)
+		}
+	}
+
+	static := assets(embeddedStatic, "static", "")
+	for _, name := range []string{"style.css", "outliner.js"} {
+		if _, err := fs.Stat(static, name); err != nil {
+			t.Errorf("Expected embedded %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"outliner.test.js", "outliner.module.js"} {
+		if _, err := fs.Stat(static, name); err == nil {
+			t.Errorf("Expected %s not to be embedded", name)
+		}
+	}
+}
+
+func TestAssetOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	static := assets(embeddedStatic, "static", dir)
+	b, err := fs.ReadFile(static, "style.css")
+	if err != nil {
+		t.Fatalf("Failed to read overridden file: %v", err)
+	}
+	if string(b) != "body {}" {
+		t.Errorf("Expected file from override directory, got %q", b)
+	}
+}
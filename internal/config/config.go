@@ -0,0 +1,571 @@
+// Package config loads Composter's settings. Each setting has a built-in
+// default, which can be overridden, in increasing order of precedence, by a
+// TOML config file, an environment variable and a command-line flag.
+//
+// The config file is named by the -config flag or the COMPOSTER_CONFIG
+// environment variable. Unknown keys in it are an error, so that typos do
+// not go unnoticed.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the full set of settings.
+type Config struct {
+	Server   Server   `toml:"server"`
+	TLS      TLS      `toml:"tls"`
+	Database Database `toml:"database"`
+	Session  Session  `toml:"session"`
+	Cookies  Cookies  `toml:"cookies"`
+	Auth     Auth     `toml:"auth"`
+	OIDC     OIDC     `toml:"oidc"`
+	Trash    Trash    `toml:"trash"`
+	Features Features `toml:"features"`
+}
+
+type Server struct {
+	// Listen is the address to serve HTTP on, as host:port.
+	Listen string `toml:"listen"`
+
+	// TemplatesDir and StaticDir override the templates and static files
+	// built into the binary, for development. Templates are re-read on
+	// every page when TemplatesDir is set.
+	TemplatesDir string `toml:"templates_dir"`
+	StaticDir    string `toml:"static_dir"`
+
+	// Timeouts for reading a request, writing its response and keeping an
+	// idle connection open.
+	ReadTimeout       time.Duration `toml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout"`
+	WriteTimeout      time.Duration `toml:"write_timeout"`
+	IdleTimeout       time.Duration `toml:"idle_timeout"`
+
+	// ShutdownTimeout is how long requests in progress may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
+	MaxHeaderBytes int   `toml:"max_header_bytes"`
+	MaxBodyBytes   int64 `toml:"max_body_bytes"`
+
+	// TrustedProxies are the IP addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-Proto and X-Forwarded-For headers are believed.
+	TrustedProxies []string `toml:"trusted_proxies"`
+}
+
+// TLS configures serving HTTPS. It is off unless CertFile and KeyFile are
+// set. Send SIGHUP to reload them after renewal.
+type TLS struct {
+	CertFile string `toml:"cert_file"`
+	KeyFile  string `toml:"key_file"`
+
+	// RedirectListen is an address to serve plain HTTP on, redirecting
+	// every request to HTTPS.
+	RedirectListen string `toml:"redirect_listen"`
+
+	// HSTSMaxAge is how long browsers are told to insist on HTTPS. Zero
+	// leaves the Strict-Transport-Security header out.
+	HSTSMaxAge time.Duration `toml:"hsts_max_age"`
+}
+
+// Enabled reports whether HTTPS is configured.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Database struct {
+	// Driver is "sqlite" or "postgres".
+	Driver string `toml:"driver"`
+	// DSN is a SQLite file name, or a PostgreSQL connection string or URL.
+	DSN string `toml:"dsn"`
+}
+
+type Session struct {
+	// Store is where sessions are kept: "database" or "memory". "sqlite"
+	// is accepted as another name for "database".
+	Store           string        `toml:"store"`
+	IdleTimeout     time.Duration `toml:"idle_timeout"`
+	AbsoluteTimeout time.Duration `toml:"absolute_timeout"`
+}
+
+type Cookies struct {
+	// Secure marks cookies to be sent over HTTPS only.
+	Secure bool `toml:"secure"`
+
+	// SameSite is "lax", "strict" or "none".
+	SameSite string `toml:"same_site"`
+}
+
+type Auth struct {
+	// AdminPassword is the first admin's password. If empty, one is
+	// generated and printed once.
+	AdminPassword      string `toml:"admin_password"`
+	PasswordMinLength  int    `toml:"password_min_length"`
+	PasswordMinClasses int    `toml:"password_min_classes"`
+}
+
+// OIDC configures single sign-on. It is off unless Issuer is set.
+type OIDC struct {
+	Issuer        string   `toml:"issuer"`
+	ClientID      string   `toml:"client_id"`
+	ClientSecret  string   `toml:"client_secret"`
+	RedirectURL   string   `toml:"redirect_url"`
+	UsernameClaim string   `toml:"username_claim"`
+	GroupsClaim   string   `toml:"groups_claim"`
+	AdminGroups   []string `toml:"admin_groups"`
+	AutoProvision bool     `toml:"auto_provision"`
+	LinkExisting  bool     `toml:"link_existing"`
+}
+
+type Trash struct {
+	// Retention is how long deleted outlines and templates stay in the
+	// trash before they are purged. Zero keeps them until they are purged
+	// by hand.
+	Retention time.Duration `toml:"retention"`
+}
+
+// Features turn optional parts of Composter on and off.
+type Features struct {
+	// API enables the REST API and API tokens.
+	API bool `toml:"api"`
+
+	// TwoFactor lets users enroll in two-factor authentication. Turning it
+	// off does not stop users who already have from being asked for codes.
+	TwoFactor bool `toml:"two_factor"`
+}
+
+// Default returns the settings used when nothing overrides them.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Listen:            ":8080",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			MaxBodyBytes:      16 << 20,
+		},
+		TLS: TLS{
+			HSTSMaxAge: 180 * 24 * time.Hour,
+		},
+		Database: Database{
+			Driver: "sqlite",
+			DSN:    "composter.db",
+		},
+		Session: Session{
+			Store:           "database",
+			IdleTimeout:     24 * time.Hour,
+			AbsoluteTimeout: 7 * 24 * time.Hour,
+		},
+		Cookies: Cookies{
+			SameSite: "lax",
+		},
+		Auth: Auth{
+			PasswordMinLength:  10,
+			PasswordMinClasses: 2,
+		},
+		Trash: Trash{
+			Retention: 30 * 24 * time.Hour,
+		},
+		Features: Features{
+			API:       true,
+			TwoFactor: true,
+		},
+	}
+}
+
+// setting ties a field to the environment variable and flag that set it.
+// Secrets have no flag, since command lines are visible to other users.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"COMPOSTER_LISTEN", "listen", "address to listen on", (*stringValue)(&c.Server.Listen)},
+		{"COMPOSTER_TEMPLATES_DIR", "templates", "directory to read page templates from instead of the built-in ones", (*stringValue)(&c.Server.TemplatesDir)},
+		{"COMPOSTER_STATIC_DIR", "static", "directory to serve static files from instead of the built-in ones", (*stringValue)(&c.Server.StaticDir)},
+		{"COMPOSTER_READ_TIMEOUT", "read-timeout", "time allowed to read a request", (*durationValue)(&c.Server.ReadTimeout)},
+		{"COMPOSTER_READ_HEADER_TIMEOUT", "read-header-timeout", "time allowed to read request headers", (*durationValue)(&c.Server.ReadHeaderTimeout)},
+		{"COMPOSTER_WRITE_TIMEOUT", "write-timeout", "time allowed to write a response", (*durationValue)(&c.Server.WriteTimeout)},
+		{"COMPOSTER_IDLE_TIMEOUT", "idle-timeout", "how long to keep idle connections open", (*durationValue)(&c.Server.IdleTimeout)},
+		{"COMPOSTER_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to wait for requests to finish when stopping", (*durationValue)(&c.Server.ShutdownTimeout)},
+		{"COMPOSTER_MAX_HEADER_BYTES", "max-header-bytes", "largest request headers accepted", (*intValue)(&c.Server.MaxHeaderBytes)},
+		{"COMPOSTER_MAX_BODY_BYTES", "max-body-bytes", "largest request body accepted", (*int64Value)(&c.Server.MaxBodyBytes)},
+		{"COMPOSTER_TRUSTED_PROXIES", "trusted-proxies", "comma-separated addresses of proxies trusted to set X-Forwarded-Proto and X-Forwarded-For", (*listValue)(&c.Server.TrustedProxies)},
+		{"COMPOSTER_TLS_CERT_FILE", "tls-cert", "TLS certificate file; enables HTTPS", (*stringValue)(&c.TLS.CertFile)},
+		{"COMPOSTER_TLS_KEY_FILE", "tls-key", "TLS key file", (*stringValue)(&c.TLS.KeyFile)},
+		{"COMPOSTER_TLS_REDIRECT_LISTEN", "tls-redirect-listen", "address to redirect plain HTTP to HTTPS from", (*stringValue)(&c.TLS.RedirectListen)},
+		{"COMPOSTER_HSTS_MAX_AGE", "hsts-max-age", "Strict-Transport-Security max-age; 0 to leave it out", (*durationValue)(&c.TLS.HSTSMaxAge)},
+		{"COMPOSTER_DATABASE_DRIVER", "db-driver", "database backend: sqlite or postgres", (*stringValue)(&c.Database.Driver)},
+		{"COMPOSTER_DATABASE_DSN", "db", "database to open", (*stringValue)(&c.Database.DSN)},
+		{"COMPOSTER_SESSION_STORE", "session-store", "where to keep sessions: database or memory", (*stringValue)(&c.Session.Store)},
+		{"COMPOSTER_SESSION_IDLE_TIMEOUT", "session-idle-timeout", "how long an unused session lasts", (*durationValue)(&c.Session.IdleTimeout)},
+		{"COMPOSTER_SESSION_ABSOLUTE_TIMEOUT", "session-absolute-timeout", "how long any session lasts", (*durationValue)(&c.Session.AbsoluteTimeout)},
+		{"COMPOSTER_COOKIE_SECURE", "cookie-secure", "send cookies over HTTPS only", (*boolValue)(&c.Cookies.Secure)},
+		{"COMPOSTER_COOKIE_SAMESITE", "cookie-samesite", "SameSite cookie attribute: lax, strict or none", (*stringValue)(&c.Cookies.SameSite)},
+		{"COMPOSTER_ADMIN_PASSWORD", "", "", (*stringValue)(&c.Auth.AdminPassword)},
+		{"COMPOSTER_PASSWORD_MIN_LENGTH", "password-min-length", "minimum password length", (*intValue)(&c.Auth.PasswordMinLength)},
+		{"COMPOSTER_PASSWORD_MIN_CLASSES", "password-min-classes", "how many character classes a password must mix", (*intValue)(&c.Auth.PasswordMinClasses)},
+		{"COMPOSTER_OIDC_ISSUER", "oidc-issuer", "OpenID Connect issuer URL; enables single sign-on", (*stringValue)(&c.OIDC.Issuer)},
+		{"COMPOSTER_OIDC_CLIENT_ID", "oidc-client-id", "OpenID Connect client ID", (*stringValue)(&c.OIDC.ClientID)},
+		{"COMPOSTER_OIDC_CLIENT_SECRET", "", "", (*stringValue)(&c.OIDC.ClientSecret)},
+		{"COMPOSTER_OIDC_REDIRECT_URL", "oidc-redirect-url", "OpenID Connect callback URL", (*stringValue)(&c.OIDC.RedirectURL)},
+		{"COMPOSTER_OIDC_USERNAME_CLAIM", "oidc-username-claim", "claim matched against usernames", (*stringValue)(&c.OIDC.UsernameClaim)},
+		{"COMPOSTER_OIDC_GROUPS_CLAIM", "oidc-groups-claim", "claim listing a user's groups", (*stringValue)(&c.OIDC.GroupsClaim)},
+		{"COMPOSTER_OIDC_ADMIN_GROUPS", "oidc-admin-groups", "comma-separated groups whose members are admins", (*listValue)(&c.OIDC.AdminGroups)},
+		{"COMPOSTER_OIDC_AUTO_PROVISION", "oidc-auto-provision", "create users on their first single sign-on", (*boolValue)(&c.OIDC.AutoProvision)},
+		{"COMPOSTER_OIDC_LINK_EXISTING", "oidc-link-existing", "let single sign-on accounts log in as existing users without a password", (*boolValue)(&c.OIDC.LinkExisting)},
+		{"COMPOSTER_TRASH_RETENTION", "trash-retention", "how long deleted items stay in the trash; 0 to keep them until purged by hand", (*durationValue)(&c.Trash.Retention)},
+		{"COMPOSTER_FEATURE_API", "feature-api", "enable the REST API and API tokens", (*boolValue)(&c.Features.API)},
+		{"COMPOSTER_FEATURE_TWO_FACTOR", "feature-two-factor", "let users enroll in two-factor authentication", (*boolValue)(&c.Features.TwoFactor)},
+	}
+}
+
+// Load reads the configuration from the config file, the environment as
+// seen through getenv, and the command-line arguments args (without the
+// program name), and validates it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	// Flags are parsed first to find the config file, but applied last
+	fs := flag.NewFlagSet("composter", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", getenv("COMPOSTER_CONFIG"), "TOML config file")
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.Var(&deferredValue{target: s.value}, s.flag, s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *configPath != "" {
+		if err := c.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if d, ok := f.Value.(*deferredValue); ok && err == nil {
+			if setErr := d.target.Set(d.raw); setErr != nil {
+				err = fmt.Errorf("-%s: %w", f.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	md, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("config file %s: unknown keys %s", path, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// Validate checks that the settings make sense together, reporting every
+// problem it finds.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		fail("server.listen: %v", err)
+	}
+	for name, dir := range map[string]string{
+		"server.templates_dir": c.Server.TemplatesDir,
+		"server.static_dir":    c.Server.StaticDir,
+	} {
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil {
+			fail("%s: %v", name, err)
+		} else if !info.IsDir() {
+			fail("%s: %s is not a directory", name, dir)
+		}
+	}
+
+	for name, d := range map[string]time.Duration{
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.read_header_timeout": c.Server.ReadHeaderTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+		"server.shutdown_timeout":    c.Server.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			fail("%s must be positive", name)
+		}
+	}
+	if c.Server.MaxHeaderBytes < 4096 {
+		fail("server.max_header_bytes must be at least 4096")
+	}
+	if c.Server.MaxBodyBytes < 1024 {
+		fail("server.max_body_bytes must be at least 1024")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			fail("server.trusted_proxies: invalid address %q", proxy)
+		}
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			fail("tls.cert_file and tls.key_file must be set together")
+		}
+		for name, file := range map[string]string{
+			"tls.cert_file": c.TLS.CertFile,
+			"tls.key_file":  c.TLS.KeyFile,
+		} {
+			if _, err := os.Stat(file); file != "" && err != nil {
+				fail("%s: %v", name, err)
+			}
+		}
+	}
+	if c.TLS.RedirectListen != "" {
+		if !c.TLS.Enabled() {
+			fail("tls.redirect_listen requires tls.cert_file and tls.key_file")
+		}
+		if _, _, err := net.SplitHostPort(c.TLS.RedirectListen); err != nil {
+			fail("tls.redirect_listen: %v", err)
+		}
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		fail("tls.hsts_max_age must not be negative")
+	}
+
+	switch c.Database.Driver {
+	case "sqlite", "postgres":
+	default:
+		fail("database.driver: unknown driver %q", c.Database.Driver)
+	}
+	if c.Database.DSN == "" {
+		fail("database.dsn must be set")
+	}
+
+	switch c.Session.Store {
+	case "database", "sqlite", "memory":
+	default:
+		fail("session.store: unknown store %q", c.Session.Store)
+	}
+	if c.Session.IdleTimeout <= 0 {
+		fail("session.idle_timeout must be positive")
+	}
+	if c.Session.AbsoluteTimeout < c.Session.IdleTimeout {
+		fail("session.absolute_timeout must be at least session.idle_timeout")
+	}
+
+	switch c.Cookies.SameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.Cookies.Secure {
+			fail("cookies.same_site = \"none\" requires cookies.secure")
+		}
+	default:
+		fail("cookies.same_site: unknown value %q", c.Cookies.SameSite)
+	}
+
+	if c.Auth.PasswordMinLength < 1 {
+		fail("auth.password_min_length must be at least 1")
+	}
+	if c.Auth.PasswordMinClasses < 1 || c.Auth.PasswordMinClasses > 4 {
+		fail("auth.password_min_classes must be between 1 and 4")
+	}
+
+	if c.OIDC.Issuer != "" {
+		if c.OIDC.ClientID == "" {
+			fail("oidc.client_id must be set when oidc.issuer is")
+		}
+		if c.OIDC.RedirectURL == "" {
+			fail("oidc.redirect_url must be set when oidc.issuer is")
+		}
+	}
+
+	if c.Trash.Retention < 0 {
+		fail("trash.retention must not be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+// Usage describes every setting, for -help.
+func Usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: composter [flags]")
+	fmt.Fprintln(w, "       composter migrate status|up [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Settings come from, in increasing order of precedence: defaults, the")
+	fmt.Fprintln(w, "config file, environment variables and flags.")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  -config\n\tTOML config file (env COMPOSTER_CONFIG)\n")
+
+	defaults := Default().settings()
+	for _, s := range defaults {
+		if s.flag == "" {
+			fmt.Fprintf(w, "  (env %s only)\n", s.env)
+			continue
+		}
+		fmt.Fprintf(w, "  -%s\n\t%s (env %s, default %q)\n", s.flag, s.usage, s.env, s.value.String())
+	}
+}
+
+// Flag values for each kind of setting.
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type int64Value int64
+
+func (v *int64Value) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*v = int64Value(n)
+	return nil
+}
+func (v *int64Value) String() string { return strconv.FormatInt(int64(*v), 10) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+// deferredValue holds a flag's value until the config file and environment
+// have been applied, so that the flag can override them.
+type deferredValue struct {
+	target flag.Value
+	raw    string
+}
+
+func (v *deferredValue) Set(s string) error {
+	// Catch malformed values while parsing, where the flag package reports
+	// them with usage information
+	if err := v.check(s); err != nil {
+		return err
+	}
+	v.raw = s
+	return nil
+}
+
+func (v *deferredValue) check(s string) error {
+	switch v.target.(type) {
+	case *intValue:
+		_, err := strconv.Atoi(s)
+		return err
+	case *int64Value:
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err
+	case *boolValue:
+		_, err := strconv.ParseBool(s)
+		return err
+	case *durationValue:
+		_, err := time.ParseDuration(s)
+		return err
+	}
+	return nil
+}
+
+func (v *deferredValue) String() string { return v.raw }
+
+// IsBoolFlag lets boolean flags be given without a value.
+func (v *deferredValue) IsBoolFlag() bool {
+	_, ok := v.target.(*boolValue)
+	return ok
+}
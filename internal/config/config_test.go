@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv returns a getenv that sees only vars.
+func testEnv(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "composter.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(nil, testEnv(nil))
+	if err != nil {
+		t.Fatalf("Failed to load defaults: %v", err)
+	}
+
+	if want := Default(); !reflect.DeepEqual(c, want) {
+		t.Errorf("Expected defaults %+v, got %+v", want, c)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+[server]
+listen = ":9000"
+
+[database]
+dsn = "file.db"
+
+[session]
+store = "memory"
+idle_timeout = "1h"
+absolute_timeout = "12h"
+
+[oidc]
+admin_groups = ["admins", "ops"]
+`)
+
+	env := map[string]string{
+		"COMPOSTER_CONFIG":       path,
+		"COMPOSTER_DATABASE_DSN": "env.db",
+		"COMPOSTER_LISTEN":       ":9001",
+	}
+	c, err := Load([]string{"-listen", ":9002", "-cookie-secure"}, testEnv(env))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	// Flag beats environment beats file beats default
+	if c.Server.Listen != ":9002" {
+		t.Errorf("Expected listen from flag, got %q", c.Server.Listen)
+	}
+	if c.Database.DSN != "env.db" {
+		t.Errorf("Expected DSN from environment, got %q", c.Database.DSN)
+	}
+	if c.Session.Store != "memory" || c.Session.IdleTimeout != time.Hour || c.Session.AbsoluteTimeout != 12*time.Hour {
+		t.Errorf("Expected session settings from file, got %+v", c.Session)
+	}
+	if len(c.OIDC.AdminGroups) != 2 || c.OIDC.AdminGroups[1] != "ops" {
+		t.Errorf("Expected admin groups from file, got %v", c.OIDC.AdminGroups)
+	}
+	if !c.Cookies.Secure {
+		t.Error("Expected boolean flag without a value to set cookie-secure")
+	}
+	if c.Auth.PasswordMinLength != 10 {
+		t.Errorf("Expected default password length, got %d", c.Auth.PasswordMinLength)
+	}
+
+	// The -config flag beats COMPOSTER_CONFIG
+	other := writeConfig(t, "[database]\ndsn = \"other.db\"\n")
+	delete(env, "COMPOSTER_DATABASE_DSN")
+	c, err = Load([]string{"-config", other}, testEnv(env))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if c.Database.DSN != "other.db" {
+		t.Errorf("Expected DSN from -config file, got %q", c.Database.DSN)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unknown key", nil, map[string]string{"COMPOSTER_CONFIG": writeConfig(t, "[server]\nlisen = \":80\"\n")}, "unknown keys server.lisen"},
+		{"missing file", []string{"-config", filepath.Join(dir, "missing.toml")}, nil, "missing.toml"},
+		{"bad env", nil, map[string]string{"COMPOSTER_PASSWORD_MIN_LENGTH": "ten"}, "COMPOSTER_PASSWORD_MIN_LENGTH"},
+		{"bad flag", []string{"-session-idle-timeout", "forever"}, nil, "session-idle-timeout"},
+		{"unknown flag", []string{"-verbose"}, nil, "verbose"},
+		{"bad listen", []string{"-listen", "8080"}, nil, "server.listen"},
+		{"missing dir", []string{"-static", filepath.Join(dir, "nope")}, nil, "server.static_dir"},
+		{"bad driver", []string{"-db-driver", "mysql"}, nil, "database.driver"},
+		{"bad store", []string{"-session-store", "redis"}, nil, "session.store"},
+		{"short absolute timeout", []string{"-session-idle-timeout", "2h", "-session-absolute-timeout", "1h"}, nil, "session.absolute_timeout"},
+		{"insecure SameSite=None", []string{"-cookie-samesite", "none"}, nil, "requires cookies.secure"},
+		{"bad password classes", []string{"-password-min-classes", "5"}, nil, "auth.password_min_classes"},
+		{"bad proxy", []string{"-trusted-proxies", "10.0.0.1,proxy.local"}, nil, "proxy.local"},
+		{"key without certificate", []string{"-tls-key", "key.pem"}, nil, "must be set together"},
+		{"missing certificate", []string{"-tls-cert", filepath.Join(dir, "cert.pem"), "-tls-key", filepath.Join(dir, "key.pem")}, nil, "tls.cert_file"},
+		{"redirect without TLS", []string{"-tls-redirect-listen", ":80"}, nil, "requires tls.cert_file"},
+		{"negative trash retention", []string{"-trash-retention", "-1h"}, nil, "trash.retention"},
+		{"incomplete OIDC", []string{"-oidc-issuer", "https://idp.example.com"}, nil, "oidc.client_id"},
+	}
+
+	for _, tt := range tests {
+		_, err := Load(tt.args, testEnv(tt.env))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error mentioning %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	_, err := Load([]string{"-listen", "nope", "-db", ""}, testEnv(nil))
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, want := range []string{"server.listen", "database.dsn"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	if _, err := Load([]string{"-help"}, testEnv(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestLoadAssetOverrides(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Load([]string{"-templates", dir, "-static", dir}, testEnv(nil))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if c.Server.TemplatesDir != dir || c.Server.StaticDir != dir {
+		t.Errorf("Expected overrides to be set, got %+v", c.Server)
+	}
+
+	file := filepath.Join(dir, "style.css")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := Load([]string{"-static", file}, testEnv(nil)); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Expected error for a file, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,27 +12,51 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// DB is a connection to a SQLite or PostgreSQL database. It implements
+// Store.
 type DB struct {
 	*sql.DB
+	dialect *dialect
+
+	// fts5 is set once the SQLite full-text search index is in use.
+	fts5 bool
 }
 
 type User struct {
-	ID        int
-	Username  string
-	Password  string
-	IsAdmin   bool
-	CreatedAt time.Time
+	ID       int
+	Username string
+	Password string
+	IsAdmin  bool
+	// MustChangePassword is set for accounts whose password was chosen by
+	// someone else. Such users can do nothing but pick a new one.
+	MustChangePassword bool
+	// TOTPEnabled is set once the user has enrolled an authenticator app,
+	// after which logging in takes a one-time code as well as the password.
+	TOTPEnabled bool
+	CreatedAt   time.Time
 }
 
 type Outline struct {
-	ID        int
-	UserID    int
-	Title     string
-	Content   string
+	ID      int
+	UserID  int
+	Title   string
+	Content string
+	// Version counts the saves that changed the outline. It is the version
+	// of the outline's newest revision.
+	Version   int
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// DeletedAt is when the outline was moved to the trash, or the zero
+	// time if it is not in the trash.
+	DeletedAt time.Time
+	// Role is the role on the outline of the user it was loaded for.
+	Role string
 }
 
+// ErrVersionConflict is returned by UpdateOutline when the outline has been
+// changed since the version the caller's edit is based on.
+var ErrVersionConflict = errors.New("outline has been changed since it was loaded")
+
 type Template struct {
 	ID          int
 	Name        string
@@ -40,6 +67,7 @@ type Template struct {
 	UserID      int // 0 for system templates
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	DeletedAt   time.Time // zero unless the template is in the trash
 }
 
 // Template categories
@@ -54,62 +82,48 @@ const (
 	CategoryBeginner     = "Beginner"
 )
 
+// New opens a SQLite database.
 func New(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+	return Open(SQLite, dataSourceName)
+}
+
+// Open connects to a database using driver, either SQLite or Postgres.
+func Open(driver, dataSourceName string) (*DB, error) {
+	d, err := lookupDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(d.driver, dataSourceName)
 	if err != nil {
 		return nil, err
 	}
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &DB{db}, nil
-}
-
-func (db *DB) Init() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL,
-		is_admin BOOLEAN DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS outlines (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		title TEXT NOT NULL,
-		content TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS templates (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		description TEXT NOT NULL,
-		content TEXT NOT NULL,
-		category TEXT NOT NULL,
-		is_system BOOLEAN DEFAULT 0,
-		user_id INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_outlines_user_id ON outlines(user_id);
-	CREATE INDEX IF NOT EXISTS idx_templates_category ON templates(category);
-	CREATE INDEX IF NOT EXISTS idx_templates_user_id ON templates(user_id);
-	CREATE INDEX IF NOT EXISTS idx_templates_is_system ON templates(is_system);
-	`
-
-	_, err := db.Exec(schema)
+	return &DB{DB: db, dialect: d}, nil
+}
+
+// Driver returns the name of the database's backend.
+func (db *DB) Driver() string {
+	return db.dialect.name
+}
+
+// Init brings the schema up to date and seeds system templates. On first
+// run it also creates an "admin" user with adminPassword, or with a random
+// password printed to stdout if adminPassword is empty. Either way the admin
+// must choose a new password at first login.
+func (db *DB) Init(adminPassword string) error {
+	applied, err := db.Migrate()
 	if err != nil {
 		return err
 	}
+	for _, m := range applied {
+		fmt.Printf("Applied migration %04d_%s\n", m.Version, m.Name)
+	}
 
 	// Create default admin user if no users exist
 	var count int
@@ -119,17 +133,23 @@ func (db *DB) Init() error {
 	}
 
 	if count == 0 {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
-		if err != nil {
-			return err
+		generated := adminPassword == ""
+		if generated {
+			adminPassword, err = randomPassword()
+			if err != nil {
+				return err
+			}
 		}
 
-		_, err = db.Exec("INSERT INTO users (username, password, is_admin) VALUES (?, ?, ?)",
-			"admin", string(hashedPassword), true)
-		if err != nil {
+		if err := db.CreateUser("admin", adminPassword, true, true); err != nil {
 			return err
 		}
-		fmt.Println("Created default admin user (username: admin, password: admin)")
+		if generated {
+			fmt.Printf("Created admin user (username: admin, password: %s)\n", adminPassword)
+			fmt.Println("This password is shown only once and must be changed at first login")
+		} else {
+			fmt.Println("Created admin user (username: admin) with the configured initial password")
+		}
 	}
 
 	// Seed system templates
@@ -137,43 +157,68 @@ func (db *DB) Init() error {
 		return err
 	}
 
+	if err := db.initSummaries(); err != nil {
+		return fmt.Errorf("summarizing outlines and templates: %w", err)
+	}
+
+	if err := db.initSearch(); err != nil {
+		return fmt.Errorf("setting up search: %w", err)
+	}
+
 	return nil
 }
 
+// randomPassword returns a password suitable for a freshly created account.
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // User methods
-func (db *DB) CreateUser(username, password string, isAdmin bool) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+
+// CreateUser creates a user. mustChangePassword is set in the same insert, so
+// the account never exists without it. A user created with an empty password
+// has none, and can only log in through single sign-on.
+func (db *DB) CreateUser(username, password string, isAdmin, mustChangePassword bool) error {
+	var hashedPassword []byte
+	if password != "" {
+		var err error
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err = db.Exec("INSERT INTO users (username, password, is_admin) VALUES (?, ?, ?)",
-		username, string(hashedPassword), isAdmin)
+	_, err := db.Exec("INSERT INTO users (username, password, is_admin, must_change_password) VALUES (?, ?, ?, ?)",
+		username, string(hashedPassword), isAdmin, mustChangePassword)
 	return err
 }
 
-func (db *DB) GetUser(username string) (*User, error) {
+// userColumns are the users columns scanUser reads, in order.
+const userColumns = "id, username, password, is_admin, must_change_password, totp_enabled, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
 	user := &User{}
-	err := db.QueryRow("SELECT id, username, password, is_admin, created_at FROM users WHERE username = ?",
-		username).Scan(&user.ID, &user.Username, &user.Password, &user.IsAdmin, &user.CreatedAt)
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.IsAdmin, &user.MustChangePassword, &user.TOTPEnabled, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
+func (db *DB) GetUser(username string) (*User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
 func (db *DB) GetUserByID(id int) (*User, error) {
-	user := &User{}
-	err := db.QueryRow("SELECT id, username, password, is_admin, created_at FROM users WHERE id = ?",
-		id).Scan(&user.ID, &user.Username, &user.Password, &user.IsAdmin, &user.CreatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 }
 
 func (db *DB) GetAllUsers() ([]User, error) {
-	rows, err := db.Query("SELECT id, username, password, is_admin, created_at FROM users ORDER BY username")
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
 	if err != nil {
 		return nil, err
 	}
@@ -181,23 +226,26 @@ func (db *DB) GetAllUsers() ([]User, error) {
 
 	var users []User
 	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Password, &user.IsAdmin, &user.CreatedAt); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
 	return users, nil
 }
 
-func (db *DB) UpdateUser(id int, username string, password string, isAdmin bool) error {
+// UpdateUser changes a user's username and admin rights, and their password
+// unless it is empty. A new password sets must_change_password to
+// mustChangePassword in the same update; otherwise the flag is left alone.
+func (db *DB) UpdateUser(id int, username string, password string, isAdmin, mustChangePassword bool) error {
 	if password != "" {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
 			return err
 		}
-		_, err = db.Exec("UPDATE users SET username = ?, password = ?, is_admin = ? WHERE id = ?",
-			username, string(hashedPassword), isAdmin, id)
+		_, err = db.Exec("UPDATE users SET username = ?, password = ?, is_admin = ?, must_change_password = ? WHERE id = ?",
+			username, string(hashedPassword), isAdmin, mustChangePassword, id)
 		return err
 	}
 
@@ -206,8 +254,57 @@ func (db *DB) UpdateUser(id int, username string, password string, isAdmin bool)
 	return err
 }
 
+// DeleteUser deletes a user and moves their outlines and templates to the
+// trash, where an admin can recover them until they are purged.
 func (db *DB) DeleteUser(id int) error {
-	_, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := trashUserContent(tx, id, time.Now()); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM outline_shares WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM api_tokens WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_identities WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetMustChangePassword sets whether a user must choose a new password
+// before doing anything else.
+func (db *DB) SetMustChangePassword(id int, must bool) error {
+	_, err := db.Exec("UPDATE users SET must_change_password = ? WHERE id = ?", must, id)
+	return err
+}
+
+// ChangePassword sets a password the user chose themselves, lifting any
+// requirement to change it.
+func (db *DB) ChangePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET password = ?, must_change_password = FALSE WHERE id = ?",
+		string(hashedPassword), id)
 	return err
 }
 
@@ -227,18 +324,36 @@ func (db *DB) VerifyPassword(username, password string) (*User, error) {
 
 // Outline methods
 func (db *DB) CreateOutline(userID int, title, content string) (int64, error) {
-	result, err := db.Exec("INSERT INTO outlines (user_id, title, content) VALUES (?, ?, ?)",
-		userID, title, content)
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	defer tx.Rollback()
+
+	s := summarize(content)
+	var id int64
+	err = tx.QueryRow("INSERT INTO outlines (user_id, title, content, node_count, depth, preview) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		userID, title, content, s.nodeCount, s.depth, s.preview).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := addRevision(tx, id, userID, 1, title, content); err != nil {
+		return 0, err
+	}
+	if err := indexItem(tx, SearchOutline, id, title, content); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
+// GetOutline returns an outline the user owns or has had shared with them,
+// with their Role on it, or sql.ErrNoRows if there is no such outline.
 func (db *DB) GetOutline(id, userID int) (*Outline, error) {
 	outline := &Outline{}
-	err := db.QueryRow("SELECT id, user_id, title, content, created_at, updated_at FROM outlines WHERE id = ? AND user_id = ?",
-		id, userID).Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Content, &outline.CreatedAt, &outline.UpdatedAt)
+	err := db.QueryRow(`SELECT o.id, o.user_id, o.title, o.content, o.version, o.created_at, o.updated_at, CASE WHEN o.user_id = ? THEN 'owner' ELSE s.role END
+		FROM outlines o LEFT JOIN outline_shares s ON s.outline_id = o.id AND s.user_id = ?
+		WHERE o.id = ? AND o.deleted_at IS NULL AND (o.user_id = ? OR s.user_id IS NOT NULL)`,
+		userID, userID, id, userID).Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Content, &outline.Version, &outline.CreatedAt, &outline.UpdatedAt, &outline.Role)
 	if err != nil {
 		return nil, err
 	}
@@ -246,7 +361,7 @@ func (db *DB) GetOutline(id, userID int) (*Outline, error) {
 }
 
 func (db *DB) GetUserOutlines(userID int) ([]Outline, error) {
-	rows, err := db.Query("SELECT id, user_id, title, content, created_at, updated_at FROM outlines WHERE user_id = ? ORDER BY updated_at DESC",
+	rows, err := db.Query("SELECT id, user_id, title, content, version, created_at, updated_at FROM outlines WHERE user_id = ? AND deleted_at IS NULL ORDER BY updated_at DESC",
 		userID)
 	if err != nil {
 		return nil, err
@@ -256,7 +371,7 @@ func (db *DB) GetUserOutlines(userID int) ([]Outline, error) {
 	var outlines []Outline
 	for rows.Next() {
 		var outline Outline
-		if err := rows.Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Content, &outline.CreatedAt, &outline.UpdatedAt); err != nil {
+		if err := rows.Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Content, &outline.Version, &outline.CreatedAt, &outline.UpdatedAt); err != nil {
 			return nil, err
 		}
 		outlines = append(outlines, outline)
@@ -264,30 +379,106 @@ func (db *DB) GetUserOutlines(userID int) ([]Outline, error) {
 	return outlines, nil
 }
 
-func (db *DB) UpdateOutline(id, userID int, title, content string) error {
-	_, err := db.Exec("UPDATE outlines SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?",
-		title, content, id, userID)
-	return err
+func (db *DB) CountUserOutlines(userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM outlines WHERE user_id = ? AND deleted_at IS NULL", userID).Scan(&count)
+	return count, err
 }
 
+// UpdateOutline saves a new title and content for an outline and returns
+// its version afterwards. If either has changed, a revision is recorded and
+// the version goes up by one. The edit must be based on the current
+// version, or ErrVersionConflict is returned; pass version 0 to save
+// regardless. Saving the current title and content is never a conflict. The
+// user saving must have at least RoleEditor, or ErrForbidden is returned. It
+// returns sql.ErrNoRows if the user has no access to such an outline.
+func (db *DB) UpdateOutline(id, userID, version int, title, content string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := requireRole(tx, id, userID, RoleEditor); err != nil {
+		return 0, err
+	}
+
+	var oldTitle, oldContent string
+	var current int
+	err = tx.QueryRow("SELECT title, content, version FROM outlines WHERE id = ?", id).Scan(&oldTitle, &oldContent, &current)
+	if err != nil {
+		return 0, err
+	}
+	if title == oldTitle && content == oldContent {
+		return current, nil
+	}
+	if version != 0 && version != current {
+		return current, ErrVersionConflict
+	}
+
+	// The version check is repeated here in case another save got in first
+	s := summarize(content)
+	result, err := tx.Exec("UPDATE outlines SET title = ?, content = ?, node_count = ?, depth = ?, preview = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+		title, content, s.nodeCount, s.depth, s.preview, id, current)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return current, ErrVersionConflict
+	}
+	if err := addRevision(tx, int64(id), userID, current+1, title, content); err != nil {
+		return 0, err
+	}
+	if err := indexItem(tx, SearchOutline, int64(id), title, content); err != nil {
+		return 0, err
+	}
+	return current + 1, tx.Commit()
+}
+
+// DeleteOutline moves an outline to its owner's trash. Use PurgeOutline to
+// delete it for good. The user deleting it must own it; anyone it is shared
+// with, even as RoleOwner, gets ErrForbidden. It returns sql.ErrNoRows if the user has no
+// access to such an outline.
 func (db *DB) DeleteOutline(id, userID int) error {
-	_, err := db.Exec("DELETE FROM outlines WHERE id = ? AND user_id = ?", id, userID)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireOwner(tx, id, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE outlines SET deleted_at = ? WHERE id = ?", time.Now().Unix(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Template methods
 func (db *DB) CreateTemplate(name, description, content, category string, isSystem bool, userID int) (int64, error) {
-	result, err := db.Exec("INSERT INTO templates (name, description, content, category, is_system, user_id) VALUES (?, ?, ?, ?, ?, ?)",
-		name, description, content, category, isSystem, userID)
+	tx, err := db.Begin()
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	defer tx.Rollback()
+
+	s := summarize(content)
+	var id int64
+	err = tx.QueryRow("INSERT INTO templates (name, description, content, category, is_system, user_id, node_count, depth, preview) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		name, description, content, category, isSystem, userID, s.nodeCount, s.depth, s.preview).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := indexItem(tx, SearchTemplate, id, name, content); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
 }
 
 func (db *DB) GetTemplate(id int) (*Template, error) {
 	template := &Template{}
-	err := db.QueryRow("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE id = ?",
+	err := db.QueryRow("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE id = ? AND deleted_at IS NULL",
 		id).Scan(&template.ID, &template.Name, &template.Description, &template.Content, &template.Category, &template.IsSystem, &template.UserID, &template.CreatedAt, &template.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -296,7 +487,7 @@ func (db *DB) GetTemplate(id int) (*Template, error) {
 }
 
 func (db *DB) GetAllTemplates() ([]Template, error) {
-	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates ORDER BY is_system DESC, category, name")
+	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE deleted_at IS NULL ORDER BY is_system DESC, category, name")
 	if err != nil {
 		return nil, err
 	}
@@ -314,7 +505,7 @@ func (db *DB) GetAllTemplates() ([]Template, error) {
 }
 
 func (db *DB) GetSystemTemplates() ([]Template, error) {
-	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE is_system = 1 ORDER BY category, name")
+	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE is_system = TRUE AND deleted_at IS NULL ORDER BY category, name")
 	if err != nil {
 		return nil, err
 	}
@@ -332,7 +523,7 @@ func (db *DB) GetSystemTemplates() ([]Template, error) {
 }
 
 func (db *DB) GetUserTemplates(userID int) ([]Template, error) {
-	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE user_id = ? ORDER BY category, name",
+	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE user_id = ? AND deleted_at IS NULL ORDER BY category, name",
 		userID)
 	if err != nil {
 		return nil, err
@@ -351,7 +542,7 @@ func (db *DB) GetUserTemplates(userID int) ([]Template, error) {
 }
 
 func (db *DB) GetTemplatesByCategory(category string) ([]Template, error) {
-	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE category = ? ORDER BY is_system DESC, name",
+	rows, err := db.Query("SELECT id, name, description, content, category, is_system, user_id, created_at, updated_at FROM templates WHERE category = ? AND deleted_at IS NULL ORDER BY is_system DESC, name",
 		category)
 	if err != nil {
 		return nil, err
@@ -370,13 +561,32 @@ func (db *DB) GetTemplatesByCategory(category string) ([]Template, error) {
 }
 
 func (db *DB) UpdateTemplate(id int, name, description, content, category string, userID int) error {
-	_, err := db.Exec("UPDATE templates SET name = ?, description = ?, content = ?, category = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND is_system = 0",
-		name, description, content, category, id, userID)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	s := summarize(content)
+	result, err := tx.Exec("UPDATE templates SET name = ?, description = ?, content = ?, category = ?, node_count = ?, depth = ?, preview = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND is_system = FALSE AND deleted_at IS NULL",
+		name, description, content, category, s.nodeCount, s.depth, s.preview, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := indexItem(tx, SearchTemplate, int64(id), name, content); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// DeleteTemplate moves one of a user's templates to the trash. System
+// templates cannot be deleted.
 func (db *DB) DeleteTemplate(id, userID int) error {
-	_, err := db.Exec("DELETE FROM templates WHERE id = ? AND user_id = ? AND is_system = 0", id, userID)
+	_, err := db.Exec("UPDATE templates SET deleted_at = ? WHERE id = ? AND user_id = ? AND is_system = FALSE AND deleted_at IS NULL",
+		time.Now().Unix(), id, userID)
 	return err
 }
 
@@ -944,7 +1154,7 @@ func (db *DB) SeedSystemTemplates() error {
 	for _, tmpl := range templates {
 		// Check if template already exists
 		var count int
-		err := db.QueryRow("SELECT COUNT(*) FROM templates WHERE name = ? AND is_system = 1", tmpl.name).Scan(&count)
+		err := db.QueryRow("SELECT COUNT(*) FROM templates WHERE name = ? AND is_system = TRUE", tmpl.name).Scan(&count)
 		if err != nil {
 			return fmt.Errorf("failed to check template %s: %w", tmpl.name, err)
 		}
@@ -1,11 +1,90 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 )
 
+// testDriver is the backend the tests run against. TestMain runs the suite
+// against SQLite, then again against PostgreSQL if
+// COMPOSTER_TEST_POSTGRES_DSN names a database the tests may use. Each test
+// gets its own schema there, which is dropped and recreated every run.
+var testDriver = SQLite
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if dsn := os.Getenv("COMPOSTER_TEST_POSTGRES_DSN"); dsn != "" && code == 0 {
+		fmt.Println("Running tests against PostgreSQL")
+		testDriver = Postgres
+		code = m.Run()
+	}
+	os.Exit(code)
+}
+
+// newTestDB returns an empty database called name on the backend under
+// test, removed once the test is done.
+func newTestDB(t *testing.T, name string) *DB {
+	t.Helper()
+
+	var db *DB
+	var err error
+	switch testDriver {
+	case SQLite:
+		dbPath := "/tmp/test_composter_" + name + ".db"
+		os.Remove(dbPath)
+		t.Cleanup(func() { os.Remove(dbPath) })
+		db, err = New(dbPath)
+	case Postgres:
+		db, err = openTestSchema(os.Getenv("COMPOSTER_TEST_POSTGRES_DSN"), "composter_test_"+name)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openTestSchema recreates schema in the PostgreSQL database at dsn and
+// connects to it with schema as the search path.
+func openTestSchema(dsn, schema string) (*DB, error) {
+	admin, err := Open(Postgres, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE; CREATE SCHEMA " + schema); err != nil {
+		return nil, err
+	}
+
+	// Unknown connection parameters are sent to the server as settings
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+	return Open(Postgres, dsn)
+}
+
+// skipUnlessSQLite skips tests of SQLite-specific behaviour on other
+// backends.
+func skipUnlessSQLite(t *testing.T) {
+	t.Helper()
+	if testDriver != SQLite {
+		t.Skip("SQLite only")
+	}
+}
+
 func TestNew(t *testing.T) {
+	skipUnlessSQLite(t)
+
 	// Create a temporary database file
 	dbPath := "/tmp/test_composter.db"
 	defer os.Remove(dbPath)
@@ -23,17 +102,10 @@ func TestNew(t *testing.T) {
 }
 
 func TestInit(t *testing.T) {
-	dbPath := "/tmp/test_composter_init.db"
-	defer os.Remove(dbPath)
-
-	db, err := New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
-	}
-	defer db.Close()
+	db := newTestDB(t, "init")
 
 	// Initialize the database
-	if err := db.Init(); err != nil {
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -50,10 +122,66 @@ func TestInit(t *testing.T) {
 	if !user.IsAdmin {
 		t.Error("Expected admin user to have IsAdmin = true")
 	}
+
+	if !user.MustChangePassword {
+		t.Error("Expected admin user to have to change password")
+	}
 }
 
-func TestCreateAndGetUser(t *testing.T) {
-	dbPath := "/tmp/test_composter_user.db"
+func TestInitGeneratesAdminPassword(t *testing.T) {
+	db := newTestDB(t, "init_generated")
+
+	if err := db.Init(""); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	if _, err := db.VerifyPassword("admin", "admin"); err == nil {
+		t.Error("Expected a generated admin password rather than 'admin'")
+	}
+	if _, err := db.VerifyPassword("admin", ""); err == nil {
+		t.Error("Expected a non-empty admin password")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db := newTestDB(t, "change_password")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	if err := db.ChangePassword(admin.ID, "a new passphrase"); err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+
+	user, err := db.VerifyPassword("admin", "a new passphrase")
+	if err != nil {
+		t.Fatalf("Failed to verify new password: %v", err)
+	}
+	if user.MustChangePassword {
+		t.Error("Expected password change to clear MustChangePassword")
+	}
+
+	if err := db.SetMustChangePassword(admin.ID, true); err != nil {
+		t.Fatalf("Failed to set MustChangePassword: %v", err)
+	}
+	user, err = db.GetUserByID(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if !user.MustChangePassword {
+		t.Error("Expected MustChangePassword to be set")
+	}
+}
+
+func TestInitAddsMissingColumns(t *testing.T) {
+	skipUnlessSQLite(t)
+	dbPath := "/tmp/test_composter_init_upgrade.db"
 	defer os.Remove(dbPath)
 
 	db, err := New(dbPath)
@@ -62,14 +190,38 @@ func TestCreateAndGetUser(t *testing.T) {
 	}
 	defer db.Close()
 
-	if err := db.Init(); err != nil {
+	// A users table as created by earlier versions
+	_, err = db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password TEXT NOT NULL,
+		is_admin BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create old users table: %v", err)
+	}
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	if _, err := db.GetUser("admin"); err != nil {
+		t.Errorf("Failed to get admin user after upgrade: %v", err)
+	}
+}
+
+func TestCreateAndGetUser(t *testing.T) {
+	db := newTestDB(t, "user")
+
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
 	// Create a new user
 	username := "testuser"
 	password := "testpass"
-	err = db.CreateUser(username, password, false)
+	err := db.CreateUser(username, password, false, false)
 	if err != nil {
 		t.Fatalf("Failed to create user: %v", err)
 	}
@@ -87,19 +239,38 @@ func TestCreateAndGetUser(t *testing.T) {
 	if user.IsAdmin {
 		t.Error("Expected non-admin user to have IsAdmin = false")
 	}
-}
+	if user.MustChangePassword {
+		t.Error("Expected MustChangePassword to be clear")
+	}
 
-func TestVerifyPassword(t *testing.T) {
-	dbPath := "/tmp/test_composter_password.db"
-	defer os.Remove(dbPath)
+	// The flag can be set as the user is created
+	if err := db.CreateUser("newhire", password, false, true); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if user, _ := db.GetUser("newhire"); user == nil || !user.MustChangePassword {
+		t.Errorf("Expected MustChangePassword to be set, got %+v", user)
+	}
 
-	db, err := New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
+	// A new password sets the flag with it; otherwise it is left alone
+	if err := db.UpdateUser(user.ID, username, "newpass", false, true); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
 	}
-	defer db.Close()
+	if err := db.UpdateUser(user.ID, username, "", false, false); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	user, _ = db.GetUser(username)
+	if !user.MustChangePassword {
+		t.Error("Expected MustChangePassword to be set with the new password")
+	}
+	if _, err := db.VerifyPassword(username, "newpass"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	db := newTestDB(t, "password")
 
-	if err := db.Init(); err != nil {
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -121,16 +292,9 @@ func TestVerifyPassword(t *testing.T) {
 }
 
 func TestCreateAndGetOutline(t *testing.T) {
-	dbPath := "/tmp/test_composter_outline.db"
-	defer os.Remove(dbPath)
-
-	db, err := New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
-	}
-	defer db.Close()
+	db := newTestDB(t, "outline")
 
-	if err := db.Init(); err != nil {
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -172,16 +336,9 @@ func TestCreateAndGetOutline(t *testing.T) {
 }
 
 func TestUpdateOutline(t *testing.T) {
-	dbPath := "/tmp/test_composter_update.db"
-	defer os.Remove(dbPath)
+	db := newTestDB(t, "update")
 
-	db, err := New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
-	}
-	defer db.Close()
-
-	if err := db.Init(); err != nil {
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -201,10 +358,13 @@ func TestUpdateOutline(t *testing.T) {
 	// Update the outline
 	newTitle := "Updated Title"
 	newContent := "<div>Updated Content</div>"
-	err = db.UpdateOutline(int(id), user.ID, newTitle, newContent)
+	version, err := db.UpdateOutline(int(id), user.ID, 1, newTitle, newContent)
 	if err != nil {
 		t.Fatalf("Failed to update outline: %v", err)
 	}
+	if version != 2 {
+		t.Errorf("Expected version 2, got %d", version)
+	}
 
 	// Verify the update
 	outline, err := db.GetOutline(int(id), user.ID)
@@ -219,19 +379,67 @@ func TestUpdateOutline(t *testing.T) {
 	if outline.Content != newContent {
 		t.Errorf("Expected content '%s', got '%s'", newContent, outline.Content)
 	}
+
+	if outline.Version != 2 {
+		t.Errorf("Expected version 2, got %d", outline.Version)
+	}
 }
 
-func TestDeleteOutline(t *testing.T) {
-	dbPath := "/tmp/test_composter_delete.db"
-	defer os.Remove(dbPath)
+func TestUpdateOutlineVersionConflict(t *testing.T) {
+	db := newTestDB(t, "update_conflict")
 
-	db, err := New(dbPath)
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	user, err := db.GetUser("admin")
 	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	id, err := db.CreateOutline(user.ID, "Plan", "<div>One</div>")
+	if err != nil {
+		t.Fatalf("Failed to create outline: %v", err)
+	}
+
+	// Two editors load version 1; the first to save wins
+	if version, err := db.UpdateOutline(int(id), user.ID, 1, "Plan", "<div>First</div>"); err != nil || version != 2 {
+		t.Fatalf("Expected first save to make version 2, got %d, %v", version, err)
+	}
+	version, err := db.UpdateOutline(int(id), user.ID, 1, "Plan", "<div>Second</div>")
+	if err != ErrVersionConflict || version != 2 {
+		t.Errorf("Expected ErrVersionConflict at version 2, got %d, %v", version, err)
+	}
+
+	outline, _ := db.GetOutline(int(id), user.ID)
+	if outline.Content != "<div>First</div>" {
+		t.Errorf("Expected the first save to be kept, got %q", outline.Content)
+	}
+
+	// Saving what is already there is not a conflict, and changes nothing
+	if version, err := db.UpdateOutline(int(id), user.ID, 1, "Plan", "<div>First</div>"); err != nil || version != 2 {
+		t.Errorf("Expected unchanged save to succeed at version 2, got %d, %v", version, err)
+	}
+
+	// Version 0 saves regardless
+	if version, err := db.UpdateOutline(int(id), user.ID, 0, "Plan", "<div>Forced</div>"); err != nil || version != 3 {
+		t.Errorf("Expected forced save to make version 3, got %d, %v", version, err)
 	}
-	defer db.Close()
 
-	if err := db.Init(); err != nil {
+	base, err := db.GetOutlineVersion(int(id), user.ID, 2)
+	if err != nil || base.Content != "<div>First</div>" {
+		t.Errorf("Expected version 2 to be the first save, got %+v, %v", base, err)
+	}
+
+	if _, err := db.UpdateOutline(int(id)+1, user.ID, 1, "Plan", ""); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a missing outline, got %v", err)
+	}
+}
+
+func TestDeleteOutline(t *testing.T) {
+	db := newTestDB(t, "delete")
+
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -259,17 +467,297 @@ func TestDeleteOutline(t *testing.T) {
 	}
 }
 
-func TestGetUserOutlines(t *testing.T) {
-	dbPath := "/tmp/test_composter_list.db"
-	defer os.Remove(dbPath)
+func TestTrash(t *testing.T) {
+	db := newTestDB(t, "trash")
 
-	db, err := New(dbPath)
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+
+	kept, _ := db.CreateOutline(user.ID, "Kept", "<div>Kept</div>")
+	trashed, _ := db.CreateOutline(user.ID, "Trashed", "<div>Trashed</div>")
+	templateID, err := db.CreateTemplate("Mine", "", "<div>Step</div>", CategoryGeneral, false, user.ID)
 	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
+		t.Fatalf("Failed to create template: %v", err)
 	}
-	defer db.Close()
 
-	if err := db.Init(); err != nil {
+	if err := db.DeleteOutline(int(trashed), user.ID); err != nil {
+		t.Fatalf("Failed to delete outline: %v", err)
+	}
+	if err := db.DeleteTemplate(int(templateID), user.ID); err != nil {
+		t.Fatalf("Failed to delete template: %v", err)
+	}
+
+	// Deleted items are hidden everywhere but the trash
+	if outlines, _ := db.GetUserOutlines(user.ID); len(outlines) != 1 || outlines[0].ID != int(kept) {
+		t.Errorf("Expected only the kept outline to be listed, got %+v", outlines)
+	}
+	if count, _ := db.CountUserOutlines(user.ID); count != 1 {
+		t.Errorf("Expected 1 outline to be counted, got %d", count)
+	}
+	if _, err := db.UpdateOutline(int(trashed), user.ID, 0, "Edited", ""); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows saving a deleted outline, got %v", err)
+	}
+	if _, err := db.GetTemplate(int(templateID)); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for a deleted template, got %v", err)
+	}
+	if templates, _ := db.GetUserTemplates(user.ID); len(templates) != 0 {
+		t.Errorf("Expected no templates to be listed, got %+v", templates)
+	}
+
+	outlines, err := db.GetTrashedOutlines(user.ID)
+	if err != nil || len(outlines) != 1 || outlines[0].ID != int(trashed) || outlines[0].DeletedAt.IsZero() {
+		t.Fatalf("Expected the deleted outline in the trash, got %+v, %v", outlines, err)
+	}
+	templates, err := db.GetTrashedTemplates(user.ID)
+	if err != nil || len(templates) != 1 || templates[0].Name != "Mine" {
+		t.Fatalf("Expected the deleted template in the trash, got %+v, %v", templates, err)
+	}
+
+	// Another user can neither see nor restore them
+	db.CreateUser("other", "password", false, false)
+	other, _ := db.GetUser("other")
+	if outlines, _ := db.GetTrashedOutlines(other.ID); len(outlines) != 0 {
+		t.Errorf("Expected another user's trash to be empty, got %+v", outlines)
+	}
+	if err := db.RestoreOutline(int(trashed), other.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows restoring another user's outline, got %v", err)
+	}
+	if err := db.PurgeOutline(int(trashed), other.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows purging another user's outline, got %v", err)
+	}
+
+	// Only items in the trash can be restored or purged
+	if err := db.RestoreOutline(int(kept), user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows restoring an outline not in the trash, got %v", err)
+	}
+	if err := db.PurgeOutline(int(kept), user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows purging an outline not in the trash, got %v", err)
+	}
+
+	if err := db.RestoreOutline(int(trashed), user.ID); err != nil {
+		t.Fatalf("Failed to restore outline: %v", err)
+	}
+	outline, err := db.GetOutline(int(trashed), user.ID)
+	if err != nil || outline.Title != "Trashed" || !outline.DeletedAt.IsZero() {
+		t.Errorf("Expected the outline to be back, got %+v, %v", outline, err)
+	}
+	if revisions, _ := db.GetOutlineRevisions(int(trashed), user.ID); len(revisions) != 1 {
+		t.Errorf("Expected the restored outline to keep its history, got %d revisions", len(revisions))
+	}
+
+	if err := db.PurgeTemplate(int(templateID), user.ID); err != nil {
+		t.Fatalf("Failed to purge template: %v", err)
+	}
+	if err := db.RestoreTemplate(int(templateID), user.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows restoring a purged template, got %v", err)
+	}
+
+	db.DeleteOutline(int(kept), user.ID)
+	db.DeleteOutline(int(trashed), user.ID)
+	if err := db.EmptyTrash(user.ID); err != nil {
+		t.Fatalf("Failed to empty trash: %v", err)
+	}
+	if outlines, _ := db.GetTrashedOutlines(user.ID); len(outlines) != 0 {
+		t.Errorf("Expected the trash to be empty, got %+v", outlines)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outline_revisions WHERE outline_id IN (?, ?)", kept, trashed).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected revisions to be deleted, got %d, %v", count, err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := newTestDB(t, "purge_trash")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+
+	old, _ := db.CreateOutline(user.ID, "Old", "")
+	recent, _ := db.CreateOutline(user.ID, "Recent", "")
+	db.DeleteOutline(int(old), user.ID)
+	db.DeleteOutline(int(recent), user.ID)
+
+	now := time.Now()
+	if _, err := db.Exec("UPDATE outlines SET deleted_at = ? WHERE id = ?", now.Add(-48*time.Hour).Unix(), old); err != nil {
+		t.Fatalf("Failed to backdate outline: %v", err)
+	}
+
+	n, err := db.PurgeTrash(now.Add(-24 * time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 item purged, got %d, %v", n, err)
+	}
+	outlines, _ := db.GetTrashedOutlines(user.ID)
+	if len(outlines) != 1 || outlines[0].ID != int(recent) {
+		t.Errorf("Expected only the recently deleted outline to be left, got %+v", outlines)
+	}
+}
+
+func TestDeleteUserKeepsContent(t *testing.T) {
+	db := newTestDB(t, "deleted_users")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	admin, _ := db.GetUser("admin")
+	db.CreateUser("leaver", "password", false, false)
+	leaver, _ := db.GetUser("leaver")
+	db.CreateUser("empty", "password", false, false)
+	empty, _ := db.GetUser("empty")
+
+	outlineID, _ := db.CreateOutline(leaver.ID, "Handover", "<div>Notes</div>")
+	db.CreateTemplate("Leaver's", "", "", CategoryGeneral, false, leaver.ID)
+
+	if err := db.DeleteUser(leaver.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if err := db.DeleteUser(empty.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	// Only users who left something behind are listed
+	deleted, err := db.GetDeletedUsers()
+	if err != nil || len(deleted) != 1 {
+		t.Fatalf("Expected 1 deleted user, got %+v, %v", deleted, err)
+	}
+	if d := deleted[0]; d.ID != leaver.ID || d.Username != "leaver" || d.Outlines != 1 || d.Templates != 1 {
+		t.Errorf("Unexpected deleted user %+v", d)
+	}
+
+	if err := db.RecoverDeletedUser(leaver.ID, 9999); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows recovering to a missing user, got %v", err)
+	}
+	if err := db.RecoverDeletedUser(leaver.ID, admin.ID); err != nil {
+		t.Fatalf("Failed to recover content: %v", err)
+	}
+	if deleted, _ := db.GetDeletedUsers(); len(deleted) != 0 {
+		t.Errorf("Expected no deleted users left, got %+v", deleted)
+	}
+
+	// The recovered items arrive in the recipient's trash
+	outlines, _ := db.GetTrashedOutlines(admin.ID)
+	if len(outlines) != 1 || outlines[0].ID != int(outlineID) {
+		t.Fatalf("Expected the outline in the admin's trash, got %+v", outlines)
+	}
+	if templates, _ := db.GetTrashedTemplates(admin.ID); len(templates) != 1 {
+		t.Errorf("Expected the template in the admin's trash, got %+v", templates)
+	}
+	if err := db.RestoreOutline(int(outlineID), admin.ID); err != nil {
+		t.Fatalf("Failed to restore outline: %v", err)
+	}
+	revisions, err := db.GetOutlineRevisions(int(outlineID), admin.ID)
+	if err != nil || len(revisions) != 1 || revisions[0].Author != "" {
+		t.Errorf("Expected the history to be kept with its author gone, got %+v, %v", revisions, err)
+	}
+}
+
+func TestPurgeDeletedUser(t *testing.T) {
+	db := newTestDB(t, "purge_deleted_user")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	db.CreateUser("leaver", "password", false, false)
+	leaver, _ := db.GetUser("leaver")
+	id, _ := db.CreateOutline(leaver.ID, "Gone", "")
+	db.DeleteUser(leaver.ID)
+
+	if err := db.PurgeDeletedUser(leaver.ID); err != nil {
+		t.Fatalf("Failed to purge deleted user: %v", err)
+	}
+	if err := db.PurgeDeletedUser(leaver.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows purging twice, got %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outlines WHERE id = ?", id).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected the outline to be deleted, got %d, %v", count, err)
+	}
+}
+
+func TestOutlineRevisions(t *testing.T) {
+	db := newTestDB(t, "revisions")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	user, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	id, err := db.CreateOutline(user.ID, "Plan", "<div>One</div>")
+	if err != nil {
+		t.Fatalf("Failed to create outline: %v", err)
+	}
+
+	// Saving unchanged content records nothing
+	for _, content := range []string{"<div>One</div><div>Two</div>", "<div>One</div><div>Two</div>"} {
+		if _, err := db.UpdateOutline(int(id), user.ID, 0, "Plan", content); err != nil {
+			t.Fatalf("Failed to update outline: %v", err)
+		}
+	}
+
+	revisions, err := db.GetOutlineRevisions(int(id), user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get revisions: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d", len(revisions))
+	}
+	if revisions[0].Size != len("<div>One</div><div>Two</div>") || revisions[0].Author != "admin" || revisions[0].Content != "" {
+		t.Errorf("Unexpected newest revision %+v", revisions[0])
+	}
+
+	first, err := db.GetOutlineRevision(revisions[1].ID, int(id), user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get revision: %v", err)
+	}
+	if first.Title != "Plan" || first.Content != "<div>One</div>" {
+		t.Errorf("Unexpected first revision %+v", first)
+	}
+
+	// Other users cannot see the history
+	if err := db.CreateUser("other", "password", false, false); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	other, _ := db.GetUser("other")
+	if revisions, err := db.GetOutlineRevisions(int(id), other.ID); err != nil || len(revisions) != 0 {
+		t.Errorf("Expected no revisions for another user, got %d, %v", len(revisions), err)
+	}
+	if _, err := db.GetOutlineRevision(first.ID, int(id), other.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for another user's revision, got %v", err)
+	}
+	if _, err := db.UpdateOutline(int(id), other.ID, 0, "Stolen", ""); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for another user's outline, got %v", err)
+	}
+	if revisions, _ := db.GetOutlineRevisions(int(id), user.ID); len(revisions) != 2 {
+		t.Errorf("Expected another user's save to record nothing, got %d revisions", len(revisions))
+	}
+
+	// Deleting the outline hides its history, and purging it deletes it
+	if err := db.DeleteOutline(int(id), user.ID); err != nil {
+		t.Fatalf("Failed to delete outline: %v", err)
+	}
+	if revisions, err := db.GetOutlineRevisions(int(id), user.ID); err != nil || len(revisions) != 0 {
+		t.Errorf("Expected no revisions for a deleted outline, got %d, %v", len(revisions), err)
+	}
+	if err := db.PurgeOutline(int(id), user.ID); err != nil {
+		t.Fatalf("Failed to purge outline: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outline_revisions WHERE outline_id = ?", id).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected revisions to be deleted, got %d, %v", count, err)
+	}
+}
+
+func TestGetUserOutlines(t *testing.T) {
+	db := newTestDB(t, "list")
+
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -314,16 +802,9 @@ func TestGetUserOutlines(t *testing.T) {
 }
 
 func TestBeginnerTemplatesSeeded(t *testing.T) {
-	dbPath := "/tmp/test_composter_beginner_templates.db"
-	defer os.Remove(dbPath)
+	db := newTestDB(t, "beginner_templates")
 
-	db, err := New(dbPath)
-	if err != nil {
-		t.Fatalf("Failed to create database: %v", err)
-	}
-	defer db.Close()
-
-	if err := db.Init(); err != nil {
+	if err := db.Init("admin"); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
@@ -371,3 +852,274 @@ func TestBeginnerTemplatesSeeded(t *testing.T) {
 		t.Error("Expected to find at least one template with CategoryBeginner")
 	}
 }
+
+func TestCountUserOutlines(t *testing.T) {
+	db := newTestDB(t, "count_outlines")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	user, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if _, err := db.CreateOutline(user.ID, fmt.Sprintf("Outline %d", i), "Content"); err != nil {
+			t.Fatalf("Failed to create outline %d: %v", i, err)
+		}
+	}
+
+	count, err := db.CountUserOutlines(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to count outlines: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("Expected 5 outlines, got %d", count)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db := newTestDB(t, "tokens")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	user, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	// Unknown scopes are rejected
+	if _, _, err := db.CreateAPIToken(user.ID, "Bad", []string{"admin"}, nil); err != ErrInvalidScope {
+		t.Errorf("Expected ErrInvalidScope, got %v", err)
+	}
+
+	token, id, err := db.CreateAPIToken(user.ID, "Script", []string{ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	resolved, apiToken, err := db.AuthenticateAPIToken(token)
+	if err != nil {
+		t.Fatalf("Failed to authenticate token: %v", err)
+	}
+	if resolved.ID != user.ID {
+		t.Errorf("Expected token to resolve to user %d, got %d", user.ID, resolved.ID)
+	}
+	if !apiToken.HasScope(ScopeRead) || apiToken.HasScope(ScopeWrite) {
+		t.Errorf("Unexpected scopes: %v", apiToken.Scopes)
+	}
+
+	tokens, err := db.GetUserAPITokens(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to list tokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Fatal("Expected one token with last used time recorded")
+	}
+
+	// Using it again soon after does not write the time again
+	recent := time.Now().UTC().Add(-tokenTouchInterval / 2).Truncate(time.Second)
+	db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", recent, id)
+	if _, apiToken, _ = db.AuthenticateAPIToken(token); !apiToken.LastUsedAt.Equal(recent) {
+		t.Errorf("Expected last used time %v to be kept, got %v", recent, apiToken.LastUsedAt)
+	}
+	stale := recent.Add(-tokenTouchInterval)
+	db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", stale, id)
+	if _, apiToken, _ = db.AuthenticateAPIToken(token); !apiToken.LastUsedAt.After(recent) {
+		t.Errorf("Expected last used time %v to be updated, got %v", stale, apiToken.LastUsedAt)
+	}
+
+	if _, _, err := db.AuthenticateAPIToken(token + "x"); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken for wrong token, got %v", err)
+	}
+
+	// Expired tokens are refused
+	past := time.Now().Add(-time.Hour)
+	expired, _, err := db.CreateAPIToken(user.ID, "Old", []string{ScopeWrite}, &past)
+	if err != nil {
+		t.Fatalf("Failed to create expired token: %v", err)
+	}
+	if _, _, err := db.AuthenticateAPIToken(expired); err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	// Revoked tokens are refused
+	if err := db.DeleteAPIToken(int(id), user.ID); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	if _, _, err := db.AuthenticateAPIToken(token); err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken after revoke, got %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	db := newTestDB(t, "twofactor")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	if err := db.SetTOTPSecret(admin.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	user, err := db.GetUserByID(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.TOTPEnabled {
+		t.Error("Expected two-factor to stay off until confirmed")
+	}
+
+	if err := db.EnableTOTP(admin.ID, 100); err != nil {
+		t.Fatalf("Failed to enable TOTP: %v", err)
+	}
+	user, err = db.GetUserByID(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if !user.TOTPEnabled {
+		t.Error("Expected two-factor to be enabled")
+	}
+
+	// Codes for the step used to enable, or earlier ones, are rejected
+	for _, tt := range []struct {
+		step int64
+		ok   bool
+	}{{100, false}, {99, false}, {101, true}, {101, false}} {
+		ok, err := db.UseTOTPStep(admin.ID, tt.step)
+		if err != nil {
+			t.Fatalf("Failed to use step: %v", err)
+		}
+		if ok != tt.ok {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", tt.step, ok, tt.ok)
+		}
+	}
+
+	codes, err := db.NewRecoveryCodes(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to create recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	if ok, _ := db.UseRecoveryCode(admin.ID, "wrong-code"); ok {
+		t.Error("Expected unknown recovery code to be rejected")
+	}
+	if ok, err := db.UseRecoveryCode(admin.ID, " "+codes[3]+" "); err != nil || !ok {
+		t.Errorf("Expected recovery code to be accepted, got %v, %v", ok, err)
+	}
+	if ok, _ := db.UseRecoveryCode(admin.ID, codes[3]); ok {
+		t.Error("Expected recovery code to be accepted only once")
+	}
+
+	count, err := db.CountRecoveryCodes(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to count recovery codes: %v", err)
+	}
+	if count != recoveryCodeCount-1 {
+		t.Errorf("Expected %d unused codes, got %d", recoveryCodeCount-1, count)
+	}
+
+	if err := db.DisableTOTP(admin.ID); err != nil {
+		t.Fatalf("Failed to disable TOTP: %v", err)
+	}
+	enrollment, err := db.GetTOTP(admin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get TOTP: %v", err)
+	}
+	if enrollment.Enabled || enrollment.Secret != "" {
+		t.Errorf("Expected enrollment to be cleared, got %+v", enrollment)
+	}
+	if count, _ := db.CountRecoveryCodes(admin.ID); count != 0 {
+		t.Errorf("Expected recovery codes to be deleted, got %d", count)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	db := newTestDB(t, "settings")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	value, err := db.GetSetting(SettingRequireTwoFactor)
+	if err != nil {
+		t.Fatalf("Failed to get setting: %v", err)
+	}
+	if value != "" {
+		t.Errorf("Expected unset setting to be empty, got %q", value)
+	}
+
+	for _, want := range []string{"admins", "all"} {
+		if err := db.SetSetting(SettingRequireTwoFactor, want); err != nil {
+			t.Fatalf("Failed to set setting: %v", err)
+		}
+		value, err := db.GetSetting(SettingRequireTwoFactor)
+		if err != nil {
+			t.Fatalf("Failed to get setting: %v", err)
+		}
+		if value != want {
+			t.Errorf("Expected %q, got %q", want, value)
+		}
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	db := newTestDB(t, "identities")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	if _, err := db.GetUserByIdentity("https://idp.example.com", "user-1"); err != sql.ErrNoRows {
+		t.Fatalf("Expected sql.ErrNoRows for unlinked identity, got %v", err)
+	}
+
+	user, err := db.CreateExternalUser("alice", true)
+	if err != nil {
+		t.Fatalf("Failed to create external user: %v", err)
+	}
+	if !user.IsAdmin || user.MustChangePassword {
+		t.Errorf("Unexpected external user %+v", user)
+	}
+
+	if err := db.LinkIdentity(user.ID, "https://idp.example.com", "user-1"); err != nil {
+		t.Fatalf("Failed to link identity: %v", err)
+	}
+	linked, err := db.GetUserByIdentity("https://idp.example.com", "user-1")
+	if err != nil {
+		t.Fatalf("Failed to get user by identity: %v", err)
+	}
+	if linked.ID != user.ID {
+		t.Errorf("Expected user %d, got %d", user.ID, linked.ID)
+	}
+
+	// The same subject at another issuer is someone else
+	if _, err := db.GetUserByIdentity("https://other.example.com", "user-1"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for other issuer, got %v", err)
+	}
+
+	if err := db.SetAdmin(user.ID, false); err != nil {
+		t.Fatalf("Failed to revoke admin: %v", err)
+	}
+	if user, _ := db.GetUserByID(user.ID); user.IsAdmin {
+		t.Error("Expected admin rights to be revoked")
+	}
+
+	if err := db.DeleteUser(user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := db.GetUserByIdentity("https://idp.example.com", "user-1"); err != sql.ErrNoRows {
+		t.Errorf("Expected identity to be removed with user, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Supported database backends, as named in configuration.
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// dialect holds what differs between backends. Queries are written once,
+// with ? placeholders and SQL both backends accept; the dialect rewrites
+// the placeholders and supplies its own migrations.
+//
+// Deletes remove dependent rows themselves rather than rely on ON DELETE
+// CASCADE. SQLite connections do not turn on PRAGMA foreign_keys, so its
+// cascades never fire. Postgres enforces them, but by the time the parent
+// row goes its dependents are already gone.
+type dialect struct {
+	name   string
+	driver string
+
+	// numbered is set for backends that take $1, $2, ... placeholders.
+	numbered bool
+
+	// tableExists is a query counting the tables named by its argument.
+	tableExists string
+
+	// migrationsTable creates the table recording applied migrations.
+	migrationsTable string
+}
+
+var dialects = map[string]*dialect{
+	SQLite: {
+		name:            SQLite,
+		driver:          "sqlite3",
+		tableExists:     "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		migrationsTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP)",
+	},
+	Postgres: {
+		name:            Postgres,
+		driver:          "pgx",
+		numbered:        true,
+		tableExists:     "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+		migrationsTable: "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP)",
+	},
+}
+
+func lookupDialect(name string) (*dialect, error) {
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown database driver %q", name)
+	}
+	return d, nil
+}
+
+// rebind rewrites the ? placeholders in query for the backend. Question
+// marks inside string literals are left alone.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// The query methods of DB and Tx shadow those of database/sql to rebind
+// placeholders, so code in this package can use ? for every backend.
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+// Tx is a transaction that rebinds placeholders like DB does.
+type Tx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx, db.dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
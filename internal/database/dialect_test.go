@@ -0,0 +1,26 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect string
+		query   string
+		want    string
+	}{
+		{SQLite, "SELECT id FROM users WHERE username = ? AND id = ?", "SELECT id FROM users WHERE username = ? AND id = ?"},
+		{Postgres, "SELECT id FROM users WHERE username = ? AND id = ?", "SELECT id FROM users WHERE username = $1 AND id = $2"},
+		{Postgres, "UPDATE users SET totp_secret = '?' WHERE id = ?", "UPDATE users SET totp_secret = '?' WHERE id = $1"},
+		{Postgres, "SELECT 'it''s?' WHERE x = ?", "SELECT 'it''s?' WHERE x = $1"},
+	}
+
+	for _, tt := range tests {
+		if got := dialects[tt.dialect].rebind(tt.query); got != tt.want {
+			t.Errorf("%s: rebind(%q) = %q, expected %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := Open("mysql", "composter"); err == nil {
+		t.Error("Expected unknown driver to be rejected")
+	}
+}
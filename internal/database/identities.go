@@ -0,0 +1,36 @@
+package database
+
+// GetUserByIdentity returns the user linked to an account at an external
+// identity provider, or sql.ErrNoRows if there is none.
+func (db *DB) GetUserByIdentity(issuer, subject string) (*User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)",
+		issuer, subject))
+}
+
+// LinkIdentity records that the account subject at issuer belongs to a user,
+// so that later logins find them even if their username changes.
+func (db *DB) LinkIdentity(userID int, issuer, subject string) error {
+	_, err := db.Exec("INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)",
+		issuer, subject, userID)
+	return err
+}
+
+// CreateExternalUser creates a user who logs in through an identity
+// provider. They are given a random password nobody knows, so they cannot
+// log in with a password until an admin sets one.
+func (db *DB) CreateExternalUser(username string, isAdmin bool) (*User, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateUser(username, password, isAdmin, false); err != nil {
+		return nil, err
+	}
+	return db.GetUser(username)
+}
+
+// SetAdmin grants or revokes a user's admin rights.
+func (db *DB) SetAdmin(id int, isAdmin bool) error {
+	_, err := db.Exec("UPDATE users SET is_admin = ? WHERE id = ?", isAdmin, id)
+	return err
+}
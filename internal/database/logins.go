@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginLockout tracks recent failed logins for a username, and whether the
+// username is currently locked out. Rows exist for unknown usernames too, so
+// that lockouts do not reveal which accounts exist. Times are stored as Unix
+// seconds.
+type LoginLockout struct {
+	Username      string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Locked reports whether the lockout is in force at time now.
+func (l *LoginLockout) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+// LoginAttempt is an entry in the login audit log.
+type LoginAttempt struct {
+	ID         int
+	Username   string
+	RemoteAddr string
+	Success    bool
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func scanLoginLockout(row interface{ Scan(...interface{}) error }) (*LoginLockout, error) {
+	lockout := &LoginLockout{}
+	var last int64
+	var until sql.NullInt64
+	if err := row.Scan(&lockout.Username, &lockout.Failures, &last, &until); err != nil {
+		return nil, err
+	}
+	lockout.LastFailureAt = time.Unix(last, 0)
+	if until.Valid {
+		t := time.Unix(until.Int64, 0)
+		lockout.LockedUntil = &t
+	}
+	return lockout, nil
+}
+
+func (db *DB) GetLoginLockout(username string) (*LoginLockout, error) {
+	return scanLoginLockout(db.QueryRow("SELECT username, failures, last_failure_at, locked_until FROM login_lockouts WHERE username = ?",
+		username))
+}
+
+// GetLockedLogins returns every username locked out at time now.
+func (db *DB) GetLockedLogins(now time.Time) ([]LoginLockout, error) {
+	rows, err := db.Query("SELECT username, failures, last_failure_at, locked_until FROM login_lockouts WHERE locked_until > ? ORDER BY locked_until DESC",
+		now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []LoginLockout
+	for rows.Next() {
+		lockout, err := scanLoginLockout(rows)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, *lockout)
+	}
+	return lockouts, nil
+}
+
+// RecordLoginFailure counts a failed login for username at time now and
+// returns the number of failures since the last success or lockout. Failures
+// older than window are forgotten.
+func (db *DB) RecordLoginFailure(username string, now time.Time, window time.Duration) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO login_lockouts (username, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT(username) DO UPDATE SET
+			failures = CASE WHEN login_lockouts.last_failure_at <= ? THEN 1 ELSE login_lockouts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at`,
+		username, now.Unix(), now.Add(-window).Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err = tx.QueryRow("SELECT failures FROM login_lockouts WHERE username = ?", username).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+// LockLogin locks username out until the given time and resets its failure
+// count, so the lockout is not extended by the attempts that caused it.
+func (db *DB) LockLogin(username string, until time.Time) error {
+	_, err := db.Exec("UPDATE login_lockouts SET failures = 0, locked_until = ? WHERE username = ?",
+		until.Unix(), username)
+	return err
+}
+
+// ClearLoginLockout forgets all failures and any lockout for username.
+func (db *DB) ClearLoginLockout(username string) error {
+	_, err := db.Exec("DELETE FROM login_lockouts WHERE username = ?", username)
+	return err
+}
+
+// DeleteStaleLoginLockouts removes lockout rows with no failure since before
+// and no lockout still in force at that time.
+func (db *DB) DeleteStaleLoginLockouts(before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM login_lockouts WHERE last_failure_at <= ? AND (locked_until IS NULL OR locked_until <= ?)",
+		before.Unix(), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordLoginAttempt adds an entry to the login audit log.
+func (db *DB) RecordLoginAttempt(username, remoteAddr string, success bool, reason string) error {
+	_, err := db.Exec("INSERT INTO login_attempts (username, remote_addr, success, reason) VALUES (?, ?, ?, ?)",
+		username, remoteAddr, success, reason)
+	return err
+}
+
+// DeleteOldLoginAttempts removes audit log entries made before before.
+func (db *DB) DeleteOldLoginAttempts(before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM login_attempts WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetRecentLoginFailures returns up to limit failed logins, newest first.
+func (db *DB) GetRecentLoginFailures(limit int) ([]LoginAttempt, error) {
+	rows, err := db.Query("SELECT id, username, remote_addr, success, reason, created_at FROM login_attempts WHERE success = FALSE ORDER BY id DESC LIMIT ?",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Username, &a.RemoteAddr, &a.Success, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, nil
+}
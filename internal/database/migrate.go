@@ -0,0 +1,231 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes are SQL files named NNNN_description.sql, applied in order
+// of their version number NNNN. Each backend has its own directory, and
+// every migration must be written for all of them under the same name.
+// Once released a migration must never be edited; change the schema by
+// adding a new one.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is one step in the schema's history.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus reports whether a migration has been applied to a
+// database, and when.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations returns the migrations built into the binary for the
+// database's backend, in order.
+func (db *DB) Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, path.Join("migrations", db.dialect.name))
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".sql")
+		if !ok {
+			continue
+		}
+		number, description, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || description == "" {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_description.sql", entry.Name())
+		}
+		// ReadDir sorts by name, so versions must count up from 1
+		if version != len(migrations)+1 {
+			return nil, fmt.Errorf("migration %s: expected version %d", entry.Name(), len(migrations)+1)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: description, SQL: string(data)})
+	}
+	return migrations, nil
+}
+
+// MigrationStatus lists the built-in migrations and whether each has been
+// applied.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotNewer(applied, migrations); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		at, ok := applied[m.Version]
+		status[i] = MigrationStatus{Migration: m, Applied: ok, AppliedAt: at}
+	}
+	return status, nil
+}
+
+// Migrate applies the built-in migrations the database has not had yet and
+// returns them. Each migration runs in its own transaction, so a failure
+// leaves the database at the last one that succeeded.
+func (db *DB) Migrate() ([]Migration, error) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return db.migrate(migrations)
+}
+
+func (db *DB) migrate(migrations []Migration) ([]Migration, error) {
+	if _, err := db.Exec(db.dialect.migrationsTable); err != nil {
+		return nil, err
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNotNewer(applied, migrations); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (db *DB) applyMigration(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.Version == 1 && db.dialect.name == SQLite {
+		if err := adoptUnversioned(tx); err != nil {
+			return err
+		}
+	}
+
+	// Migrations are run as written, without rebinding
+	if _, err := tx.Tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations returns when each applied migration was applied, by
+// version.
+func (db *DB) appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	var exists int
+	err := db.QueryRow(db.dialect.tableExists, "schema_migrations").Scan(&exists)
+	if err != nil || exists == 0 {
+		return applied, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// checkNotNewer refuses to work with a database that a later version of
+// Composter has migrated, since this one does not know its schema.
+func checkNotNewer(applied map[int]time.Time, migrations []Migration) error {
+	for version := range applied {
+		if version > len(migrations) {
+			return fmt.Errorf("database has migration %d, but this version of Composter only knows %d; upgrade Composter", version, len(migrations))
+		}
+	}
+	return nil
+}
+
+// adoptUnversioned brings a SQLite database created before migrations
+// existed up to the baseline. Its tables are kept, and the baseline's
+// CREATE ... IF NOT EXISTS statements add whatever tables it lacks, but
+// columns added to existing tables since the first release have to be
+// added here.
+func adoptUnversioned(tx *Tx) error {
+	columns := []struct{ table, column, definition string }{
+		{"users", "must_change_password", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to a table unless the table is missing or already
+// has it.
+func addColumn(tx *Tx, table, column, definition string) error {
+	var tableExists, columnExists int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&tableExists)
+	if err != nil || tableExists == 0 {
+		return err
+	}
+	err = tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&columnExists)
+	if err != nil || columnExists > 0 {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
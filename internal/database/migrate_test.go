@@ -0,0 +1,278 @@
+package database
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// newFixtureDB creates a database at dbPath from an SQL fixture in testdata.
+func newFixtureDB(t *testing.T, dbPath, fixture string) *DB {
+	t.Helper()
+	skipUnlessSQLite(t)
+	os.Remove(dbPath)
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if fixture != "" {
+		data, err := os.ReadFile("testdata/" + fixture)
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+		if _, err := db.Exec(string(data)); err != nil {
+			t.Fatalf("Failed to load fixture: %v", err)
+		}
+	}
+	return db
+}
+
+// schema describes every table's columns and every index, for comparing
+// databases. Columns are sorted, since ALTER TABLE adds them at the end.
+func schema(t *testing.T, db *DB) map[string][]string {
+	t.Helper()
+	rows, err := db.Query(`SELECT m.type || ' ' || m.name, coalesce(group_concat(c.name || ' ' || c.type || ' ' || c."notnull" || ' ' || coalesce(c.dflt_value, ''), ', '), '')
+		FROM sqlite_master m LEFT JOIN pragma_table_info(m.name) c
+		WHERE m.name NOT LIKE 'sqlite_%'
+		GROUP BY m.name`)
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	defer rows.Close()
+
+	s := make(map[string][]string)
+	for rows.Next() {
+		var name, columns string
+		if err := rows.Scan(&name, &columns); err != nil {
+			t.Fatalf("Failed to read schema: %v", err)
+		}
+		s[name] = strings.Split(columns, ", ")
+		sort.Strings(s[name])
+	}
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	db := newTestDB(t, "migrate")
+
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "baseline" {
+		t.Fatalf("Expected the baseline migration first, got %+v", migrations)
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	for _, s := range status {
+		if s.Applied {
+			t.Errorf("Expected migration %d to be pending on an empty database", s.Version)
+		}
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Expected %d migrations applied, got %d", len(migrations), len(applied))
+	}
+
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate again: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected nothing to apply the second time, got %+v", applied)
+	}
+
+	status, err = db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("Expected migration %d to be applied, got %+v", s.Version, s)
+		}
+	}
+}
+
+func TestMigrateUpgradesFixtures(t *testing.T) {
+	fresh := newFixtureDB(t, "/tmp/test_composter_migrate_fresh.db", "")
+	if err := fresh.Init("unused"); err != nil {
+		t.Fatalf("Failed to initialize fresh database: %v", err)
+	}
+	want := schema(t, fresh)
+
+	for _, fixture := range []string{"first_release.sql", "before_migrations.sql"} {
+		db := newFixtureDB(t, "/tmp/test_composter_migrate_fixture.db", fixture)
+
+		if err := db.Init("unused"); err != nil {
+			t.Fatalf("%s: failed to initialize: %v", fixture, err)
+		}
+
+		if got := schema(t, db); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: upgraded schema differs from a fresh one:\n got %v\nwant %v", fixture, got, want)
+		}
+
+		// Existing data survives, and existing accounts can log in
+		user, err := db.VerifyPassword("alice", "fixture-pass")
+		if err != nil {
+			t.Fatalf("%s: failed to log in as alice: %v", fixture, err)
+		}
+		if user.IsAdmin || user.MustChangePassword {
+			t.Errorf("%s: unexpected user %+v", fixture, user)
+		}
+		admin, err := db.GetUser("admin")
+		if err != nil || !admin.IsAdmin || admin.MustChangePassword {
+			t.Errorf("%s: expected existing admin to be kept, got %+v, %v", fixture, admin, err)
+		}
+
+		outline, err := db.GetOutline(1, user.ID)
+		if err != nil {
+			t.Fatalf("%s: failed to get outline: %v", fixture, err)
+		}
+		if outline.Title != "Shopping" || !strings.Contains(outline.Content, "Bread") || outline.Version != 1 {
+			t.Errorf("%s: unexpected outline %+v", fixture, outline)
+		}
+		revisions, err := db.GetOutlineRevisions(1, user.ID)
+		if err != nil || len(revisions) != 1 || revisions[0].Title != "Shopping" || revisions[0].Author != "alice" || revisions[0].Version != 1 {
+			t.Errorf("%s: expected the outline's current state as its first revision, got %+v, %v", fixture, revisions, err)
+		}
+
+		summaries, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Limit: 10})
+		if err != nil || len(summaries) != 1 || summaries[0].NodeCount == 0 || summaries[0].Preview == "" {
+			t.Errorf("%s: expected existing outlines to be summarized, got %+v, %v", fixture, summaries, err)
+		}
+
+		// Outlines left behind by deleted users are in the trash
+		deleted, err := db.GetDeletedUsers()
+		if err != nil || len(deleted) != 1 || deleted[0].ID != 3 || deleted[0].Outlines != 1 {
+			t.Errorf("%s: expected the outline of a deleted user to be recoverable, got %+v, %v", fixture, deleted, err)
+		}
+
+		// Tables added since the first release work
+		if _, _, err := db.CreateAPIToken(user.ID, "laptop", []string{"read"}, nil); err != nil {
+			t.Errorf("%s: failed to create API token: %v", fixture, err)
+		}
+		if err := db.SetSetting("fixture", "ok"); err != nil {
+			t.Errorf("%s: failed to save setting: %v", fixture, err)
+		}
+	}
+}
+
+func TestMigrateRollsBackFailure(t *testing.T) {
+	db := newTestDB(t, "migrate_fail")
+
+	migrations := []Migration{
+		{Version: 1, Name: "one", SQL: "CREATE TABLE one (id INTEGER);"},
+		{Version: 2, Name: "two", SQL: "CREATE TABLE two (id INTEGER); INSERT INTO missing VALUES (1);"},
+	}
+	applied, err := db.migrate(migrations)
+	if err == nil {
+		t.Fatal("Expected the broken migration to fail")
+	}
+	if !strings.Contains(err.Error(), "0002_two") {
+		t.Errorf("Expected error to name the migration, got %v", err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("Expected only the first migration to be applied, got %+v", applied)
+	}
+
+	var tables int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('one', 'two')").Scan(&tables)
+	if tables != 1 {
+		t.Errorf("Expected the failed migration's table to be rolled back, found %d tables", tables)
+	}
+	var recorded int
+	db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&recorded)
+	if recorded != 1 {
+		t.Errorf("Expected 1 recorded migration, got %d", recorded)
+	}
+
+	// Fixing the migration lets it apply
+	migrations[1].SQL = "CREATE TABLE two (id INTEGER);"
+	if applied, err := db.migrate(migrations); err != nil || len(applied) != 1 || applied[0].Version != 2 {
+		t.Errorf("Expected the fixed migration to apply, got %+v, %v", applied, err)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	db := newTestDB(t, "migrate_newer")
+
+	migrations := []Migration{{Version: 1, Name: "one", SQL: "CREATE TABLE one (id INTEGER);"}}
+	if _, err := db.migrate(append(migrations, Migration{Version: 2, Name: "two", SQL: "SELECT 1;"})); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	if _, err := db.migrate(migrations); err == nil {
+		t.Error("Expected a database from a newer version to be refused")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		ok    bool
+	}{
+		{"in order", fstest.MapFS{
+			"m/0001_first.sql":  {Data: []byte("SELECT 1;")},
+			"m/0002_second.sql": {Data: []byte("SELECT 2;")},
+			"m/README":          {Data: []byte("ignored")},
+		}, true},
+		{"gap", fstest.MapFS{
+			"m/0001_first.sql": {Data: []byte("SELECT 1;")},
+			"m/0003_third.sql": {Data: []byte("SELECT 3;")},
+		}, false},
+		{"duplicate", fstest.MapFS{
+			"m/0001_first.sql": {Data: []byte("SELECT 1;")},
+			"m/0001_other.sql": {Data: []byte("SELECT 1;")},
+		}, false},
+		{"bad name", fstest.MapFS{
+			"m/first.sql": {Data: []byte("SELECT 1;")},
+		}, false},
+	}
+
+	for _, tt := range tests {
+		migrations, err := loadMigrations(tt.files, "m")
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.ok && (len(migrations) != 2 || migrations[1].Name != "second" || migrations[1].SQL != "SELECT 2;") {
+			t.Errorf("%s: unexpected migrations %+v", tt.name, migrations)
+		}
+	}
+}
+
+func TestMigrationsMatchAcrossBackends(t *testing.T) {
+	var want []Migration
+	for name := range dialects {
+		migrations, err := loadMigrations(migrationFiles, "migrations/"+name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want == nil {
+			want = migrations
+			continue
+		}
+		if len(migrations) != len(want) {
+			t.Fatalf("%s: expected %d migrations, got %d", name, len(want), len(migrations))
+		}
+		for i, m := range migrations {
+			if m.Name != want[i].Name {
+				t.Errorf("%s: migration %d is %q, expected %q", name, m.Version, m.Name, want[i].Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,19 @@
+-- Every save of an outline is kept as a revision. Existing outlines start
+-- with their current state as the first revision. user_id is whoever made
+-- the save; size is the length of content in bytes.
+
+CREATE TABLE outline_revisions (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	outline_id INTEGER NOT NULL REFERENCES outlines(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_outline_revisions_outline_id ON outline_revisions(outline_id);
+
+INSERT INTO outline_revisions (outline_id, user_id, title, content, size, created_at)
+SELECT id, user_id, title, content, octet_length(content), COALESCE(updated_at, CURRENT_TIMESTAMP)
+FROM outlines ORDER BY id;
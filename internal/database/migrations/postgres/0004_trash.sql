@@ -0,0 +1,28 @@
+-- Deleted outlines and templates are kept in the trash, with deleted_at set
+-- in Unix seconds, until they are restored or purged. A deleted user's
+-- outlines and templates go to the trash too, and deleted_users remembers
+-- whose they were so that an admin can recover them. Outlines must outlive
+-- their owner for that, so outlines.user_id loses its foreign key. Templates
+-- left behind by users deleted before now are moved to the trash the same
+-- way.
+
+ALTER TABLE outlines DROP CONSTRAINT IF EXISTS outlines_user_id_fkey;
+
+ALTER TABLE outlines ADD COLUMN deleted_at BIGINT;
+ALTER TABLE templates ADD COLUMN deleted_at BIGINT;
+
+CREATE TABLE deleted_users (
+	id INTEGER PRIMARY KEY,
+	username TEXT NOT NULL,
+	deleted_at BIGINT NOT NULL
+);
+
+INSERT INTO deleted_users (id, username, deleted_at)
+SELECT DISTINCT user_id, '', EXTRACT(EPOCH FROM now())::BIGINT FROM templates
+WHERE is_system = FALSE AND user_id NOT IN (SELECT id FROM users);
+
+UPDATE templates SET deleted_at = EXTRACT(EPOCH FROM now())::BIGINT
+WHERE is_system = FALSE AND user_id IN (SELECT id FROM deleted_users);
+
+CREATE INDEX idx_outlines_deleted_at ON outlines(deleted_at);
+CREATE INDEX idx_templates_deleted_at ON templates(deleted_at);
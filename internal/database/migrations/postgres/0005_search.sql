@@ -0,0 +1,16 @@
+-- The text of outlines and templates, one row per node, for search. node is
+-- the node's position in document order, or 0 for the title, and path holds
+-- the text of the node's ancestors, one per line. Rows are written whenever
+-- an item is saved; existing items are indexed at startup.
+
+CREATE TABLE search_entries (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	item_type TEXT NOT NULL,
+	item_id INTEGER NOT NULL,
+	node INTEGER NOT NULL,
+	path TEXT NOT NULL,
+	text TEXT NOT NULL
+);
+
+CREATE INDEX idx_search_entries_item ON search_entries(item_type, item_id);
+CREATE INDEX idx_search_entries_text ON search_entries USING GIN (to_tsvector('simple', text));
@@ -0,0 +1,12 @@
+-- Outlines shared with users other than their owner. role is viewer,
+-- editor or owner; an outline's owner, outlines.user_id, has no row here.
+
+CREATE TABLE outline_shares (
+	outline_id INTEGER NOT NULL REFERENCES outlines(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (outline_id, user_id)
+);
+
+CREATE INDEX idx_outline_shares_user_id ON outline_shares(user_id);
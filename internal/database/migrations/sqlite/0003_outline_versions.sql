@@ -0,0 +1,14 @@
+-- Outlines carry a version number, bumped by every save that records a
+-- revision, so that a save based on an older version can be detected.
+-- Existing revisions are numbered in the order they were saved.
+
+ALTER TABLE outlines ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE outline_revisions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+UPDATE outline_revisions SET version = (
+	SELECT COUNT(*) FROM outline_revisions r
+	WHERE r.outline_id = outline_revisions.outline_id AND r.id <= outline_revisions.id
+);
+UPDATE outlines SET version = COALESCE((SELECT MAX(version) FROM outline_revisions WHERE outline_id = outlines.id), 1);
+
+CREATE UNIQUE INDEX idx_outline_revisions_version ON outline_revisions(outline_id, version);
@@ -0,0 +1,29 @@
+-- Deleted outlines and templates are kept in the trash, with deleted_at set
+-- in Unix seconds, until they are restored or purged. A deleted user's
+-- outlines and templates go to the trash too, and deleted_users remembers
+-- whose they were so that an admin can recover them. Outlines left behind
+-- by users deleted before now are moved to the trash the same way.
+
+ALTER TABLE outlines ADD COLUMN deleted_at INTEGER;
+ALTER TABLE templates ADD COLUMN deleted_at INTEGER;
+
+CREATE TABLE deleted_users (
+	id INTEGER PRIMARY KEY,
+	username TEXT NOT NULL,
+	deleted_at INTEGER NOT NULL
+);
+
+INSERT INTO deleted_users (id, username, deleted_at)
+SELECT DISTINCT user_id, '', CAST(strftime('%s', 'now') AS INTEGER) FROM outlines
+WHERE user_id NOT IN (SELECT id FROM users)
+UNION
+SELECT DISTINCT user_id, '', CAST(strftime('%s', 'now') AS INTEGER) FROM templates
+WHERE is_system = FALSE AND user_id NOT IN (SELECT id FROM users);
+
+UPDATE outlines SET deleted_at = CAST(strftime('%s', 'now') AS INTEGER)
+WHERE user_id IN (SELECT id FROM deleted_users);
+UPDATE templates SET deleted_at = CAST(strftime('%s', 'now') AS INTEGER)
+WHERE is_system = FALSE AND user_id IN (SELECT id FROM deleted_users);
+
+CREATE INDEX idx_outlines_deleted_at ON outlines(deleted_at);
+CREATE INDEX idx_templates_deleted_at ON templates(deleted_at);
@@ -0,0 +1,17 @@
+-- The text of outlines and templates, one row per node, for search. node is
+-- the node's position in document order, or 0 for the title, and path holds
+-- the text of the node's ancestors, one per line. Rows are written whenever
+-- an item is saved; existing items are indexed at startup. Builds with FTS5
+-- also keep a full-text index of this table, search_fts, which is set up at
+-- startup rather than here because not every build can create it.
+
+CREATE TABLE search_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	item_type TEXT NOT NULL,
+	item_id INTEGER NOT NULL,
+	node INTEGER NOT NULL,
+	path TEXT NOT NULL,
+	text TEXT NOT NULL
+);
+
+CREATE INDEX idx_search_entries_item ON search_entries(item_type, item_id);
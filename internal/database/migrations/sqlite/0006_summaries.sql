@@ -0,0 +1,18 @@
+-- Summaries of outlines and templates, so that lists can show them without
+-- loading their content: how many non-blank nodes there are, how many levels
+-- deep they go, and the text of the first one. They are written whenever an
+-- item is saved; preview is NULL for items saved before, which are
+-- summarized at startup.
+
+ALTER TABLE outlines ADD COLUMN node_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outlines ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outlines ADD COLUMN preview TEXT;
+
+ALTER TABLE templates ADD COLUMN node_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE templates ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE templates ADD COLUMN preview TEXT;
+
+-- One index for each way the outline list can be sorted
+CREATE INDEX idx_outlines_user_updated ON outlines(user_id, updated_at, id);
+CREATE INDEX idx_outlines_user_created ON outlines(user_id, created_at, id);
+CREATE INDEX idx_outlines_user_title ON outlines(user_id, LOWER(title), id);
@@ -0,0 +1,14 @@
+-- Outlines shared with users other than their owner. role is viewer,
+-- editor or owner; an outline's owner, outlines.user_id, has no row here.
+
+CREATE TABLE outline_shares (
+	outline_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (outline_id, user_id),
+	FOREIGN KEY (outline_id) REFERENCES outlines(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_outline_shares_user_id ON outline_shares(user_id);
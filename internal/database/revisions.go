@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Revision is a saved state of an outline. A revision is recorded each time
+// an outline is created or saved with a different title or content, so the
+// newest revision always matches the outline itself.
+type Revision struct {
+	ID        int
+	OutlineID int
+	Version   int
+	UserID    int    // who saved it
+	Author    string // their username, or "" if they have been deleted
+	Title     string
+	Content   string
+	Size      int // length of Content in bytes
+	CreatedAt time.Time
+}
+
+const revisionColumns = "r.id, r.outline_id, r.version, r.user_id, COALESCE(u.username, ''), r.title, r.size, r.created_at"
+
+func addRevision(tx *Tx, outlineID int64, userID, version int, title, content string) error {
+	_, err := tx.Exec("INSERT INTO outline_revisions (outline_id, version, user_id, title, content, size) VALUES (?, ?, ?, ?, ?, ?)",
+		outlineID, version, userID, title, content, len(content))
+	return err
+}
+
+// GetOutlineRevisions lists the revisions of an outline the user has access
+// to, newest first. Content is left empty; use GetOutlineRevision to load it.
+func (db *DB) GetOutlineRevisions(outlineID, userID int) ([]Revision, error) {
+	rows, err := db.Query(`SELECT `+revisionColumns+` FROM outline_revisions r
+		JOIN outlines o ON o.id = r.outline_id
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.outline_id = ? AND `+outlineVisible+` AND o.deleted_at IS NULL
+		ORDER BY r.id DESC`,
+		outlineID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.ID, &rev.OutlineID, &rev.Version, &rev.UserID, &rev.Author, &rev.Title, &rev.Size, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetOutlineRevision returns one revision of an outline the user has access
+// to, including its content, or sql.ErrNoRows if there is no such revision.
+func (db *DB) GetOutlineRevision(id, outlineID, userID int) (*Revision, error) {
+	return scanRevision(db.QueryRow(`SELECT `+revisionColumns+`, r.content FROM outline_revisions r
+		JOIN outlines o ON o.id = r.outline_id
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.id = ? AND r.outline_id = ? AND `+outlineVisible+` AND o.deleted_at IS NULL`,
+		id, outlineID, userID, userID))
+}
+
+// GetOutlineVersion returns the revision of an outline the user has access
+// to that has the given version, including its content, or sql.ErrNoRows if
+// there is none.
+func (db *DB) GetOutlineVersion(outlineID, userID, version int) (*Revision, error) {
+	return scanRevision(db.QueryRow(`SELECT `+revisionColumns+`, r.content FROM outline_revisions r
+		JOIN outlines o ON o.id = r.outline_id
+		LEFT JOIN users u ON u.id = r.user_id
+		WHERE r.outline_id = ? AND `+outlineVisible+` AND o.deleted_at IS NULL AND r.version = ?`,
+		outlineID, userID, userID, version))
+}
+
+func scanRevision(row *sql.Row) (*Revision, error) {
+	rev := &Revision{}
+	err := row.Scan(&rev.ID, &rev.OutlineID, &rev.Version, &rev.UserID, &rev.Author, &rev.Title, &rev.Size, &rev.CreatedAt, &rev.Content)
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
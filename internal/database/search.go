@@ -0,0 +1,325 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	outlinetree "github.com/kristofer/composter/internal/outline"
+)
+
+// Kinds of item that can be searched.
+const (
+	SearchOutline  = "outline"
+	SearchTemplate = "template"
+)
+
+// maxSearchTerms is how many words of a query are searched for.
+const maxSearchTerms = 8
+
+// SearchResult is a node of an outline or template, or its title, that
+// matches a search.
+type SearchResult struct {
+	Type  string // SearchOutline or SearchTemplate
+	ID    int
+	Title string
+	// Node is the node's position in document order, counting from 1, or
+	// 0 if the title matched.
+	Node int
+	// Path is the text of the node's ancestors, outermost first.
+	Path []string
+	Text string
+}
+
+// SearchTerms splits a search query into the lowercase words searched for.
+// Punctuation separates words and is otherwise ignored.
+func SearchTerms(query string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// indexItem replaces the search entries of an outline or template with its
+// title and the text of each of its nodes. Blank lines are left out.
+func indexItem(tx *Tx, itemType string, id int64, title, content string) error {
+	if _, err := tx.Exec("DELETE FROM search_entries WHERE item_type = ? AND item_id = ?", itemType, id); err != nil {
+		return err
+	}
+
+	insert := "INSERT INTO search_entries (item_type, item_id, node, path, text) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(insert, itemType, id, 0, "", title); err != nil {
+		return err
+	}
+
+	node := 0
+	var index func(nodes []*outlinetree.Node, path []string) error
+	index = func(nodes []*outlinetree.Node, path []string) error {
+		for _, n := range nodes {
+			node++
+			if strings.TrimSpace(n.Text) != "" {
+				if _, err := tx.Exec(insert, itemType, id, node, strings.Join(path, "\n"), n.Text); err != nil {
+					return err
+				}
+			}
+			if err := index(n.Children, append(path[:len(path):len(path)], n.Text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return index(outlinetree.Parse(content).Roots, nil)
+}
+
+// unindexItems removes the search entries of the outlines or templates
+// matching where, a condition on their table.
+func unindexItems(tx *Tx, itemType, where string, args ...interface{}) error {
+	table := "outlines"
+	if itemType == SearchTemplate {
+		table = "templates"
+	}
+	_, err := tx.Exec("DELETE FROM search_entries WHERE item_type = ? AND item_id IN (SELECT id FROM "+table+" WHERE "+where+")",
+		append([]interface{}{itemType}, args...)...)
+	return err
+}
+
+// initSearch indexes outlines and templates saved before search existed, and
+// on SQLite builds that include FTS5 sets up the full-text index. That index
+// is not created by a migration because not every build can create it. A
+// build without FTS5 cannot keep it up to date either, so it removes the
+// triggers that do, and the index is rebuilt the next time a build with FTS5
+// starts.
+func (db *DB) initSearch() error {
+	for _, item := range []struct{ itemType, query string }{
+		{SearchOutline, "SELECT i.id, i.title, i.content FROM outlines i"},
+		{SearchTemplate, "SELECT i.id, i.name, i.content FROM templates i"},
+	} {
+		if err := db.indexMissing(item.itemType, item.query); err != nil {
+			return err
+		}
+	}
+
+	if db.dialect.name != SQLite {
+		return nil
+	}
+
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		fmt.Println("WARNING: this build of SQLite has no FTS5, so search reads every entry instead of using a full-text index")
+		fmt.Println("Build with the sqlite_fts5 tag (make build) to get the index")
+		for _, trigger := range []string{"search_fts_insert", "search_fts_delete"} {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('search_fts_insert', 'search_fts_delete')").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if triggers < 2 {
+		for _, statement := range []string{
+			"CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(text, content = 'search_entries', content_rowid = 'id', tokenize = 'unicode61 remove_diacritics 2')",
+			"CREATE TRIGGER IF NOT EXISTS search_fts_insert AFTER INSERT ON search_entries BEGIN INSERT INTO search_fts (rowid, text) VALUES (new.id, new.text); END",
+			"CREATE TRIGGER IF NOT EXISTS search_fts_delete AFTER DELETE ON search_entries BEGIN INSERT INTO search_fts (search_fts, rowid, text) VALUES ('delete', old.id, old.text); END",
+			"INSERT INTO search_fts (search_fts) VALUES ('rebuild')",
+		} {
+			if _, err := db.Exec(statement); err != nil {
+				return err
+			}
+		}
+	}
+	db.fts5 = true
+	return nil
+}
+
+// indexMissing indexes the items selected by query, which must return their
+// ID, title and content from a table aliased as i, that have no search
+// entries yet.
+func (db *DB) indexMissing(itemType, query string) error {
+	rows, err := db.Query(query+" WHERE NOT EXISTS (SELECT 1 FROM search_entries WHERE item_type = ? AND item_id = i.id)", itemType)
+	if err != nil {
+		return err
+	}
+	type item struct {
+		id             int64
+		title, content string
+	}
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.title, &it.content); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, it := range items {
+		if err := indexItem(tx, itemType, it.id, it.title, it.content); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Search finds the nodes and titles of the outlines a user owns or has had
+// shared with them, their templates and the system templates, that contain
+// every term of query, best matches first. Items in the trash are left out.
+// Words match as prefixes when the database has a full-text index, and
+// anywhere in the text otherwise.
+func (db *DB) Search(userID int, query string, limit int) ([]SearchResult, error) {
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var join, match, order string
+	var matchArgs, orderArgs []interface{}
+	switch {
+	case db.fts5:
+		words := make([]string, len(terms))
+		for i, term := range terms {
+			words[i] = `"` + term + `"*`
+		}
+		join = "JOIN search_fts ON search_fts.rowid = e.id"
+		match = "search_fts MATCH ?"
+		matchArgs = []interface{}{strings.Join(words, " ")}
+		order = "search_fts.rank, e.node"
+
+	case db.dialect.name == Postgres:
+		words := make([]string, len(terms))
+		for i, term := range terms {
+			words[i] = term + ":*"
+		}
+		tsquery := strings.Join(words, " & ")
+		match = "to_tsvector('simple', e.text) @@ to_tsquery('simple', ?)"
+		matchArgs = []interface{}{tsquery}
+		order = "ts_rank(to_tsvector('simple', e.text), to_tsquery('simple', ?)) DESC, e.node"
+		orderArgs = []interface{}{tsquery}
+
+	default:
+		conditions := make([]string, len(terms))
+		for i, term := range terms {
+			conditions[i] = "LOWER(e.text) LIKE ?"
+			matchArgs = append(matchArgs, "%"+term+"%")
+		}
+		match = strings.Join(conditions, " AND ")
+		order = "COALESCE(o.updated_at, t.updated_at) DESC, e.item_id, e.node"
+	}
+
+	args := append(matchArgs, userID, userID, userID)
+	args = append(args, orderArgs...)
+	args = append(args, limit)
+	rows, err := db.Query(fmt.Sprintf(`SELECT e.item_type, e.item_id, COALESCE(o.title, t.name), e.node, e.path, e.text
+		FROM search_entries e %s
+		LEFT JOIN outlines o ON e.item_type = 'outline' AND o.id = e.item_id
+		LEFT JOIN templates t ON e.item_type = 'template' AND t.id = e.item_id
+		WHERE %s AND (
+			(`+outlineVisible+` AND o.deleted_at IS NULL)
+			OR (t.deleted_at IS NULL AND (t.is_system = TRUE OR t.user_id = ?)))
+		ORDER BY %s LIMIT ?`, join, match, order), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		var path string
+		if err := rows.Scan(&result.Type, &result.ID, &result.Title, &result.Node, &path, &result.Text); err != nil {
+			return nil, err
+		}
+		result.Path = []string{}
+		if path != "" {
+			result.Path = strings.Split(path, "\n")
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// snippetLength is roughly how many characters of a node Highlight shows.
+const snippetLength = 160
+
+// Highlight returns text as HTML, with the places where it contains any of
+// terms wrapped in <mark> elements. Long text is cut down to the part around
+// the first match.
+func Highlight(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length, so positions would not line up
+		lower = runes
+	}
+
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) == term {
+				for j := i; j < i+len(t); j++ {
+					marked[j] = true
+				}
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if end > snippetLength {
+		if first > snippetLength/4 {
+			start = first - snippetLength/4
+		}
+		if start+snippetLength < end {
+			end = start + snippetLength
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		chunk := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			chunk = "<mark>" + chunk + "</mark>"
+		}
+		b.WriteString(chunk)
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
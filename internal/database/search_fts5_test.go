@@ -0,0 +1,18 @@
+//go:build sqlite_fts5
+
+package database
+
+import "testing"
+
+// Builds with the sqlite_fts5 tag must use the full-text index rather than
+// quietly falling back to scanning every entry.
+func TestSearchUsesFTS5(t *testing.T) {
+	db := newTestDB(t, "search_fts5")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	if !db.fts5 {
+		t.Fatal("Expected a build with the sqlite_fts5 tag to use the full-text index")
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	db := newTestDB(t, "search")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+	db.CreateUser("other", "password", false, false)
+	other, _ := db.GetUser("other")
+
+	id, err := db.CreateOutline(user.ID, "Zephyr plan", `<div>Gazebo</div>
+<div style="margin-left: 30px">Quasar schemata</div>
+<div style="margin-left: 60px">Pelicans for wombats</div>
+<div><br></div>`)
+	if err != nil {
+		t.Fatalf("Failed to create outline: %v", err)
+	}
+
+	results, err := db.Search(user.ID, "schemata", 10)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	want := []SearchResult{{Type: SearchOutline, ID: int(id), Title: "Zephyr plan", Node: 2, Path: []string{"Gazebo"}, Text: "Quasar schemata"}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Expected %+v, got %+v", want, results)
+	}
+
+	tests := []struct {
+		query string
+		nodes []int
+	}{
+		{"zephyr", []int{0}},
+		{"QUAS schem", []int{2}},
+		{"pelicans, wombats!", []int{3}},
+		{"quasar wombats", nil}, // every word must be in the same node
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		results, err := db.Search(user.ID, tt.query, 10)
+		if err != nil {
+			t.Fatalf("%q: failed to search: %v", tt.query, err)
+		}
+		var nodes []int
+		for _, r := range results {
+			if r.Type == SearchOutline {
+				nodes = append(nodes, r.Node)
+			}
+		}
+		if !reflect.DeepEqual(nodes, tt.nodes) {
+			t.Errorf("%q: expected nodes %v, got %v", tt.query, tt.nodes, nodes)
+		}
+	}
+
+	// Other users' outlines are not found, but system templates are
+	if results, _ := db.Search(other.ID, "quasar", 10); len(results) != 0 {
+		t.Errorf("Expected another user to find nothing, got %+v", results)
+	}
+	results, _ = db.Search(other.ID, "route handlers", 10)
+	if len(results) == 0 || results[0].Type != SearchTemplate {
+		t.Errorf("Expected system templates to be found, got %+v", results)
+	}
+
+	// Saving reindexes the outline
+	if _, err := db.UpdateOutline(int(id), user.ID, 0, "Zephyr plan", "<div>Marzipan</div>"); err != nil {
+		t.Fatalf("Failed to update outline: %v", err)
+	}
+	if results, _ := db.Search(user.ID, "schemata", 10); len(results) != 0 {
+		t.Errorf("Expected old text not to be found, got %+v", results)
+	}
+	if results, _ := db.Search(user.ID, "marzipan", 10); len(results) != 1 {
+		t.Errorf("Expected new text to be found, got %+v", results)
+	}
+
+	// Items in the trash are not found, and purged ones are unindexed
+	db.DeleteOutline(int(id), user.ID)
+	if results, _ := db.Search(user.ID, "marzipan", 10); len(results) != 0 {
+		t.Errorf("Expected a deleted outline not to be found, got %+v", results)
+	}
+	db.RestoreOutline(int(id), user.ID)
+	if results, _ := db.Search(user.ID, "marzipan", 10); len(results) != 1 {
+		t.Errorf("Expected a restored outline to be found, got %+v", results)
+	}
+	db.DeleteOutline(int(id), user.ID)
+	db.PurgeOutline(int(id), user.ID)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM search_entries WHERE item_type = ? AND item_id = ?", SearchOutline, id).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected search entries to be deleted, got %d, %v", count, err)
+	}
+
+	// User templates are found by their owner only
+	templateID, _ := db.CreateTemplate("Kiwi checklist", "", "<div>Peel the kiwi</div>", CategoryGeneral, false, other.ID)
+	if results, _ := db.Search(user.ID, "kiwi", 10); len(results) != 0 {
+		t.Errorf("Expected another user's template not to be found, got %+v", results)
+	}
+	results, _ = db.Search(other.ID, "kiwi", 10)
+	if len(results) != 2 || results[0].ID != int(templateID) {
+		t.Errorf("Expected the template's title and node to be found, got %+v", results)
+	}
+	if err := db.UpdateTemplate(int(templateID), "Checklist", "", "<div>Slice it thinly</div>", CategoryGeneral, other.ID); err != nil {
+		t.Fatalf("Failed to update template: %v", err)
+	}
+	if results, _ := db.Search(other.ID, "thinly", 10); len(results) != 1 {
+		t.Errorf("Expected the updated template to be found, got %+v", results)
+	}
+}
+
+func TestSearchIndexesExistingItems(t *testing.T) {
+	db := newTestDB(t, "search_existing")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+	db.CreateOutline(user.ID, "Saved before search", "<div>Old wombat notes</div>")
+
+	// As if the outline was saved before search entries were kept
+	if _, err := db.Exec("DELETE FROM search_entries"); err != nil {
+		t.Fatalf("Failed to clear search entries: %v", err)
+	}
+	if results, _ := db.Search(user.ID, "wombat", 10); len(results) != 0 {
+		t.Fatalf("Expected nothing to be found, got %+v", results)
+	}
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database again: %v", err)
+	}
+	if results, _ := db.Search(user.ID, "wombat", 10); len(results) != 1 {
+		t.Errorf("Expected the outline to be indexed at startup, got %+v", results)
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	got := SearchTerms("Data-base, SCHEMA! a b c d e f g h")
+	want := []string{"data", "base", "schema", "a", "b", "c", "d", "e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Database <schema>", []string{"schema"}, "Database &lt;<mark>schema</mark>&gt;"},
+		{"Schema and SCHEMAS", []string{"schema"}, "<mark>Schema</mark> and <mark>SCHEMA</mark>S"},
+		{"Data base", []string{"data", "base"}, "<mark>Data</mark> <mark>base</mark>"},
+		{"Café", []string{"café"}, "<mark>Café</mark>"},
+		{"No match", []string{"schema"}, "No match"},
+	}
+	for _, tt := range tests {
+		if got := Highlight(tt.text, tt.terms); got != tt.want {
+			t.Errorf("Highlight(%q, %q) = %q, want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+
+	// Long text is cut down to the part around the first match
+	long := strings.Repeat("lorem ", 50) + "needle" + strings.Repeat(" ipsum", 50)
+	got := Highlight(long, []string{"needle"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("Expected a cut-down snippet around the match, got %q", got)
+	}
+}
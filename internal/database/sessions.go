@@ -0,0 +1,79 @@
+package database
+
+import (
+	"time"
+)
+
+// Session is a login session. It ends at IdleExpiresAt unless renewed by use,
+// and at AbsoluteExpiresAt regardless.
+type Session struct {
+	UserID            int
+	IdleExpiresAt     time.Time
+	AbsoluteExpiresAt time.Time
+}
+
+// Expired reports whether the session has ended at time now.
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.IdleExpiresAt) || !now.Before(s.AbsoluteExpiresAt)
+}
+
+// CreateSession records a new session. Sessions are keyed by a SHA-256 hash
+// of the session ID held in the browser's cookie, so a copy of the database
+// cannot be used to hijack them. Expiry times are stored as Unix seconds.
+func (db *DB) CreateSession(sessionID string, session *Session) error {
+	_, err := db.Exec("INSERT INTO sessions (id_hash, user_id, idle_expires_at, absolute_expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(sessionID), session.UserID, session.IdleExpiresAt.Unix(), session.AbsoluteExpiresAt.Unix())
+	return err
+}
+
+// GetSession returns a session as found; callers decide what to do with
+// expired sessions.
+func (db *DB) GetSession(sessionID string) (*Session, error) {
+	var userID int
+	var idle, absolute int64
+	err := db.QueryRow("SELECT user_id, idle_expires_at, absolute_expires_at FROM sessions WHERE id_hash = ?",
+		hashToken(sessionID)).Scan(&userID, &idle, &absolute)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		UserID:            userID,
+		IdleExpiresAt:     time.Unix(idle, 0),
+		AbsoluteExpiresAt: time.Unix(absolute, 0),
+	}, nil
+}
+
+func (db *DB) RenewSession(sessionID string, idleExpiresAt time.Time) error {
+	_, err := db.Exec("UPDATE sessions SET idle_expires_at = ? WHERE id_hash = ?",
+		idleExpiresAt.Unix(), hashToken(sessionID))
+	return err
+}
+
+func (db *DB) DeleteSession(sessionID string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE id_hash = ?", hashToken(sessionID))
+	return err
+}
+
+// DeleteUserSessions ends every session belonging to a user.
+func (db *DB) DeleteUserSessions(userID int) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteUserSessionsExcept ends every session belonging to a user other than
+// sessionID.
+func (db *DB) DeleteUserSessionsExcept(userID int, sessionID string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND id_hash != ?", userID, hashToken(sessionID))
+	return err
+}
+
+// DeleteExpiredSessions removes every session that has ended by now and
+// returns how many were removed.
+func (db *DB) DeleteExpiredSessions(now time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM sessions WHERE idle_expires_at <= ? OR absolute_expires_at <= ?",
+		now.Unix(), now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Roles a user can have on an outline, from least to most trusted. An
+// outline's owner always has RoleOwner; other users get a role by having the
+// outline shared with them.
+const (
+	// RoleViewer can read and export the outline and its history.
+	RoleViewer = "viewer"
+	// RoleEditor can also save changes and restore revisions.
+	RoleEditor = "editor"
+	// RoleOwner can also share the outline. Only the outline's owner, not
+	// those it is shared with as RoleOwner, can move it to the trash or
+	// transfer it to another owner.
+	RoleOwner = "owner"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+var (
+	// ErrForbidden is returned when a user's role on an outline does not
+	// allow what they tried to do.
+	ErrForbidden = errors.New("not allowed by the user's role on the outline")
+	// ErrInvalidRole is returned for a role that is not one of the Role
+	// constants.
+	ErrInvalidRole = errors.New("invalid outline role")
+	// ErrShareWithOwner is returned when sharing an outline with its owner.
+	ErrShareWithOwner = errors.New("the outline belongs to that user")
+)
+
+// RoleAllows reports whether role is at least as trusted as min.
+func RoleAllows(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
+
+// ValidRole reports whether role is one of the Role constants.
+func ValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// OutlineShare is a user who has access to an outline, and their role.
+type OutlineShare struct {
+	UserID   int
+	Username string
+	Role     string
+	// CreatedAt is when the outline was shared with the user, or the zero
+	// time for its owner.
+	CreatedAt time.Time
+}
+
+// SharedOutline is an outline another user has shared, as listed for the
+// user it is shared with.
+type SharedOutline struct {
+	OutlineSummary
+	Owner string
+	Role  string
+}
+
+// outlineVisible is a condition on an outline aliased o that holds if the
+// user whose ID is given twice as its arguments owns it or has it shared
+// with them.
+const outlineVisible = "(o.user_id = ? OR EXISTS (SELECT 1 FROM outline_shares s WHERE s.outline_id = o.id AND s.user_id = ?))"
+
+// rowQuerier is a DB or a Tx.
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// outlineRole returns a user's role on an outline that is not in the trash,
+// or sql.ErrNoRows if they have none.
+func outlineRole(q rowQuerier, id, userID int) (string, error) {
+	var role string
+	err := q.QueryRow(`SELECT CASE WHEN o.user_id = ? THEN 'owner' ELSE s.role END
+		FROM outlines o LEFT JOIN outline_shares s ON s.outline_id = o.id AND s.user_id = ?
+		WHERE o.id = ? AND o.deleted_at IS NULL AND (o.user_id = ? OR s.user_id IS NOT NULL)`,
+		userID, userID, id, userID).Scan(&role)
+	return role, err
+}
+
+// requireRole returns nil if a user has at least role min on an outline,
+// ErrForbidden if they have a lesser role, and sql.ErrNoRows if they have
+// none.
+func requireRole(q rowQuerier, id, userID int, min string) error {
+	role, err := outlineRole(q, id, userID)
+	if err != nil {
+		return err
+	}
+	if !RoleAllows(role, min) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// requireOwner returns nil if a user owns an outline that is not in the
+// trash, ErrForbidden if they only have it shared with them, even as
+// RoleOwner, and sql.ErrNoRows if they have no access to it.
+func requireOwner(q rowQuerier, id, userID int) error {
+	var ownerID int
+	err := q.QueryRow("SELECT o.user_id FROM outlines o WHERE o.id = ? AND o.deleted_at IS NULL AND "+outlineVisible,
+		id, userID, userID).Scan(&ownerID)
+	if err != nil {
+		return err
+	}
+	if ownerID != userID {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// GetOutlineShares lists who has access to an outline, its owner first and
+// then the others by username. The user asking must have access to it, or
+// sql.ErrNoRows is returned.
+func (db *DB) GetOutlineShares(id, userID int) ([]OutlineShare, error) {
+	if _, err := outlineRole(db, id, userID); err != nil {
+		return nil, err
+	}
+
+	owner := OutlineShare{Role: RoleOwner}
+	err := db.QueryRow("SELECT o.user_id, COALESCE(u.username, '') FROM outlines o LEFT JOIN users u ON u.id = o.user_id WHERE o.id = ?",
+		id).Scan(&owner.UserID, &owner.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT s.user_id, u.username, s.role, s.created_at
+		FROM outline_shares s JOIN users u ON u.id = s.user_id
+		WHERE s.outline_id = ? ORDER BY u.username`,
+		id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []OutlineShare{owner}
+	for rows.Next() {
+		var share OutlineShare
+		if err := rows.Scan(&share.UserID, &share.Username, &share.Role, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// ShareOutline gives another user a role on an outline, replacing any role
+// they had. The user sharing it must have RoleOwner.
+func (db *DB) ShareOutline(id, userID, withUserID int, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireRole(tx, id, userID, RoleOwner); err != nil {
+		return err
+	}
+	var ownerID int
+	if err := tx.QueryRow("SELECT user_id FROM outlines WHERE id = ?", id).Scan(&ownerID); err != nil {
+		return err
+	}
+	if withUserID == ownerID {
+		return ErrShareWithOwner
+	}
+
+	_, err = tx.Exec(`INSERT INTO outline_shares (outline_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(outline_id, user_id) DO UPDATE SET role = excluded.role`,
+		id, withUserID, role)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnshareOutline takes away another user's access to an outline. The user
+// doing so must have RoleOwner, unless they are removing their own access.
+// It returns sql.ErrNoRows if the outline was not shared with that user.
+func (db *DB) UnshareOutline(id, userID, withUserID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if withUserID != userID {
+		if err := requireRole(tx, id, userID, RoleOwner); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM outline_shares WHERE outline_id = ? AND user_id = ?", id, withUserID)
+	if err := affectedOne(result, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TransferOutline makes another user an outline's owner. The user doing so
+// must own it. Nobody loses access: the previous owner keeps RoleOwner
+// through a share, which they can then give up.
+func (db *DB) TransferOutline(id, userID, toUserID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireOwner(tx, id, userID); err != nil {
+		return err
+	}
+	if toUserID == userID {
+		return ErrShareWithOwner
+	}
+	var exists int
+	if err := tx.QueryRow("SELECT 1 FROM users WHERE id = ?", toUserID).Scan(&exists); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM outline_shares WHERE outline_id = ? AND user_id = ?", id, toUserID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO outline_shares (outline_id, user_id, role) VALUES (?, ?, ?)", id, userID, RoleOwner); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE outlines SET user_id = ? WHERE id = ?", toUserID, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSharedOutlines lists the outlines other users have shared with a user,
+// most recently updated first.
+func (db *DB) GetSharedOutlines(userID int) ([]SharedOutline, error) {
+	rows, err := db.Query(`SELECT o.id, o.user_id, o.title, o.version, o.node_count, o.depth, COALESCE(o.preview, ''), o.created_at, o.updated_at,
+			COALESCE(u.username, ''), s.role
+		FROM outline_shares s
+		JOIN outlines o ON o.id = s.outline_id
+		LEFT JOIN users u ON u.id = o.user_id
+		WHERE s.user_id = ? AND o.deleted_at IS NULL
+		ORDER BY o.updated_at DESC, o.id DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outlines []SharedOutline
+	for rows.Next() {
+		var outline SharedOutline
+		if err := rows.Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Version, &outline.NodeCount, &outline.Depth, &outline.Preview, &outline.CreatedAt, &outline.UpdatedAt, &outline.Owner, &outline.Role); err != nil {
+			return nil, err
+		}
+		outlines = append(outlines, outline)
+	}
+	return outlines, rows.Err()
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestOutlineRoles(t *testing.T) {
+	db := newTestDB(t, "outline_roles")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	owner, _ := db.GetUser("admin")
+	for _, name := range []string{"viewer", "editor", "stranger"} {
+		if err := db.CreateUser(name, "password", false, false); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	viewer, _ := db.GetUser("viewer")
+	editor, _ := db.GetUser("editor")
+	stranger, _ := db.GetUser("stranger")
+
+	id64, _ := db.CreateOutline(owner.ID, "Pairing", "<div>Decompose</div>")
+	id := int(id64)
+	if err := db.ShareOutline(id, owner.ID, viewer.ID, RoleViewer); err != nil {
+		t.Fatalf("Failed to share outline: %v", err)
+	}
+	if err := db.ShareOutline(id, owner.ID, editor.ID, RoleEditor); err != nil {
+		t.Fatalf("Failed to share outline: %v", err)
+	}
+
+	for user, want := range map[int]string{owner.ID: RoleOwner, viewer.ID: RoleViewer, editor.ID: RoleEditor} {
+		outline, err := db.GetOutline(id, user)
+		if err != nil || outline.Role != want || outline.UserID != owner.ID {
+			t.Errorf("Expected user %d to get the outline as %s, got %+v, %v", user, want, outline, err)
+		}
+	}
+	if _, err := db.GetOutline(id, stranger.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a user it is not shared with, got %v", err)
+	}
+
+	if _, err := db.UpdateOutline(id, viewer.ID, 0, "Pairing", "<div>Viewed</div>"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a viewer's save to be forbidden, got %v", err)
+	}
+	if _, err := db.UpdateOutline(id, stranger.ID, 0, "Pairing", "<div>Strange</div>"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a stranger's save, got %v", err)
+	}
+	if _, err := db.UpdateOutline(id, editor.ID, 0, "Pairing", "<div>Edited</div>"); err != nil {
+		t.Errorf("Expected an editor to be able to save, got %v", err)
+	}
+	outline, _ := db.GetOutline(id, owner.ID)
+	if outline.Content != "<div>Edited</div>" || outline.UserID != owner.ID {
+		t.Errorf("Expected the editor's change, still owned by its owner, got %+v", outline)
+	}
+
+	// Sharees see the history, with the editor as an author
+	revisions, err := db.GetOutlineRevisions(id, viewer.ID)
+	if err != nil || len(revisions) != 2 || revisions[0].Author != "editor" {
+		t.Errorf("Expected the viewer to see both revisions, got %+v, %v", revisions, err)
+	}
+	if revisions, _ := db.GetOutlineRevisions(id, stranger.ID); len(revisions) != 0 {
+		t.Errorf("Expected no revisions for a stranger, got %+v", revisions)
+	}
+
+	if err := db.DeleteOutline(id, editor.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected an editor's delete to be forbidden, got %v", err)
+	}
+	if err := db.DeleteOutline(id, stranger.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a stranger's delete, got %v", err)
+	}
+
+	// Only owners change who has access
+	if err := db.ShareOutline(id, editor.ID, stranger.ID, RoleViewer); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected an editor's share to be forbidden, got %v", err)
+	}
+	if err := db.UnshareOutline(id, editor.ID, viewer.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected an editor's unshare to be forbidden, got %v", err)
+	}
+
+	// Once in the trash, nobody it is shared with can see it
+	if err := db.DeleteOutline(id, owner.ID); err != nil {
+		t.Fatalf("Failed to delete outline: %v", err)
+	}
+	if _, err := db.GetOutline(id, editor.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected a trashed outline to be hidden from sharees, got %v", err)
+	}
+	if shared, _ := db.GetSharedOutlines(editor.ID); len(shared) != 0 {
+		t.Errorf("Expected no shared outlines once in the trash, got %+v", shared)
+	}
+}
+
+func TestShareOutline(t *testing.T) {
+	db := newTestDB(t, "share_outline")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	owner, _ := db.GetUser("admin")
+	db.CreateUser("bob", "password", false, false)
+	db.CreateUser("carol", "password", false, false)
+	bob, _ := db.GetUser("bob")
+	carol, _ := db.GetUser("carol")
+
+	id64, _ := db.CreateOutline(owner.ID, "Shared plan", "<div>Step</div>")
+	id := int(id64)
+
+	if err := db.ShareOutline(id, owner.ID, owner.ID, RoleEditor); !errors.Is(err, ErrShareWithOwner) {
+		t.Errorf("Expected ErrShareWithOwner, got %v", err)
+	}
+	for _, role := range []string{"admin", "commenter"} {
+		if err := db.ShareOutline(id, owner.ID, bob.ID, role); !errors.Is(err, ErrInvalidRole) {
+			t.Errorf("Expected ErrInvalidRole for %s, got %v", role, err)
+		}
+	}
+
+	db.ShareOutline(id, owner.ID, carol.ID, RoleViewer)
+	db.ShareOutline(id, owner.ID, bob.ID, RoleViewer)
+	// Sharing again changes the role
+	if err := db.ShareOutline(id, owner.ID, bob.ID, RoleEditor); err != nil {
+		t.Fatalf("Failed to change role: %v", err)
+	}
+
+	shares, err := db.GetOutlineShares(id, carol.ID)
+	if err != nil || len(shares) != 3 {
+		t.Fatalf("Expected three users with access, got %+v, %v", shares, err)
+	}
+	want := []struct {
+		username, role string
+	}{{"admin", RoleOwner}, {"bob", RoleEditor}, {"carol", RoleViewer}}
+	for i, w := range want {
+		if shares[i].Username != w.username || shares[i].Role != w.role {
+			t.Errorf("Expected share %d to be %s as %s, got %+v", i, w.username, w.role, shares[i])
+		}
+	}
+	if !shares[0].CreatedAt.IsZero() || shares[1].CreatedAt.IsZero() {
+		t.Errorf("Expected only shares to have a creation time, got %+v", shares)
+	}
+
+	shared, err := db.GetSharedOutlines(bob.ID)
+	if err != nil || len(shared) != 1 || shared[0].ID != id || shared[0].Owner != "admin" || shared[0].Role != RoleEditor || shared[0].Preview != "Step" {
+		t.Errorf("Expected the outline shared with bob, got %+v, %v", shared, err)
+	}
+	// Shared outlines are not in the user's own list
+	if page, _, _ := db.ListOutlineSummaries(bob.ID, ListOptions{Limit: 10}); len(page) != 0 {
+		t.Errorf("Expected bob to own no outlines, got %+v", page)
+	}
+
+	// Anyone can leave an outline shared with them
+	if err := db.UnshareOutline(id, carol.ID, carol.ID); err != nil {
+		t.Errorf("Expected carol to be able to leave, got %v", err)
+	}
+	if _, err := db.GetOutline(id, carol.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected carol to have lost access, got %v", err)
+	}
+	if err := db.UnshareOutline(id, owner.ID, carol.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows removing a share that is gone, got %v", err)
+	}
+	if _, err := db.GetOutlineShares(id, carol.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows listing shares without access, got %v", err)
+	}
+
+	// Deleting a user removes their shares
+	if err := db.DeleteUser(bob.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if shares, _ := db.GetOutlineShares(id, owner.ID); len(shares) != 1 {
+		t.Errorf("Expected only the owner left, got %+v", shares)
+	}
+}
+
+func TestTransferOutline(t *testing.T) {
+	db := newTestDB(t, "transfer_outline")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	owner, _ := db.GetUser("admin")
+	db.CreateUser("bob", "password", false, false)
+	db.CreateUser("carol", "password", false, false)
+	bob, _ := db.GetUser("bob")
+	carol, _ := db.GetUser("carol")
+
+	id64, _ := db.CreateOutline(owner.ID, "Handover", "<div>Everything</div>")
+	id := int(id64)
+	db.ShareOutline(id, owner.ID, bob.ID, RoleEditor)
+	db.ShareOutline(id, owner.ID, carol.ID, RoleOwner)
+
+	if err := db.TransferOutline(id, bob.ID, carol.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected an editor's transfer to be forbidden, got %v", err)
+	}
+	// Sharing as an owner does not make the outline anyone else's to give
+	// away or delete
+	if err := db.TransferOutline(id, carol.ID, bob.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a co-owner's transfer to be forbidden, got %v", err)
+	}
+	if err := db.DeleteOutline(id, carol.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected a co-owner's delete to be forbidden, got %v", err)
+	}
+	db.UnshareOutline(id, owner.ID, carol.ID)
+	if err := db.TransferOutline(id, owner.ID, owner.ID); !errors.Is(err, ErrShareWithOwner) {
+		t.Errorf("Expected ErrShareWithOwner, got %v", err)
+	}
+	if err := db.TransferOutline(id, owner.ID, 9999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing user, got %v", err)
+	}
+
+	if err := db.TransferOutline(id, owner.ID, bob.ID); err != nil {
+		t.Fatalf("Failed to transfer outline: %v", err)
+	}
+	outline, err := db.GetOutline(id, bob.ID)
+	if err != nil || outline.UserID != bob.ID || outline.Role != RoleOwner {
+		t.Errorf("Expected bob to own the outline, got %+v, %v", outline, err)
+	}
+	if page, _, _ := db.ListOutlineSummaries(bob.ID, ListOptions{Limit: 10}); len(page) != 1 {
+		t.Errorf("Expected the outline in bob's list, got %+v", page)
+	}
+
+	// The previous owner keeps access until they leave
+	outline, err = db.GetOutline(id, owner.ID)
+	if err != nil || outline.Role != RoleOwner {
+		t.Errorf("Expected the previous owner to keep access, got %+v, %v", outline, err)
+	}
+	if err := db.UnshareOutline(id, owner.ID, owner.ID); err != nil {
+		t.Errorf("Expected the previous owner to be able to leave, got %v", err)
+	}
+	if _, err := db.GetOutline(id, owner.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the previous owner to have lost access, got %v", err)
+	}
+
+	// Purging the outline removes its shares
+	db.ShareOutline(id, bob.ID, carol.ID, RoleViewer)
+	db.DeleteOutline(id, bob.ID)
+	if err := db.PurgeOutline(id, bob.ID); err != nil {
+		t.Fatalf("Failed to purge outline: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM outline_shares").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no shares left, got %d", count)
+	}
+}
+
+func TestSearchSharedOutlines(t *testing.T) {
+	db := newTestDB(t, "search_shared")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	owner, _ := db.GetUser("admin")
+	db.CreateUser("bob", "password", false, false)
+	bob, _ := db.GetUser("bob")
+
+	id, _ := db.CreateOutline(owner.ID, "Observatory", "<div>Telescopes for quokkas</div>")
+	if results, _ := db.Search(bob.ID, "quokkas", 10); len(results) != 0 {
+		t.Errorf("Expected no results before sharing, got %+v", results)
+	}
+
+	db.ShareOutline(int(id), owner.ID, bob.ID, RoleViewer)
+	results, err := db.Search(bob.ID, "quokkas", 10)
+	if err != nil || len(results) != 1 || results[0].ID != int(id) {
+		t.Errorf("Expected the shared outline to be found, got %+v, %v", results, err)
+	}
+}
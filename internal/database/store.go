@@ -0,0 +1,114 @@
+package database
+
+import "time"
+
+// Store is everything the rest of Composter needs from the database. DB
+// implements it for each supported backend; handlers and middleware depend
+// on Store rather than on DB.
+type Store interface {
+	// Users
+	CreateUser(username, password string, isAdmin, mustChangePassword bool) error
+	GetUser(username string) (*User, error)
+	GetUserByID(id int) (*User, error)
+	GetAllUsers() ([]User, error)
+	UpdateUser(id int, username string, password string, isAdmin, mustChangePassword bool) error
+	DeleteUser(id int) error
+	SetMustChangePassword(id int, must bool) error
+	ChangePassword(id int, password string) error
+	VerifyPassword(username, password string) (*User, error)
+	SetAdmin(id int, isAdmin bool) error
+
+	// Outlines
+	CreateOutline(userID int, title, content string) (int64, error)
+	GetOutline(id, userID int) (*Outline, error)
+	GetUserOutlines(userID int) ([]Outline, error)
+	ListOutlineSummaries(userID int, opts ListOptions) ([]OutlineSummary, string, error)
+	CountUserOutlines(userID int) (int, error)
+	UpdateOutline(id, userID, version int, title, content string) (int, error)
+	DeleteOutline(id, userID int) error
+	GetOutlineRevisions(outlineID, userID int) ([]Revision, error)
+	GetOutlineRevision(id, outlineID, userID int) (*Revision, error)
+	GetOutlineVersion(outlineID, userID, version int) (*Revision, error)
+
+	// Sharing
+	GetOutlineShares(id, userID int) ([]OutlineShare, error)
+	ShareOutline(id, userID, withUserID int, role string) error
+	UnshareOutline(id, userID, withUserID int) error
+	TransferOutline(id, userID, toUserID int) error
+	GetSharedOutlines(userID int) ([]SharedOutline, error)
+
+	// Templates
+	CreateTemplate(name, description, content, category string, isSystem bool, userID int) (int64, error)
+	GetTemplate(id int) (*Template, error)
+	GetAllTemplates() ([]Template, error)
+	GetSystemTemplates() ([]Template, error)
+	GetUserTemplates(userID int) ([]Template, error)
+	GetSystemTemplateSummaries() ([]TemplateSummary, error)
+	GetUserTemplateSummaries(userID int) ([]TemplateSummary, error)
+	GetTemplatesByCategory(category string) ([]Template, error)
+	UpdateTemplate(id int, name, description, content, category string, userID int) error
+	DeleteTemplate(id, userID int) error
+
+	// Search
+	Search(userID int, query string, limit int) ([]SearchResult, error)
+
+	// Trash
+	GetTrashedOutlines(userID int) ([]Outline, error)
+	GetTrashedTemplates(userID int) ([]Template, error)
+	RestoreOutline(id, userID int) error
+	RestoreTemplate(id, userID int) error
+	PurgeOutline(id, userID int) error
+	PurgeTemplate(id, userID int) error
+	EmptyTrash(userID int) error
+	PurgeTrash(before time.Time) (int64, error)
+	GetDeletedUsers() ([]DeletedUser, error)
+	RecoverDeletedUser(id, toUserID int) error
+	PurgeDeletedUser(id int) error
+
+	// Sessions
+	CreateSession(sessionID string, session *Session) error
+	GetSession(sessionID string) (*Session, error)
+	RenewSession(sessionID string, idleExpiresAt time.Time) error
+	DeleteSession(sessionID string) error
+	DeleteUserSessions(userID int) error
+	DeleteUserSessionsExcept(userID int, sessionID string) error
+	DeleteExpiredSessions(now time.Time) (int64, error)
+
+	// API tokens
+	CreateAPIToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, int64, error)
+	GetUserAPITokens(userID int) ([]APIToken, error)
+	DeleteAPIToken(id, userID int) error
+	AuthenticateAPIToken(plain string) (*User, *APIToken, error)
+
+	// Login throttling and auditing
+	GetLoginLockout(username string) (*LoginLockout, error)
+	GetLockedLogins(now time.Time) ([]LoginLockout, error)
+	RecordLoginFailure(username string, now time.Time, window time.Duration) (int, error)
+	LockLogin(username string, until time.Time) error
+	ClearLoginLockout(username string) error
+	DeleteStaleLoginLockouts(before time.Time) (int64, error)
+	RecordLoginAttempt(username, remoteAddr string, success bool, reason string) error
+	DeleteOldLoginAttempts(before time.Time) (int64, error)
+	GetRecentLoginFailures(limit int) ([]LoginAttempt, error)
+
+	// Two-factor authentication
+	GetTOTP(userID int) (*TOTP, error)
+	SetTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	NewRecoveryCodes(userID int) ([]string, error)
+	UseRecoveryCode(userID int, code string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+
+	// Single sign-on identities
+	GetUserByIdentity(issuer, subject string) (*User, error)
+	LinkIdentity(userID int, issuer, subject string) error
+	CreateExternalUser(username string, isAdmin bool) (*User, error)
+
+	// Server-wide settings
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
+}
+
+var _ Store = (*DB)(nil)
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	outlinetree "github.com/kristofer/composter/internal/outline"
+)
+
+// OutlineSummary is what a list of outlines shows of each one, without its
+// content.
+type OutlineSummary struct {
+	ID      int
+	UserID  int
+	Title   string
+	Version int
+	// NodeCount is the number of non-blank nodes, and Depth how many
+	// levels they go down to: 1 for a flat list, 0 for an empty outline.
+	NodeCount int
+	Depth     int
+	// Preview is the text of the first non-blank node, cut down to
+	// previewLength characters.
+	Preview   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TemplateSummary is what a list of templates shows of each one, without
+// its content. The summary fields are as for OutlineSummary.
+type TemplateSummary struct {
+	ID          int
+	Name        string
+	Description string
+	Category    string
+	IsSystem    bool
+	UserID      int
+	NodeCount   int
+	Depth       int
+	Preview     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Orders in which ListOutlineSummaries can list outlines.
+const (
+	SortUpdated = "updated" // most recently updated first
+	SortCreated = "created" // most recently created first
+	SortTitle   = "title"   // by title, ignoring case
+)
+
+// outlineSorts maps each sort order to the column it sorts by, and whether
+// it sorts in descending order. Outlines with equal values are ordered by
+// ID in the same direction.
+var outlineSorts = map[string]struct {
+	column string
+	desc   bool
+}{
+	SortUpdated: {"updated_at", true},
+	SortCreated: {"created_at", true},
+	SortTitle:   {"LOWER(title)", false},
+}
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort order")
+	ErrInvalidCursor = errors.New("invalid page cursor")
+	ErrInvalidLimit  = errors.New("page size must be positive")
+)
+
+// ListOptions chooses a page of a list.
+type ListOptions struct {
+	// Sort is SortUpdated, SortCreated or SortTitle. It defaults to
+	// SortUpdated.
+	Sort string
+	// Limit is the most items a page holds. It must be positive.
+	Limit int
+	// Cursor is the cursor returned with the previous page, or "" for the
+	// first page. It only works with the same Sort.
+	Cursor string
+}
+
+// pageCursor marks where a page ended: the sort order, and the value sorted
+// by and the ID of the page's last outline.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// previewLength is how many characters of an item's first node its summary
+// keeps.
+const previewLength = 100
+
+// summary holds the summary fields of an outline or template.
+type summary struct {
+	nodeCount, depth int
+	preview          string
+}
+
+// summarize computes the summary fields of outline or template content.
+func summarize(content string) summary {
+	var s summary
+	var walk func(nodes []*outlinetree.Node, level int)
+	walk = func(nodes []*outlinetree.Node, level int) {
+		for _, n := range nodes {
+			text := strings.TrimSpace(n.Text)
+			if text != "" {
+				s.nodeCount++
+				if level > s.depth {
+					s.depth = level
+				}
+				if s.preview == "" {
+					s.preview = text
+				}
+			}
+			walk(n.Children, level+1)
+		}
+	}
+	walk(outlinetree.Parse(content).Roots, 1)
+
+	if runes := []rune(s.preview); len(runes) > previewLength {
+		s.preview = strings.TrimSpace(string(runes[:previewLength])) + "…"
+	}
+	return s
+}
+
+// initSummaries summarizes the outlines and templates saved before
+// summaries were kept.
+func (db *DB) initSummaries() error {
+	for _, table := range []string{"outlines", "templates"} {
+		if err := db.summarizeMissing(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarizeMissing fills in the summary fields of the rows of table that
+// have none.
+func (db *DB) summarizeMissing(table string) error {
+	rows, err := db.Query("SELECT id, content FROM " + table + " WHERE preview IS NULL")
+	if err != nil {
+		return err
+	}
+	summaries := make(map[int]summary)
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		summaries[id] = summarize(content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, s := range summaries {
+		_, err := tx.Exec("UPDATE "+table+" SET node_count = ?, depth = ?, preview = ? WHERE id = ?",
+			s.nodeCount, s.depth, s.preview, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListOutlineSummaries returns a page of a user's outlines, sorted as opts
+// asks, and the cursor of the next page, which is "" on the last page. It
+// returns ErrInvalidSort, ErrInvalidCursor or ErrInvalidLimit if opts has
+// any of them wrong.
+func (db *DB) ListOutlineSummaries(userID int, opts ListOptions) ([]OutlineSummary, string, error) {
+	if opts.Limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+	if opts.Sort == "" {
+		opts.Sort = SortUpdated
+	}
+	sort, ok := outlineSorts[opts.Sort]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	query := "SELECT id, user_id, title, version, node_count, depth, COALESCE(preview, ''), created_at, updated_at, CAST(" + sort.column + " AS TEXT) FROM outlines WHERE user_id = ? AND deleted_at IS NULL"
+	args := []interface{}{userID}
+
+	op, dir := ">", "ASC"
+	if sort.desc {
+		op, dir = "<", "DESC"
+	}
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil || cursor.Sort != opts.Sort {
+			return nil, "", ErrInvalidCursor
+		}
+		query += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sort.column, op)
+		args = append(args, cursor.Value, cursor.Value, cursor.ID)
+	}
+
+	// One more than asked for tells whether there is another page
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", sort.column, dir, dir)
+	args = append(args, opts.Limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var outlines []OutlineSummary
+	var values []string
+	for rows.Next() {
+		var outline OutlineSummary
+		var value string
+		if err := rows.Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Version, &outline.NodeCount, &outline.Depth, &outline.Preview, &outline.CreatedAt, &outline.UpdatedAt, &value); err != nil {
+			return nil, "", err
+		}
+		outlines = append(outlines, outline)
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(outlines) <= opts.Limit {
+		return outlines, "", nil
+	}
+	outlines = outlines[:opts.Limit]
+	last := outlines[len(outlines)-1]
+	next, err := encodeCursor(pageCursor{Sort: opts.Sort, Value: values[len(outlines)-1], ID: last.ID})
+	if err != nil {
+		return nil, "", err
+	}
+	return outlines, next, nil
+}
+
+func encodeCursor(c pageCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// GetSystemTemplateSummaries lists the system templates by category and
+// name. Unlike outlines, templates are not listed a page at a time: the
+// templates page shows them all grouped by category, and there are few of
+// them, the system's being seeded and a user's being kept to reuse.
+func (db *DB) GetSystemTemplateSummaries() ([]TemplateSummary, error) {
+	return db.templateSummaries("is_system = TRUE")
+}
+
+// GetUserTemplateSummaries lists a user's own templates by category and
+// name.
+func (db *DB) GetUserTemplateSummaries(userID int) ([]TemplateSummary, error) {
+	return db.templateSummaries("user_id = ?", userID)
+}
+
+func (db *DB) templateSummaries(where string, args ...interface{}) ([]TemplateSummary, error) {
+	rows, err := db.Query("SELECT id, name, description, category, is_system, user_id, node_count, depth, COALESCE(preview, ''), created_at, updated_at FROM templates WHERE "+where+" AND deleted_at IS NULL ORDER BY category, name",
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []TemplateSummary
+	for rows.Next() {
+		var template TemplateSummary
+		if err := rows.Scan(&template.ID, &template.Name, &template.Description, &template.Category, &template.IsSystem, &template.UserID, &template.NodeCount, &template.Depth, &template.Preview, &template.CreatedAt, &template.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    summary
+	}{
+		{"empty", "", summary{}},
+		{"blank lines", "<div><br></div><div> </div>", summary{}},
+		{"flat", "<div>One</div><div>Two</div>", summary{2, 1, "One"}},
+		{"nested", `<div><br></div>
+<div>Project</div>
+<div style="margin-left: 30px">Models</div>
+<div style="margin-left: 60px">Data &amp; schema</div>
+<div style="margin-left: 30px">Views</div>`, summary{4, 3, "Project"}},
+		{"long first line", "<div>" + strings.Repeat("word ", 30) + "</div>", summary{1, 1, strings.TrimSpace(strings.Repeat("word ", 20)) + "…"}},
+	}
+	for _, tt := range tests {
+		if got := summarize(tt.content); got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestListOutlineSummaries(t *testing.T) {
+	db := newTestDB(t, "outline_summaries")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+
+	// Outlines b and d were updated at the same time, so they are ordered by ID
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	outlines := []struct {
+		title   string
+		created time.Time
+		updated time.Time
+	}{
+		{"banana", base, base.Add(3 * time.Hour)},
+		{"Apple", base.Add(time.Hour), base.Add(time.Hour)},
+		{"cherry", base.Add(2 * time.Hour), base.Add(4 * time.Hour)},
+		{"apricot", base.Add(3 * time.Hour), base.Add(time.Hour)},
+		{"Date", base.Add(4 * time.Hour), base.Add(2 * time.Hour)},
+	}
+	ids := make(map[string]int)
+	for _, o := range outlines {
+		id, err := db.CreateOutline(user.ID, o.title, "<div>"+o.title+"</div>")
+		if err != nil {
+			t.Fatalf("Failed to create outline: %v", err)
+		}
+		if _, err := db.Exec("UPDATE outlines SET created_at = ?, updated_at = ? WHERE id = ?", o.created, o.updated, id); err != nil {
+			t.Fatalf("Failed to set timestamps: %v", err)
+		}
+		ids[o.title] = int(id)
+	}
+	trashed, _ := db.CreateOutline(user.ID, "In the trash", "")
+	db.DeleteOutline(int(trashed), user.ID)
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"cherry", "banana", "Date", "apricot", "Apple"}},
+		{SortUpdated, []string{"cherry", "banana", "Date", "apricot", "Apple"}},
+		{SortCreated, []string{"Date", "apricot", "cherry", "Apple", "banana"}},
+		{SortTitle, []string{"Apple", "apricot", "banana", "cherry", "Date"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages == 5 {
+				t.Fatalf("%q: too many pages", tt.sort)
+			}
+			page, next, err := db.ListOutlineSummaries(user.ID, ListOptions{Sort: tt.sort, Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("%q: failed to list outlines: %v", tt.sort, err)
+			}
+			if len(page) > 2 {
+				t.Fatalf("%q: expected at most 2 outlines, got %d", tt.sort, len(page))
+			}
+			for _, o := range page {
+				got = append(got, o.Title)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.sort, tt.want, got)
+		}
+	}
+
+	// A page that fits exactly has no next page
+	page, next, err := db.ListOutlineSummaries(user.ID, ListOptions{Limit: 5})
+	if err != nil || len(page) != 5 || next != "" {
+		t.Errorf("Expected all outlines on one page, got %d, %q, %v", len(page), next, err)
+	}
+	summary := page[1]
+	if summary.ID != ids["banana"] || summary.NodeCount != 1 || summary.Depth != 1 || summary.Preview != "banana" || summary.Version != 1 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if !summary.UpdatedAt.Equal(base.Add(3 * time.Hour)) {
+		t.Errorf("Expected updated time %v, got %v", base.Add(3*time.Hour), summary.UpdatedAt)
+	}
+
+	// Saving updates the summary
+	db.UpdateOutline(ids["banana"], user.ID, 0, "banana", `<div>Fruit</div><div style="margin-left: 30px">Yellow</div>`)
+	page, _, _ = db.ListOutlineSummaries(user.ID, ListOptions{Sort: SortTitle, Limit: 5})
+	if page[2].NodeCount != 2 || page[2].Depth != 2 || page[2].Preview != "Fruit" {
+		t.Errorf("Expected the summary to be updated, got %+v", page[2])
+	}
+
+	if _, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Sort: "size", Limit: 2}); !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("Expected ErrInvalidSort, got %v", err)
+	}
+	for _, limit := range []int{0, -1} {
+		if _, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Limit: limit}); !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("Expected ErrInvalidLimit for limit %d, got %v", limit, err)
+		}
+	}
+	if _, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Limit: 2, Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+	_, next, _ = db.ListOutlineSummaries(user.ID, ListOptions{Sort: SortTitle, Limit: 2})
+	if _, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Sort: SortCreated, Limit: 2, Cursor: next}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected a cursor for another order to be refused, got %v", err)
+	}
+}
+
+func TestTemplateSummaries(t *testing.T) {
+	db := newTestDB(t, "template_summaries")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+
+	system, err := db.GetSystemTemplateSummaries()
+	if err != nil {
+		t.Fatalf("Failed to list system templates: %v", err)
+	}
+	templates, _ := db.GetSystemTemplates()
+	if len(system) != len(templates) {
+		t.Fatalf("Expected %d system templates, got %d", len(templates), len(system))
+	}
+	for i, s := range system {
+		want := summarize(templates[i].Content)
+		if s.ID != templates[i].ID || s.NodeCount != want.nodeCount || s.Depth != want.depth || s.Preview != want.preview || s.NodeCount == 0 {
+			t.Errorf("Unexpected summary %+v of %q", s, templates[i].Name)
+		}
+	}
+
+	id, _ := db.CreateTemplate("Mine", "", "<div>Step</div>", CategoryGeneral, false, user.ID)
+	db.UpdateTemplate(int(id), "Mine", "", `<div>Step</div><div style="margin-left: 30px">Detail</div>`, CategoryGeneral, user.ID)
+	mine, err := db.GetUserTemplateSummaries(user.ID)
+	if err != nil || len(mine) != 1 || mine[0].NodeCount != 2 || mine[0].Depth != 2 || mine[0].Preview != "Step" {
+		t.Errorf("Expected the updated template's summary, got %+v, %v", mine, err)
+	}
+}
+
+func TestSummariesFilledInAtStartup(t *testing.T) {
+	db := newTestDB(t, "summaries_startup")
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	user, _ := db.GetUser("admin")
+	db.CreateOutline(user.ID, "Saved before summaries", "<div>First</div><div>Second</div>")
+
+	// As if the outline was saved before summaries were kept
+	if _, err := db.Exec("UPDATE outlines SET node_count = 0, depth = 0, preview = NULL"); err != nil {
+		t.Fatalf("Failed to clear summaries: %v", err)
+	}
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database again: %v", err)
+	}
+	page, _, err := db.ListOutlineSummaries(user.ID, ListOptions{Limit: 10})
+	if err != nil || len(page) != 1 || page[0].NodeCount != 2 || page[0].Preview != "First" {
+		t.Errorf("Expected the outline to be summarized at startup, got %+v, %v", page, err)
+	}
+}
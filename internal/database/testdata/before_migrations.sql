@@ -0,0 +1,118 @@
+-- A database as left by the last release before migrations, whose tables
+-- already have every column of the baseline. Passwords are "fixture-pass".
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	is_admin BOOLEAN DEFAULT 0,
+	must_change_password BOOLEAN NOT NULL DEFAULT 0,
+	totp_secret TEXT NOT NULL DEFAULT '',
+	totp_enabled BOOLEAN NOT NULL DEFAULT 0,
+	totp_last_step INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE outlines (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE templates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL,
+	content TEXT NOT NULL,
+	category TEXT NOT NULL,
+	is_system BOOLEAN DEFAULT 0,
+	user_id INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	last_used_at DATETIME,
+	expires_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE sessions (
+	id_hash TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	idle_expires_at INTEGER NOT NULL,
+	absolute_expires_at INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE login_lockouts (
+	username TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failure_at INTEGER NOT NULL,
+	locked_until INTEGER
+);
+
+CREATE TABLE login_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	remote_addr TEXT NOT NULL,
+	success BOOLEAN NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_identities (
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (issuer, subject),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE INDEX idx_outlines_user_id ON outlines(user_id);
+CREATE INDEX idx_templates_category ON templates(category);
+CREATE INDEX idx_templates_user_id ON templates(user_id);
+CREATE INDEX idx_templates_is_system ON templates(is_system);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_idle_expires_at ON sessions(idle_expires_at);
+CREATE INDEX idx_login_attempts_created_at ON login_attempts(created_at);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+INSERT INTO users (id, username, password, is_admin, must_change_password, totp_enabled, created_at) VALUES
+	(1, 'admin', '$2a$04$gpKjpzzI5TdAd4JmBfkVMeSyijh0nDurIJ47KzJRmUCDmHCoOsFKy', 1, 0, 0, '2024-01-01 09:00:00'),
+	(2, 'alice', '$2a$04$gpKjpzzI5TdAd4JmBfkVMeSyijh0nDurIJ47KzJRmUCDmHCoOsFKy', 0, 0, 1, '2024-01-02 09:00:00');
+
+INSERT INTO outlines (id, user_id, title, content, created_at, updated_at) VALUES
+	(1, 2, 'Shopping', '<ul><li>Milk</li><li>Bread</li></ul>', '2024-01-03 09:00:00', '2024-01-04 09:00:00'),
+	(2, 3, 'Left behind', '<ul><li>Owner deleted</li></ul>', '2024-01-03 09:00:00', '2024-01-03 09:00:00');
+
+INSERT INTO settings (key, value) VALUES ('require_2fa', 'admins');
@@ -0,0 +1,50 @@
+-- A database as left by the first release of Composter, before migrations
+-- and before any columns were added to its tables. Passwords are
+-- "fixture-pass".
+
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	is_admin BOOLEAN DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE outlines (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE templates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL,
+	content TEXT NOT NULL,
+	category TEXT NOT NULL,
+	is_system BOOLEAN DEFAULT 0,
+	user_id INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_outlines_user_id ON outlines(user_id);
+CREATE INDEX idx_templates_category ON templates(category);
+CREATE INDEX idx_templates_user_id ON templates(user_id);
+CREATE INDEX idx_templates_is_system ON templates(is_system);
+
+INSERT INTO users (id, username, password, is_admin, created_at) VALUES
+	(1, 'admin', '$2a$04$gpKjpzzI5TdAd4JmBfkVMeSyijh0nDurIJ47KzJRmUCDmHCoOsFKy', 1, '2024-01-01 09:00:00'),
+	(2, 'alice', '$2a$04$gpKjpzzI5TdAd4JmBfkVMeSyijh0nDurIJ47KzJRmUCDmHCoOsFKy', 0, '2024-01-02 09:00:00');
+
+INSERT INTO outlines (id, user_id, title, content, created_at, updated_at) VALUES
+	(1, 2, 'Shopping', '<ul><li>Milk</li><li>Bread</li></ul>', '2024-01-03 09:00:00', '2024-01-04 09:00:00'),
+	(2, 3, 'Left behind', '<ul><li>Owner deleted</li></ul>', '2024-01-03 09:00:00', '2024-01-03 09:00:00');
+
+INSERT INTO templates (id, name, description, content, category, is_system, user_id) VALUES
+	(1, 'Alice''s template', 'Mine', '<ul><li>Step</li></ul>', 'General', 0, 2);
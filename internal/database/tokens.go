@@ -0,0 +1,179 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Token scopes. A read token may only make safe (GET/HEAD) requests; a write
+// token may make any request.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// tokenTouchInterval limits how often a token's last used time is updated,
+// so that a script making many requests does not write on every one.
+const tokenTouchInterval = time.Minute
+
+// tokenPrefix marks personal API tokens so they are easy to recognise in
+// logs and secret scanners.
+const tokenPrefix = "cpt_"
+
+var (
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrTokenExpired = errors.New("API token has expired")
+	ErrInvalidScope = errors.New("invalid API token scope")
+)
+
+// APIToken is a personal access token. Only a SHA-256 hash of the token is
+// stored; the token itself is shown once when it is created.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Scopes     []string
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token's expiry time has passed.
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a new token for a user and returns it in plain text
+// along with its ID. A nil expiresAt creates a token that never expires.
+func (db *DB) CreateAPIToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, int64, error) {
+	if len(scopes) == 0 {
+		return "", 0, ErrInvalidScope
+	}
+	for _, s := range scopes {
+		if s != ScopeRead && s != ScopeWrite {
+			return "", 0, ErrInvalidScope
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, err
+	}
+	token := tokenPrefix + hex.EncodeToString(b)
+
+	var expires sql.NullTime
+	if expiresAt != nil {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	var id int64
+	err := db.QueryRow("INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		userID, name, hashToken(token), strings.Join(scopes, ","), expires).Scan(&id)
+	if err != nil {
+		return "", 0, err
+	}
+	return token, id, nil
+}
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	token := &APIToken{}
+	var scopes string
+	var lastUsed, expires sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &lastUsed, &expires, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	if expires.Valid {
+		token.ExpiresAt = &expires.Time
+	}
+	return token, nil
+}
+
+func (db *DB) GetUserAPITokens(userID int) ([]APIToken, error) {
+	rows, err := db.Query("SELECT id, user_id, name, scopes, last_used_at, expires_at, created_at FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, nil
+}
+
+func (db *DB) DeleteAPIToken(id, userID int) error {
+	_, err := db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+// AuthenticateAPIToken resolves a plain-text token to its owner, recording
+// when it was last used to within tokenTouchInterval.
+func (db *DB) AuthenticateAPIToken(plain string) (*User, *APIToken, error) {
+	if !strings.HasPrefix(plain, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	token, err := scanAPIToken(db.QueryRow("SELECT id, user_id, name, scopes, last_used_at, expires_at, created_at FROM api_tokens WHERE token_hash = ?",
+		hashToken(plain)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if token.Expired() {
+		return nil, nil, ErrTokenExpired
+	}
+
+	user, err := db.GetUserByID(token.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= tokenTouchInterval {
+		if _, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, token.ID); err != nil {
+			return nil, nil, err
+		}
+		token.LastUsedAt = &now
+	}
+
+	return user, token, nil
+}
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Deleted outlines and templates stay in the trash, with deleted_at set, until
+// their owner restores or purges them, or PurgeTrash removes them once they
+// are old enough. Times in the trash are stored as Unix seconds.
+
+// DeletedUser is a deleted account whose outlines or templates are still in
+// the trash.
+type DeletedUser struct {
+	ID int
+	// Username is "" for accounts deleted before the trash existed.
+	Username  string
+	DeletedAt time.Time
+	Outlines  int
+	Templates int
+}
+
+// trashUserContent moves everything a user owns to the trash, remembering
+// whose it was. Items already in the trash keep their deletion time.
+func trashUserContent(tx *Tx, userID int, now time.Time) error {
+	_, err := tx.Exec(`INSERT INTO deleted_users (id, username, deleted_at)
+		SELECT id, username, ? FROM users
+		WHERE id = ? AND (EXISTS (SELECT 1 FROM outlines WHERE user_id = ?)
+			OR EXISTS (SELECT 1 FROM templates WHERE user_id = ? AND is_system = FALSE))`,
+		now.Unix(), userID, userID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE outlines SET deleted_at = ? WHERE user_id = ? AND deleted_at IS NULL", now.Unix(), userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE templates SET deleted_at = ? WHERE user_id = ? AND is_system = FALSE AND deleted_at IS NULL", now.Unix(), userID)
+	return err
+}
+
+// purgeOutlines deletes the outlines matching where, and their revisions,
+// shares and search entries, returning how many outlines there were.
+func purgeOutlines(tx *Tx, where string, args ...interface{}) (int64, error) {
+	_, err := tx.Exec("DELETE FROM outline_revisions WHERE outline_id IN (SELECT id FROM outlines WHERE "+where+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM outline_shares WHERE outline_id IN (SELECT id FROM outlines WHERE "+where+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := unindexItems(tx, SearchOutline, where, args...); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM outlines WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// purgeTemplates deletes the templates matching where, and their search
+// entries, returning how many templates there were.
+func purgeTemplates(tx *Tx, where string, args ...interface{}) (int64, error) {
+	if err := unindexItems(tx, SearchTemplate, where, args...); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM templates WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetTrashedOutlines lists a user's outlines in the trash, most recently
+// deleted first. Content is left empty.
+func (db *DB) GetTrashedOutlines(userID int) ([]Outline, error) {
+	rows, err := db.Query("SELECT id, user_id, title, version, created_at, updated_at, deleted_at FROM outlines WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outlines []Outline
+	for rows.Next() {
+		var outline Outline
+		var deletedAt int64
+		if err := rows.Scan(&outline.ID, &outline.UserID, &outline.Title, &outline.Version, &outline.CreatedAt, &outline.UpdatedAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		outline.DeletedAt = time.Unix(deletedAt, 0)
+		outlines = append(outlines, outline)
+	}
+	return outlines, rows.Err()
+}
+
+// GetTrashedTemplates lists a user's templates in the trash, most recently
+// deleted first. Content is left empty.
+func (db *DB) GetTrashedTemplates(userID int) ([]Template, error) {
+	rows, err := db.Query("SELECT id, name, description, category, is_system, user_id, created_at, updated_at, deleted_at FROM templates WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []Template
+	for rows.Next() {
+		var template Template
+		var deletedAt int64
+		if err := rows.Scan(&template.ID, &template.Name, &template.Description, &template.Category, &template.IsSystem, &template.UserID, &template.CreatedAt, &template.UpdatedAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		template.DeletedAt = time.Unix(deletedAt, 0)
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+// RestoreOutline takes one of a user's outlines out of the trash. It returns
+// sql.ErrNoRows if the user has no such outline in the trash.
+func (db *DB) RestoreOutline(id, userID int) error {
+	result, err := db.Exec("UPDATE outlines SET deleted_at = NULL WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID)
+	return affectedOne(result, err)
+}
+
+// RestoreTemplate takes one of a user's templates out of the trash. It
+// returns sql.ErrNoRows if the user has no such template in the trash.
+func (db *DB) RestoreTemplate(id, userID int) error {
+	result, err := db.Exec("UPDATE templates SET deleted_at = NULL WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID)
+	return affectedOne(result, err)
+}
+
+// PurgeOutline deletes one of a user's outlines in the trash for good,
+// along with its revisions. It returns sql.ErrNoRows if the user has no such
+// outline in the trash.
+func (db *DB) PurgeOutline(id, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	n, err := purgeOutlines(tx, "id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// PurgeTemplate deletes one of a user's templates in the trash for good. It
+// returns sql.ErrNoRows if the user has no such template in the trash.
+func (db *DB) PurgeTemplate(id, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	n, err := purgeTemplates(tx, "id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// EmptyTrash deletes everything in a user's trash for good.
+func (db *DB) EmptyTrash(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := purgeOutlines(tx, "user_id = ? AND deleted_at IS NOT NULL", userID); err != nil {
+		return err
+	}
+	if _, err := purgeTemplates(tx, "user_id = ? AND deleted_at IS NOT NULL", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeTrash deletes for good every outline and template that was moved to
+// the trash before the given time, and forgets deleted users who no longer
+// have anything left in it. It returns how many outlines and templates were
+// deleted.
+func (db *DB) PurgeTrash(before time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	outlines, err := purgeOutlines(tx, "deleted_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	templates, err := purgeTemplates(tx, "deleted_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`DELETE FROM deleted_users
+		WHERE NOT EXISTS (SELECT 1 FROM outlines WHERE user_id = deleted_users.id)
+		AND NOT EXISTS (SELECT 1 FROM templates WHERE user_id = deleted_users.id)`)
+	if err != nil {
+		return 0, err
+	}
+	return outlines + templates, tx.Commit()
+}
+
+// GetDeletedUsers lists the deleted users who still have outlines or
+// templates in the trash, most recently deleted first.
+func (db *DB) GetDeletedUsers() ([]DeletedUser, error) {
+	rows, err := db.Query(`SELECT d.id, d.username, d.deleted_at,
+			(SELECT COUNT(*) FROM outlines WHERE user_id = d.id),
+			(SELECT COUNT(*) FROM templates WHERE user_id = d.id AND is_system = FALSE)
+		FROM deleted_users d ORDER BY d.deleted_at DESC, d.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []DeletedUser
+	for rows.Next() {
+		var user DeletedUser
+		var deletedAt int64
+		if err := rows.Scan(&user.ID, &user.Username, &deletedAt, &user.Outlines, &user.Templates); err != nil {
+			return nil, err
+		}
+		user.DeletedAt = time.Unix(deletedAt, 0)
+		if user.Outlines+user.Templates > 0 {
+			users = append(users, user)
+		}
+	}
+	return users, rows.Err()
+}
+
+// RecoverDeletedUser gives a deleted user's outlines and templates to
+// another user. They arrive in that user's trash, as if just deleted, so
+// that the recipient can restore what they want to keep. It returns
+// sql.ErrNoRows if either user does not exist.
+func (db *DB) RecoverDeletedUser(id, toUserID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT 1 FROM users WHERE id = ?", toUserID).Scan(&exists); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM deleted_users WHERE id = ?", id)
+	if err := affectedOne(result, err); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.Exec("UPDATE outlines SET user_id = ?, deleted_at = ? WHERE user_id = ?", toUserID, now, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE templates SET user_id = ?, deleted_at = ? WHERE user_id = ? AND is_system = FALSE", toUserID, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeletedUser deletes a deleted user's outlines and templates for good.
+// It returns sql.ErrNoRows if there is no such deleted user.
+func (db *DB) PurgeDeletedUser(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM deleted_users WHERE id = ?", id)
+	if err := affectedOne(result, err); err != nil {
+		return err
+	}
+	if _, err := purgeOutlines(tx, "user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := purgeTemplates(tx, "user_id = ? AND is_system = FALSE", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// affectedOne turns the result of a statement meant to change a single row
+// into sql.ErrNoRows if it changed none.
+func affectedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
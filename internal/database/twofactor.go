@@ -0,0 +1,174 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is how many recovery codes a user is given at a time.
+const recoveryCodeCount = 10
+
+// SettingRequireTwoFactor names the setting that says which users must
+// enroll in two-factor authentication.
+const SettingRequireTwoFactor = "require_2fa"
+
+// TOTP is a user's authenticator enrollment.
+type TOTP struct {
+	Secret  string
+	Enabled bool
+	// LastStep is the time step of the last code accepted, so that no code
+	// can be used twice.
+	LastStep int64
+}
+
+func (db *DB) GetTOTP(userID int) (*TOTP, error) {
+	t := &TOTP{}
+	err := db.QueryRow("SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?",
+		userID).Scan(&t.Secret, &t.Enabled, &t.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetTOTPSecret starts enrollment with a new secret. Two-factor
+// authentication stays off until EnableTOTP confirms the user's app is
+// producing codes for it.
+func (db *DB) SetTOTPSecret(userID int, secret string) error {
+	_, err := db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?",
+		secret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor authentication for a user whose code for
+// time step step has just been accepted.
+func (db *DB) EnableTOTP(userID int, step int64) error {
+	_, err := db.Exec("UPDATE users SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ? AND totp_secret != ''",
+		step, userID)
+	return err
+}
+
+// DisableTOTP turns off two-factor authentication, forgetting the secret and
+// any recovery codes.
+func (db *DB) DisableTOTP(userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = '', totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for time step step was accepted, returning
+// false if that step or a later one has already been used.
+func (db *DB) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+		step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// NewRecoveryCodes replaces a user's recovery codes with a fresh set and
+// returns them in plain text. Only bcrypt hashes are stored, so the codes
+// are shown once.
+func (db *DB) NewRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes[i], hashes[i] = code, hash
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, string(hash)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// newRecoveryCode returns a code like "k7qzm-3xw2a".
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// UseRecoveryCode consumes one of a user's unused recovery codes, returning
+// false if code matches none of them.
+func (db *DB) UseRecoveryCode(userID int, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	rows, err := db.Query("SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return false, err
+	}
+
+	matched := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			matched = id
+			break
+		}
+	}
+	rows.Close()
+
+	if matched == 0 {
+		return false, nil
+	}
+
+	// Guard against the same code being used twice concurrently
+	result, err := db.Exec("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL",
+		time.Now().UTC(), matched)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has.
+func (db *DB) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
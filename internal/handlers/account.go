@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// Account handlers
+func (h *Handler) AccountPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	tokens, err := h.DB.GetUserAPITokens(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "account.html", map[string]interface{}{
+		"User":       user,
+		"TokenCount": len(tokens),
+		"Policy":     h.Passwords.Describe(),
+	})
+}
+
+// ChangeAccountPassword lets a user change their own password. Their other
+// sessions are ended; the one making the request stays logged in.
+func (h *Handler) ChangeAccountPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if status, message := h.checkNewPassword(w, r, user, data.CurrentPassword, data.NewPassword, data.ConfirmPassword); message != "" {
+		http.Error(w, message, status)
+		return
+	}
+
+	if err := h.setOwnPassword(r, user, data.NewPassword); err != nil {
+		http.Error(w, "Error changing password", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateUser(t *testing.T) {
+	s := newTestServer(t, "create_user")
+	admin, _ := s.db.GetUser("admin")
+
+	rec := s.do(admin, http.MethodPost, "/api/admin/user/create", map[string]interface{}{
+		"username": "newhire",
+		"password": "known to the admin",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// The admin knows the password, so it has to be replaced
+	user, err := s.db.GetUser("newhire")
+	if err != nil || !user.MustChangePassword || user.IsAdmin {
+		t.Errorf("Expected a user who must change their password, got %+v, %v", user, err)
+	}
+
+	// A duplicate username leaves nothing half made
+	rec = s.do(admin, http.MethodPost, "/api/admin/user/create", map[string]interface{}{
+		"username": "newhire",
+		"password": "another",
+	})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a duplicate username to fail, got %d", rec.Code)
+	}
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	s := newTestServer(t, "update_user_password")
+	admin, _ := s.db.GetUser("admin")
+	alice := s.user("alice")
+
+	update := func(id int, username string) int {
+		return s.do(admin, http.MethodPost, "/api/admin/user/update", map[string]interface{}{
+			"id": id, "username": username, "password": "reset by the admin", "is_admin": username == "admin",
+		}).Code
+	}
+
+	// A password an admin sets for someone else must be replaced
+	if code := update(alice.ID, "alice"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if user, _ := s.db.GetUser("alice"); !user.MustChangePassword {
+		t.Error("Expected alice to have to change the password the admin set")
+	}
+
+	// Their own need not be
+	if code := update(admin.ID, "admin"); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if user, _ := s.db.GetUser("admin"); user.MustChangePassword {
+		t.Error("Expected the admin not to have to change their own new password")
+	}
+}
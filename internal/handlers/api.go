@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// Pagination limits for GET /api/v1/outlines
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// apiOutline is the JSON representation of an outline in the v1 API.
+// Content is only included when a single outline is requested.
+type apiOutline struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   *string   `json:"content,omitempty"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAPIOutline(o *database.Outline, withContent bool) apiOutline {
+	out := apiOutline{
+		ID:        o.ID,
+		Title:     o.Title,
+		Version:   o.Version,
+		CreatedAt: o.CreatedAt,
+		UpdatedAt: o.UpdatedAt,
+	}
+	if withContent {
+		out.Content = &o.Content
+	}
+	return out
+}
+
+// etag is the entity tag of an outline's current version.
+func etag(o *database.Outline) string {
+	return `"` + strconv.Itoa(o.Version) + `"`
+}
+
+// ifMatchVersion returns the outline version named by a request's If-Match
+// header, 0 if there is none, or -1 if it names no version.
+func ifMatchVersion(r *http.Request) int {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil || version <= 0 {
+		return -1
+	}
+	return version
+}
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError sends the v1 API's JSON error envelope.
+func apiError(w http.ResponseWriter, status int, message string) {
+	middleware.WriteJSONError(w, status, message)
+}
+
+// outlineFromPath loads the outline named by the {id} path value, writing an
+// error response and returning nil if it cannot be found.
+func (h *Handler) outlineFromPath(w http.ResponseWriter, r *http.Request) *database.Outline {
+	user, _ := middleware.GetUser(r)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "Invalid outline ID")
+		return nil
+	}
+
+	outline, err := h.DB.GetOutline(id, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		apiError(w, http.StatusNotFound, "Outline not found")
+		return nil
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error retrieving outline")
+		return nil
+	}
+	return outline
+}
+
+// decodeOutlineBody reads the title and content of a create or update request.
+func decodeOutlineBody(w http.ResponseWriter, r *http.Request) (title, content string, ok bool) {
+	var data struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		apiError(w, http.StatusBadRequest, "Invalid request body")
+		return "", "", false
+	}
+
+	data.Title = strings.TrimSpace(data.Title)
+	if data.Title == "" {
+		apiError(w, http.StatusBadRequest, "Title is required")
+		return "", "", false
+	}
+
+	return data.Title, data.Content, true
+}
+
+// APIListOutlines handles GET /api/v1/outlines?limit=&sort=&cursor=
+func (h *Handler) APIListOutlines(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	limit := defaultPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			apiError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return
+		}
+		limit = n
+	}
+
+	total, err := h.DB.CountUserOutlines(user.ID)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error retrieving outlines")
+		return
+	}
+
+	outlines, next, err := h.DB.ListOutlineSummaries(user.ID, database.ListOptions{
+		Sort:   r.URL.Query().Get("sort"),
+		Limit:  limit,
+		Cursor: r.URL.Query().Get("cursor"),
+	})
+	if errors.Is(err, database.ErrInvalidSort) {
+		apiError(w, http.StatusBadRequest, "Invalid sort order")
+		return
+	}
+	if errors.Is(err, database.ErrInvalidCursor) {
+		apiError(w, http.StatusBadRequest, "Invalid page cursor")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error retrieving outlines")
+		return
+	}
+
+	items := make([]apiOutline, 0, len(outlines))
+	for _, o := range outlines {
+		items = append(items, apiOutline{
+			ID:        o.ID,
+			Title:     o.Title,
+			Version:   o.Version,
+			CreatedAt: o.CreatedAt,
+			UpdatedAt: o.UpdatedAt,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"outlines":    items,
+		"total":       total,
+		"limit":       limit,
+		"next_cursor": next,
+	})
+}
+
+// APIGetOutline handles GET /api/v1/outlines/{id}
+func (h *Handler) APIGetOutline(w http.ResponseWriter, r *http.Request) {
+	outline := h.outlineFromPath(w, r)
+	if outline == nil {
+		return
+	}
+
+	w.Header().Set("ETag", etag(outline))
+	writeJSON(w, http.StatusOK, newAPIOutline(outline, true))
+}
+
+// APICreateOutline handles POST /api/v1/outlines
+func (h *Handler) APICreateOutline(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	title, content, ok := decodeOutlineBody(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := h.DB.CreateOutline(user.ID, title, content)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error creating outline")
+		return
+	}
+
+	outline, err := h.DB.GetOutline(int(id), user.ID)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error retrieving outline")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/outlines/"+strconv.Itoa(outline.ID))
+	w.Header().Set("ETag", etag(outline))
+	writeJSON(w, http.StatusCreated, newAPIOutline(outline, true))
+}
+
+// APIUpdateOutline handles PUT /api/v1/outlines/{id}. With an If-Match
+// header holding the ETag of a version, the update is refused with 412
+// Precondition Failed unless that is still the current version.
+func (h *Handler) APIUpdateOutline(w http.ResponseWriter, r *http.Request) {
+	outline := h.outlineFromPath(w, r)
+	if outline == nil {
+		return
+	}
+
+	version := ifMatchVersion(r)
+	if version < 0 {
+		apiError(w, http.StatusPreconditionFailed, "If-Match does not name a version of this outline")
+		return
+	}
+
+	title, content, ok := decodeOutlineBody(w, r)
+	if !ok {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+	_, err := h.DB.UpdateOutline(outline.ID, user.ID, version, title, content)
+	if errors.Is(err, database.ErrVersionConflict) {
+		apiError(w, http.StatusPreconditionFailed, "Outline has been changed since the version in If-Match")
+		return
+	}
+	if errors.Is(err, database.ErrForbidden) {
+		apiError(w, http.StatusForbidden, "Your role on this outline does not allow editing it")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error updating outline")
+		return
+	}
+
+	updated, err := h.DB.GetOutline(outline.ID, user.ID)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error retrieving outline")
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated))
+	writeJSON(w, http.StatusOK, newAPIOutline(updated, true))
+}
+
+// APIDeleteOutline handles DELETE /api/v1/outlines/{id}
+func (h *Handler) APIDeleteOutline(w http.ResponseWriter, r *http.Request) {
+	outline := h.outlineFromPath(w, r)
+	if outline == nil {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+	err := h.DB.DeleteOutline(outline.ID, user.ID)
+	if errors.Is(err, database.ErrForbidden) {
+		apiError(w, http.StatusForbidden, "Only an owner can delete this outline")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "Error deleting outline")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIExportOutline handles GET /api/v1/outlines/{id}/export?format=
+func (h *Handler) APIExportOutline(w http.ResponseWriter, r *http.Request) {
+	outline := h.outlineFromPath(w, r)
+	if outline == nil {
+		return
+	}
+
+	if !writeOutlineExport(w, outline, r.URL.Query().Get("format")) {
+		apiError(w, http.StatusBadRequest, "Unsupported export format")
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// apiErrorBody is the v1 API's error envelope.
+type apiErrorBody struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func TestAPIOutlineLifecycle(t *testing.T) {
+	s := newTestServer(t, "api_lifecycle")
+	alice := s.user("alice")
+
+	rec := s.do(alice, http.MethodPost, "/api/v1/outlines", map[string]string{"title": "Plan", "content": "<div>Step</div>"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created apiOutline
+	decode(t, rec, &created)
+	location := rec.Header().Get("Location")
+	if location != "/api/v1/outlines/"+strconv.Itoa(created.ID) || created.Content == nil || *created.Content != "<div>Step</div>" {
+		t.Fatalf("Unexpected created outline %+v at %q", created, location)
+	}
+	if rec.Header().Get("ETag") != `"1"` {
+		t.Errorf("Expected ETag \"1\", got %q", rec.Header().Get("ETag"))
+	}
+
+	rec = s.do(alice, http.MethodGet, location, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Errorf("Expected 200 with ETag \"1\", got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// Updating with the current ETag works and moves it on
+	rec = s.do(alice, http.MethodPut, location, map[string]string{"title": "Plan", "content": "<div>Next</div>"}, "If-Match", `"1"`)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected 200 with ETag \"2\", got %d %q: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+
+	// A stale or malformed ETag is refused
+	for _, ifMatch := range []string{`"1"`, `"nope"`} {
+		rec = s.do(alice, http.MethodPut, location, map[string]string{"title": "Plan", "content": "<div>Lost</div>"}, "If-Match", ifMatch)
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("If-Match %s: expected 412, got %d", ifMatch, rec.Code)
+		}
+	}
+
+	rec = s.do(alice, http.MethodGet, "/api/v1/outlines", nil)
+	var list struct {
+		Outlines []apiOutline `json:"outlines"`
+		Total    int          `json:"total"`
+		Limit    int          `json:"limit"`
+	}
+	decode(t, rec, &list)
+	if rec.Code != http.StatusOK || list.Total != 1 || len(list.Outlines) != 1 || list.Outlines[0].Content != nil || list.Limit != defaultPageLimit {
+		t.Errorf("Unexpected list %d %+v", rec.Code, list)
+	}
+
+	rec = s.do(alice, http.MethodDelete, location, nil)
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("Expected 204 with no body, got %d %q", rec.Code, rec.Body)
+	}
+	if rec = s.do(alice, http.MethodGet, location, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted outline to be 404, got %d", rec.Code)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	s := newTestServer(t, "api_errors")
+	alice := s.user("alice")
+	bob := s.user("bob")
+
+	id, _ := s.db.CreateOutline(alice.ID, "Private", "")
+	path := "/api/v1/outlines/" + strconv.Itoa(int(id))
+
+	tests := []struct {
+		name   string
+		user   bool
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"no session", false, http.MethodGet, "/api/v1/outlines", nil, http.StatusUnauthorized},
+		{"another user's outline", true, http.MethodGet, path, nil, http.StatusNotFound},
+		{"bad ID", true, http.MethodGet, "/api/v1/outlines/abc", nil, http.StatusBadRequest},
+		{"missing title", true, http.MethodPost, "/api/v1/outlines", map[string]string{"content": "x"}, http.StatusBadRequest},
+		{"bad JSON", true, http.MethodPost, "/api/v1/outlines", "{", http.StatusBadRequest},
+		{"limit too large", true, http.MethodGet, "/api/v1/outlines?limit=1000", nil, http.StatusBadRequest},
+		{"bad sort", true, http.MethodGet, "/api/v1/outlines?sort=size", nil, http.StatusBadRequest},
+		{"bad cursor", true, http.MethodGet, "/api/v1/outlines?cursor=nope", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		user := bob
+		if !tt.user {
+			user = nil
+		}
+		rec := s.do(user, tt.method, tt.path, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, rec.Code)
+			continue
+		}
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON error, got %q", tt.name, rec.Header().Get("Content-Type"))
+		}
+		var body apiErrorBody
+		decode(t, rec, &body)
+		if body.Error.Status != tt.status || body.Error.Message == "" {
+			t.Errorf("%s: unexpected error envelope %+v", tt.name, body)
+		}
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	s := newTestServer(t, "api_token")
+	alice := s.user("alice")
+
+	read, _, _ := s.db.CreateAPIToken(alice.ID, "Reader", []string{"read"}, nil)
+	if rec := s.do(nil, http.MethodGet, "/api/v1/outlines", nil, "Authorization", "Bearer "+read); rec.Code != http.StatusOK {
+		t.Errorf("Expected a read token to list outlines, got %d", rec.Code)
+	}
+	rec := s.do(nil, http.MethodPost, "/api/v1/outlines", map[string]string{"title": "No"}, "Authorization", "Bearer "+read)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a read token to be refused a create, got %d", rec.Code)
+	}
+	if rec := s.do(nil, http.MethodGet, "/api/v1/outlines", nil, "Authorization", "Bearer cpt_wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", rec.Code)
+	}
+}
+
+func TestAPIListOutlinesPages(t *testing.T) {
+	s := newTestServer(t, "api_pages")
+	alice := s.user("alice")
+	for _, title := range []string{"Cherry", "apple", "Banana"} {
+		s.db.CreateOutline(alice.ID, title, "<div>Step</div>")
+	}
+
+	var titles []string
+	path := "/api/v1/outlines?limit=2&sort=title"
+	for pages := 0; path != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("Expected the pages to end")
+		}
+		rec := s.do(alice, http.MethodGet, path, nil)
+		var list struct {
+			Outlines   []apiOutline `json:"outlines"`
+			Total      int          `json:"total"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		decode(t, rec, &list)
+		if rec.Code != http.StatusOK || list.Total != 3 || len(list.Outlines) > 2 {
+			t.Fatalf("Unexpected page %d %+v", rec.Code, list)
+		}
+		for _, o := range list.Outlines {
+			titles = append(titles, o.Title)
+		}
+		path = ""
+		if list.NextCursor != "" {
+			path = "/api/v1/outlines?limit=2&sort=title&cursor=" + list.NextCursor
+		}
+	}
+	if strings.Join(titles, ",") != "apple,Banana,Cherry" {
+		t.Errorf("Expected every outline once in title order, got %v", titles)
+	}
+}
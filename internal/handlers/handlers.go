@@ -2,31 +2,86 @@ package handlers
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"io/fs"
+	"mime"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kristofer/composter/internal/config"
 	"github.com/kristofer/composter/internal/database"
 	"github.com/kristofer/composter/internal/middleware"
+	"github.com/kristofer/composter/internal/oidc"
+	outlinetree "github.com/kristofer/composter/internal/outline"
 )
 
 type Handler struct {
-	DB    *database.DB
-	Store *middleware.SessionStore
-	Tmpl  *template.Template
+	DB        database.Store
+	Auth      *middleware.Authenticator
+	Logins    *middleware.LoginLimiter
+	Passwords middleware.PasswordPolicy
+	Tmpl      *template.Template
+
+	// SSO is the identity provider users may log in through, or nil.
+	SSO *oidc.Provider
+
+	// Features says which optional parts of the UI to show.
+	Features config.Features
+
+	// TrashRetention is how long deleted items stay in the trash, or zero
+	// if they stay until purged by hand.
+	TrashRetention time.Duration
+
+	// templates is where Tmpl was parsed from. If reload is set, it is
+	// parsed again for every page, so that edits show up without a restart.
+	templates fs.FS
+	reload    bool
 }
 
-func New(db *database.DB, store *middleware.SessionStore) *Handler {
-	tmpl := template.Must(template.ParseGlob("templates/*.html"))
+// New creates the handlers, parsing the page templates in the templates file
+// system. With reload set, templates are re-read on every render.
+func New(db database.Store, auth *middleware.Authenticator, logins *middleware.LoginLimiter, templates fs.FS, reload bool) *Handler {
+	tmpl := template.Must(template.ParseFS(templates, "*.html"))
 	return &Handler{
-		DB:    db,
-		Store: store,
-		Tmpl:  tmpl,
+		DB:        db,
+		Auth:      auth,
+		Logins:    logins,
+		Passwords: middleware.DefaultPasswordPolicy,
+		Tmpl:      tmpl,
+		Features:  config.Default().Features,
+		templates: templates,
+		reload:    reload,
 	}
 }
 
+// render executes a page template, adding the CSRF token that every page's
+// forms and fetch calls must send back, and which optional features are on.
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["CSRFToken"] = middleware.CSRFToken(r)
+	data["SSOEnabled"] = h.SSO != nil
+	data["Features"] = h.Features
+
+	tmpl := h.Tmpl
+	if h.reload {
+		var err error
+		if tmpl, err = template.ParseFS(h.templates, "*.html"); err != nil {
+			http.Error(w, "Error parsing templates: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	tmpl.ExecuteTemplate(w, name, data)
+}
+
 func generateSessionID() (string, error) {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -39,13 +94,13 @@ func generateSessionID() (string, error) {
 func (h *Handler) LoginPage(w http.ResponseWriter, r *http.Request) {
 	// Check if already logged in
 	if cookie, err := r.Cookie("session"); err == nil {
-		if _, ok := h.Store.Get(cookie.Value); ok {
+		if _, ok := h.Auth.SessionUser(cookie.Value); ok {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
 	}
 
-	h.Tmpl.ExecuteTemplate(w, "login.html", nil)
+	h.render(w, r, "login.html", nil)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -56,29 +111,86 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	ip := middleware.ClientIP(r)
+
+	if !h.checkLoginLimit(w, r, "login.html", ip, username) {
+		return
+	}
 
 	user, err := h.DB.VerifyPassword(username, password)
 	if err != nil {
-		h.Tmpl.ExecuteTemplate(w, "login.html", map[string]string{
+		if err := h.Logins.Failed(ip, username); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.render(w, r, "login.html", map[string]interface{}{
 			"Error": "Invalid username or password",
 		})
 		return
 	}
 
+	// Users with an authenticator must also enter a one-time code
+	if user.TOTPEnabled {
+		h.startSecondFactor(w, r, user)
+		return
+	}
+
+	if err := h.Logins.Succeeded(ip, username); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.startSession(w, r, user)
+}
+
+// checkLoginLimit asks the login limiter whether an attempt for username may
+// go ahead. If not, it answers the request by rendering page with an error
+// and returns false.
+func (h *Handler) checkLoginLimit(w http.ResponseWriter, r *http.Request, page, ip, username string) bool {
+	wait, err := h.Logins.Check(ip, username)
+	if errors.Is(err, middleware.ErrLoginThrottled) || errors.Is(err, middleware.ErrAccountLocked) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		h.render(w, r, page, map[string]interface{}{
+			"Error": "Too many failed login attempts. Try again in " + retryAfter(w, wait) + ".",
+		})
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// retryAfter tells the client to wait before trying again, in whole seconds
+// and at least one, and returns the wait for use in a message.
+func retryAfter(w http.ResponseWriter, wait time.Duration) string {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+	return wait.String()
+}
+
+// startSession logs a user in whose credentials have been checked.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *database.User) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	h.Store.Set(sessionID, user)
+	if err := h.Auth.Sessions.Set(sessionID, user.ID); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    sessionID,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   86400, // 24 hours
+	h.Auth.Cookies.Set(w, r, &http.Cookie{
+		Name:   "session",
+		Value:  sessionID,
+		Path:   "/",
+		MaxAge: int(h.Auth.Timeouts.Absolute.Seconds()),
 	})
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -86,32 +198,66 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	if cookie, err := r.Cookie("session"); err == nil {
-		h.Store.Delete(cookie.Value)
+		h.Auth.Sessions.Delete(cookie.Value)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	h.Auth.Cookies.Clear(w, r, "session", "/")
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// outlinesPageSize is how many outlines the outlines page lists at a time.
+const outlinesPageSize = 50
+
 // Outline handlers
+
+// ListOutlines shows a page of the user's outlines. The sort query parameter
+// picks the order, and cursor, taken from the previous page's link, the
+// page.
 func (h *Handler) ListOutlines(w http.ResponseWriter, r *http.Request) {
 	user, _ := middleware.GetUser(r)
 
-	outlines, err := h.DB.GetUserOutlines(user.ID)
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = database.SortUpdated
+	}
+	cursor := r.URL.Query().Get("cursor")
+
+	outlines, next, err := h.DB.ListOutlineSummaries(user.ID, database.ListOptions{
+		Sort:   sort,
+		Limit:  outlinesPageSize,
+		Cursor: cursor,
+	})
+	if errors.Is(err, database.ErrInvalidSort) {
+		http.Error(w, "Invalid sort order", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid page cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error retrieving outlines", http.StatusInternalServerError)
 		return
 	}
 
-	h.Tmpl.ExecuteTemplate(w, "outlines.html", map[string]interface{}{
-		"User":     user,
-		"Outlines": outlines,
+	// Outlines shared by others are listed above the first page
+	var shared []database.SharedOutline
+	if cursor == "" {
+		shared, err = h.DB.GetSharedOutlines(user.ID)
+		if err != nil {
+			http.Error(w, "Error retrieving shared outlines", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.render(w, r, "outlines.html", map[string]interface{}{
+		"User":       user,
+		"Outlines":   outlines,
+		"Shared":     shared,
+		"Sort":       sort,
+		"Paged":      cursor != "",
+		"NextCursor": next,
 	})
 }
 
@@ -121,7 +267,7 @@ func (h *Handler) ViewOutline(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
 	if idStr == "" {
 		// New outline
-		h.Tmpl.ExecuteTemplate(w, "editor.html", map[string]interface{}{
+		h.render(w, r, "editor.html", map[string]interface{}{
 			"User":    user,
 			"Outline": nil,
 		})
@@ -140,12 +286,19 @@ func (h *Handler) ViewOutline(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.Tmpl.ExecuteTemplate(w, "editor.html", map[string]interface{}{
+	h.render(w, r, "editor.html", map[string]interface{}{
 		"User":    user,
 		"Outline": outline,
+		"CanEdit": database.RoleAllows(outline.Role, database.RoleEditor),
+		"IsOwner": database.RoleAllows(outline.Role, database.RoleOwner),
 	})
 }
 
+// SaveOutline creates an outline, or updates one. An update must carry the
+// version of the outline it was made to, and is refused with 409 Conflict,
+// along with the outline as it is now, if that is no longer the current
+// version. With "merge" set, the edit is instead merged into the current
+// version when the two do not overlap, and the merged outline is returned.
 func (h *Handler) SaveOutline(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -156,8 +309,10 @@ func (h *Handler) SaveOutline(w http.ResponseWriter, r *http.Request) {
 
 	var data struct {
 		ID      int    `json:"id"`
+		Version int    `json:"version"`
 		Title   string `json:"title"`
 		Content string `json:"content"`
+		Merge   bool   `json:"merge"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -175,19 +330,121 @@ func (h *Handler) SaveOutline(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
 			"id":      id,
+			"version": 1,
 		})
-	} else {
-		// Update existing outline
-		err := h.DB.UpdateOutline(data.ID, user.ID, data.Title, data.Content)
+		return
+	}
+
+	// Update existing outline
+	if data.Version <= 0 {
+		http.Error(w, "Outline version required", http.StatusBadRequest)
+		return
+	}
+
+	version, err := h.DB.UpdateOutline(data.ID, user.ID, data.Version, data.Title, data.Content)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Outline not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(w, "You cannot edit this outline", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrVersionConflict) {
+		h.saveConflict(w, user.ID, data.ID, data.Version, data.Title, data.Content, data.Merge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error updating outline", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      data.ID,
+		"version": version,
+	})
+}
+
+// saveConflict answers a save made to an outdated version of an outline,
+// either by merging it into the current version or with 409 Conflict.
+func (h *Handler) saveConflict(w http.ResponseWriter, userID, id, version int, title, content string, merge bool) {
+	current, err := h.DB.GetOutline(id, userID)
+	if err != nil {
+		http.Error(w, "Error retrieving outline", http.StatusInternalServerError)
+		return
+	}
+
+	var conflicts []outlinetree.Conflict
+	if merge {
+		base, err := h.DB.GetOutlineVersion(id, userID, version)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Unknown outline version", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(w, "Error updating outline", http.StatusInternalServerError)
+			http.Error(w, "Error retrieving outline", http.StatusInternalServerError)
 			return
 		}
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"id":      data.ID,
-		})
+
+		var merged *outlinetree.Document
+		merged, conflicts = outlinetree.Merge(outlinetree.Parse(base.Content), outlinetree.Parse(current.Content), outlinetree.Parse(content))
+		mergedTitle, titleMerged := mergeTitle(base.Title, current.Title, title)
+		if !titleMerged {
+			conflicts = append(conflicts, outlinetree.Conflict{Text: title, Path: []string{}, Reason: "the title was changed in the other version"})
+		}
+
+		if len(conflicts) == 0 {
+			mergedContent := merged.HTML()
+			saved, err := h.DB.UpdateOutline(id, userID, current.Version, mergedTitle, mergedContent)
+			if err == nil {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": true,
+					"id":      id,
+					"version": saved,
+					"merged":  true,
+					"title":   mergedTitle,
+					"content": mergedContent,
+				})
+				return
+			}
+			if !errors.Is(err, database.ErrVersionConflict) {
+				http.Error(w, "Error updating outline", http.StatusInternalServerError)
+				return
+			}
+			// Saved again in the meantime; let the client try once more
+			if current, err = h.DB.GetOutline(id, userID); err != nil {
+				http.Error(w, "Error retrieving outline", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	if conflicts == nil {
+		conflicts = []outlinetree.Conflict{}
+	}
+	writeJSON(w, http.StatusConflict, map[string]interface{}{
+		"success":   false,
+		"conflict":  true,
+		"error":     "The outline has been changed since it was loaded",
+		"id":        id,
+		"version":   current.Version,
+		"title":     current.Title,
+		"content":   current.Content,
+		"conflicts": conflicts,
+	})
+}
+
+// mergeTitle merges two titles both changed from base, reporting false if
+// they were changed differently.
+func mergeTitle(base, ours, theirs string) (string, bool) {
+	switch {
+	case theirs == base || theirs == ours:
+		return ours, true
+	case ours == base:
+		return theirs, true
 	}
+	return "", false
 }
 
 func (h *Handler) DeleteOutline(w http.ResponseWriter, r *http.Request) {
@@ -208,6 +465,14 @@ func (h *Handler) DeleteOutline(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := h.DB.DeleteOutline(data.ID, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Outline not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(w, "Only the outline's owner can delete it", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error deleting outline", http.StatusInternalServerError)
 		return
@@ -216,6 +481,122 @@ func (h *Handler) DeleteOutline(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
+func (h *Handler) ExportOutline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	idStr := r.URL.Query().Get("id")
+	if idStr == "" {
+		http.Error(w, "Outline ID required", http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid outline ID", http.StatusBadRequest)
+		return
+	}
+
+	outline, err := h.DB.GetOutline(id, user.ID)
+	if err != nil {
+		http.Error(w, "Outline not found", http.StatusNotFound)
+		return
+	}
+
+	if !writeOutlineExport(w, outline, r.URL.Query().Get("format")) {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+	}
+}
+
+// writeOutlineExport sends outline as a download in the given format,
+// returning false without writing anything if the format is unknown.
+func writeOutlineExport(w http.ResponseWriter, outline *database.Outline, format string) bool {
+	doc := outlinetree.Parse(outline.Content)
+
+	switch format {
+	case "", "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", attachment(outline.Title+".md"))
+		w.Write([]byte(doc.Markdown(outline.Title)))
+	case "opml":
+		writeOPML(w, doc, outline.Title)
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *Handler) ImportOutline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	// Parse multipart form
+	err := r.ParseMultipartForm(10 << 20) // 10 MB max
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("opml")
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	title, doc, err := outlinetree.ParseOPML(file)
+	if err != nil {
+		http.Error(w, "Invalid OPML file", http.StatusBadRequest)
+		return
+	}
+
+	// Fall back to the file name when the OPML head has no title
+	if title == "" {
+		title = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	}
+	if title == "" {
+		title = "Imported Outline"
+	}
+
+	id, err := h.DB.CreateOutline(user.ID, title, doc.HTML())
+	if err != nil {
+		http.Error(w, "Error importing outline", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
+
+// writeOPML sends doc as a downloadable OPML file named after title.
+func writeOPML(w http.ResponseWriter, doc *outlinetree.Document, title string) {
+	data, err := doc.OPML(title)
+	if err != nil {
+		http.Error(w, "Error exporting OPML", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", attachment(title+".opml"))
+	w.Write(data)
+}
+
+// attachment returns a Content-Disposition header value that downloads the
+// response as filename.
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
 // Admin handlers
 func (h *Handler) AdminPage(w http.ResponseWriter, r *http.Request) {
 	user, _ := middleware.GetUser(r)
@@ -226,9 +607,32 @@ func (h *Handler) AdminPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.Tmpl.ExecuteTemplate(w, "admin.html", map[string]interface{}{
-		"User":  user,
-		"Users": users,
+	lockouts, err := h.DB.GetLockedLogins(time.Now())
+	if err != nil {
+		http.Error(w, "Error retrieving lockouts", http.StatusInternalServerError)
+		return
+	}
+
+	failures, err := h.DB.GetRecentLoginFailures(50)
+	if err != nil {
+		http.Error(w, "Error retrieving failed logins", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := h.DB.GetDeletedUsers()
+	if err != nil {
+		http.Error(w, "Error retrieving deleted users", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "admin.html", map[string]interface{}{
+		"User":         user,
+		"Users":        users,
+		"DeletedUsers": deleted,
+		"Lockouts":     lockouts,
+		"FailedLogins": failures,
+		"TwoFactor":    string(h.Auth.TwoFactorRequirement()),
+		"SSOLinking":   h.ssoLinking(),
 	})
 }
 
@@ -249,7 +653,15 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.DB.CreateUser(data.Username, data.Password, data.IsAdmin)
+	// Without a password the user can only log in through single sign-on,
+	// once linked to their account there
+	if data.Password == "" && !h.ssoLinking() {
+		http.Error(w, "Password required", http.StatusBadRequest)
+		return
+	}
+
+	// The admin knows this password, so the user must replace it
+	err := h.DB.CreateUser(data.Username, data.Password, data.IsAdmin, data.Password != "")
 	if err != nil {
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
 		return
@@ -276,12 +688,31 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.DB.UpdateUser(data.ID, data.Username, data.Password, data.IsAdmin)
+	existing, err := h.DB.GetUserByID(data.ID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// As with new users, a password reset by an admin must be replaced
+	admin, _ := middleware.GetUser(r)
+	err = h.DB.UpdateUser(data.ID, data.Username, data.Password, data.IsAdmin, data.ID != admin.ID)
 	if err != nil {
 		http.Error(w, "Error updating user", http.StatusInternalServerError)
 		return
 	}
 
+	// Log the user out everywhere if their credentials or rights were reduced
+	if data.Password != "" || (existing.IsAdmin && !data.IsAdmin) {
+		err = h.Auth.RevokeUser(data.ID)
+	} else {
+		h.Auth.UserChanged(data.ID)
+	}
+	if err != nil {
+		http.Error(w, "Error ending user sessions", http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
@@ -306,6 +737,35 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.Auth.RevokeUser(data.ID); err != nil {
+		http.Error(w, "Error ending user sessions", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// ClearLockout lifts a login lockout so the user can try again at once.
+func (h *Handler) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Logins.Unlock(data.Username); err != nil {
+		http.Error(w, "Error clearing lockout", http.StatusInternalServerError)
+		return
+	}
+
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
@@ -313,19 +773,19 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListTemplates(w http.ResponseWriter, r *http.Request) {
 	user, _ := middleware.GetUser(r)
 
-	systemTemplates, err := h.DB.GetSystemTemplates()
+	systemTemplates, err := h.DB.GetSystemTemplateSummaries()
 	if err != nil {
 		http.Error(w, "Error retrieving system templates", http.StatusInternalServerError)
 		return
 	}
 
-	userTemplates, err := h.DB.GetUserTemplates(user.ID)
+	userTemplates, err := h.DB.GetUserTemplateSummaries(user.ID)
 	if err != nil {
 		http.Error(w, "Error retrieving user templates", http.StatusInternalServerError)
 		return
 	}
 
-	h.Tmpl.ExecuteTemplate(w, "templates.html", map[string]interface{}{
+	h.render(w, r, "templates.html", map[string]interface{}{
 		"User":            user,
 		"SystemTemplates": systemTemplates,
 		"UserTemplates":   userTemplates,
@@ -485,6 +945,16 @@ func (h *Handler) ExportTemplate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "opml":
+		writeOPML(w, outlinetree.Parse(template.Content), template.Name)
+		return
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
 	// Create export structure
 	export := map[string]interface{}{
 		"name":        template.Name,
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+var testTimeouts = middleware.SessionTimeouts{Idle: time.Hour, Absolute: 24 * time.Hour}
+
+// testServer serves the handlers from a fresh SQLite database behind the
+// same middleware and routes as the real server.
+type testServer struct {
+	t        *testing.T
+	db       *database.DB
+	h        *Handler
+	sessions middleware.SessionStore
+	handler  http.Handler
+}
+
+func newTestServer(t *testing.T, name string) *testServer {
+	t.Helper()
+
+	dbPath := "/tmp/test_composter_handlers_" + name + ".db"
+	os.Remove(dbPath)
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	admin, _ := db.GetUser("admin")
+	db.SetMustChangePassword(admin.ID, false)
+
+	sessions := middleware.NewMemoryStore(testTimeouts)
+	t.Cleanup(func() { sessions.Close() })
+	auth := middleware.NewAuthenticator(db, sessions, testTimeouts)
+	logins := middleware.NewLoginLimiter(db, middleware.DefaultLoginPolicy)
+	t.Cleanup(func() { logins.Close() })
+
+	h := New(db, auth, logins, os.DirFS("../../templates"), false)
+
+	authMux := http.NewServeMux()
+	authMux.HandleFunc("/password", h.ChangePassword)
+	authMux.HandleFunc("/api/outline/save", h.SaveOutline)
+	authMux.HandleFunc("/api/outline/delete", h.DeleteOutline)
+	authMux.HandleFunc("/api/outline/restore", h.RestoreRevision)
+	authMux.HandleFunc("/api/outline/shares", h.ListShares)
+	authMux.HandleFunc("/api/outline/share", h.ShareOutline)
+	authMux.HandleFunc("/api/outline/unshare", h.UnshareOutline)
+	authMux.HandleFunc("/api/outline/transfer", h.TransferOutline)
+	authMux.HandleFunc("/api/account/password", h.ChangeAccountPassword)
+
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/api/admin/user/create", h.CreateUser)
+	adminMux.HandleFunc("/api/admin/user/update", h.UpdateUser)
+
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("GET /api/v1/outlines", h.APIListOutlines)
+	apiMux.HandleFunc("POST /api/v1/outlines", h.APICreateOutline)
+	apiMux.HandleFunc("GET /api/v1/outlines/{id}", h.APIGetOutline)
+	apiMux.HandleFunc("PUT /api/v1/outlines/{id}", h.APIUpdateOutline)
+	apiMux.HandleFunc("DELETE /api/v1/outlines/{id}", h.APIDeleteOutline)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/sso", h.SSOLogin)
+	mux.HandleFunc("/login/sso/callback", h.SSOCallback)
+	mux.Handle("/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/admin/", middleware.AdminRequired(auth)(adminMux))
+	mux.Handle("/api/v1/", middleware.APIAuthRequired(auth)(apiMux))
+
+	return &testServer{t: t, db: db, h: h, sessions: sessions, handler: mux}
+}
+
+// user creates a user and returns them.
+func (s *testServer) user(username string) *database.User {
+	s.t.Helper()
+	if err := s.db.CreateUser(username, "correct horse 1", false, false); err != nil {
+		s.t.Fatalf("Failed to create user: %v", err)
+	}
+	user, err := s.db.GetUser(username)
+	if err != nil {
+		s.t.Fatalf("Failed to get user: %v", err)
+	}
+	return user
+}
+
+// do sends a request as the user, through a session of theirs. A body that
+// is not a string is sent as JSON. Header is given as name, value pairs.
+func (s *testServer) do(user *database.User, method, path string, body interface{}, header ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("Failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	if user != nil {
+		sessionID := "session-" + strconv.Itoa(user.ID)
+		s.sessions.Set(sessionID, user.ID)
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+	}
+
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode reads a JSON response body into v.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
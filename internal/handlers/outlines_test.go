@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/outline"
+)
+
+// saveResponse is the body SaveOutline answers with, including a conflict.
+type saveResponse struct {
+	Success   bool               `json:"success"`
+	Conflict  bool               `json:"conflict"`
+	Merged    bool               `json:"merged"`
+	Error     string             `json:"error"`
+	ID        int                `json:"id"`
+	Version   int                `json:"version"`
+	Title     string             `json:"title"`
+	Content   string             `json:"content"`
+	Conflicts []outline.Conflict `json:"conflicts"`
+}
+
+// racingStore saves someone else's edit to the outline just before the
+// second UpdateOutline call, as if it had been saved in the meantime.
+type racingStore struct {
+	database.Store
+	calls int
+	race  func()
+}
+
+func (s *racingStore) UpdateOutline(id, userID, version int, title, content string) (int, error) {
+	s.calls++
+	if s.calls == 2 && s.race != nil {
+		s.race()
+	}
+	return s.Store.UpdateOutline(id, userID, version, title, content)
+}
+
+func TestSaveOutlineConflict(t *testing.T) {
+	s := newTestServer(t, "save_conflict")
+	alice := s.user("alice")
+
+	base := "<div>A</div>\n<div>B</div>"
+	id64, _ := s.db.CreateOutline(alice.ID, "Plan", base)
+	id := int(id64)
+	// Saved elsewhere since version 1 was loaded
+	s.db.UpdateOutline(id, alice.ID, 1, "Plan", "<div>A</div>\n<div>B</div>\n<div>C</div>")
+
+	save := func(version int, content string, merge bool) (int, saveResponse) {
+		rec := s.do(alice, http.MethodPost, "/api/outline/save", map[string]interface{}{
+			"id": id, "version": version, "title": "Plan", "content": content, "merge": merge,
+		})
+		var body saveResponse
+		if rec.Code == http.StatusOK || rec.Code == http.StatusConflict {
+			decode(t, rec, &body)
+		}
+		return rec.Code, body
+	}
+
+	if code, _ := save(0, base, false); code != http.StatusBadRequest {
+		t.Errorf("Expected a save without a version to be refused with 400, got %d", code)
+	}
+
+	// Without merge the client is sent the current version to choose from
+	code, body := save(1, "<div>Z</div>\n<div>A</div>\n<div>B</div>", false)
+	if code != http.StatusConflict || body.Success || !body.Conflict || body.Error == "" {
+		t.Fatalf("Expected a 409 conflict, got %d %+v", code, body)
+	}
+	if body.ID != id || body.Version != 2 || body.Title != "Plan" || body.Content != "<div>A</div>\n<div>B</div>\n<div>C</div>" {
+		t.Errorf("Expected the current version in the conflict, got %+v", body)
+	}
+	if body.Conflicts == nil || len(body.Conflicts) != 0 {
+		t.Errorf("Expected an empty conflict list, got %#v", body.Conflicts)
+	}
+
+	// A version the outline never had cannot be merged from
+	if code, _ := save(99, base, true); code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown version to be refused with 400, got %d", code)
+	}
+
+	// Overlapping edits are listed
+	code, body = save(1, "<div>A</div>\n<div>B</div>\n<div>Mine</div>", true)
+	if code != http.StatusConflict || len(body.Conflicts) != 1 || body.Conflicts[0].Text != "Mine" || body.Conflicts[0].Reason == "" {
+		t.Errorf("Expected one conflict on Mine, got %d %+v", code, body)
+	}
+
+	// Saved once more while the merge was being saved: the client is sent
+	// the newest version, with nothing in the way of merging again
+	racing := &racingStore{Store: s.db}
+	racing.race = func() {
+		s.db.UpdateOutline(id, alice.ID, 0, "Plan", "<div>A</div>\n<div>B</div>\n<div>C</div>\n<div>D</div>")
+	}
+	s.h.DB = racing
+	code, body = save(1, "<div>Z</div>\n<div>A</div>\n<div>B</div>", true)
+	if code != http.StatusConflict || body.Version != 3 || len(body.Conflicts) != 0 {
+		t.Fatalf("Expected a 409 with version 3 and no conflicts, got %d %+v", code, body)
+	}
+	s.h.DB = s.db
+
+	code, body = save(1, "<div>Z</div>\n<div>A</div>\n<div>B</div>", true)
+	want := "<div>Z</div>\n<div>A</div>\n<div>B</div>\n<div>C</div>\n<div>D</div>"
+	if code != http.StatusOK || !body.Success || !body.Merged || body.Version != 4 || body.Content != want {
+		t.Errorf("Expected the retried merge to be saved as version 4, got %d %+v", code, body)
+	}
+	if current, _ := s.db.GetOutline(id, alice.ID); current.Content != want {
+		t.Errorf("Expected the merged content saved, got %q", current.Content)
+	}
+}
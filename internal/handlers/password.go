@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// Password change handlers
+func (h *Handler) ChangePasswordPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	h.render(w, r, "password.html", map[string]interface{}{
+		"User":   user,
+		"Policy": h.Passwords.Describe(),
+	})
+}
+
+// ChangePassword sets a new password chosen by the user, after checking
+// their current one. Users made to change their password land here after
+// login.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	current := r.FormValue("current_password")
+	password := r.FormValue("new_password")
+	confirm := r.FormValue("confirm_password")
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		h.render(w, r, "password.html", map[string]interface{}{
+			"User":   user,
+			"Policy": h.Passwords.Describe(),
+			"Error":  message,
+		})
+	}
+
+	if status, message := h.checkNewPassword(w, r, user, current, password, confirm); message != "" {
+		fail(status, message)
+		return
+	}
+
+	if err := h.setOwnPassword(r, user, password); err != nil {
+		http.Error(w, "Error changing password", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// checkNewPassword returns why a user may not change their password from
+// current to password, and the status to answer with, or "" if they may.
+func (h *Handler) checkNewPassword(w http.ResponseWriter, r *http.Request, user *database.User, current, password, confirm string) (int, string) {
+	if status, message := h.checkOwnPassword(w, r, user, current, "Current password is incorrect"); message != "" {
+		return status, message
+	}
+	if password != confirm {
+		return http.StatusBadRequest, "New passwords do not match"
+	}
+	if password == current {
+		return http.StatusBadRequest, "New password must differ from the current one"
+	}
+	if err := h.Passwords.Check(user.Username, password); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+	return 0, ""
+}
+
+// checkOwnPassword checks the password a logged in user gave to confirm a
+// change to their account. If it is wrong it returns incorrect, or another
+// message if the check could not be made, with the status to answer with.
+// Checks go through the login limiter, so they cannot be used to guess the
+// password any faster than logging in.
+func (h *Handler) checkOwnPassword(w http.ResponseWriter, r *http.Request, user *database.User, password, incorrect string) (int, string) {
+	ip := middleware.ClientIP(r)
+	wait, err := h.Logins.Check(ip, user.Username)
+	if errors.Is(err, middleware.ErrLoginThrottled) || errors.Is(err, middleware.ErrAccountLocked) {
+		return http.StatusTooManyRequests, "Too many failed attempts. Try again in " + retryAfter(w, wait) + "."
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+
+	if _, err := h.DB.VerifyPassword(user.Username, password); err != nil {
+		if err := h.Logins.Failed(ip, user.Username); err != nil {
+			return http.StatusInternalServerError, "Internal server error"
+		}
+		return http.StatusBadRequest, incorrect
+	}
+	if err := h.Logins.Succeeded(ip, user.Username); err != nil {
+		return http.StatusInternalServerError, "Internal server error"
+	}
+	return 0, ""
+}
+
+// setOwnPassword stores a password a user chose and logs them out of every
+// other session, keeping the one making the request.
+func (h *Handler) setOwnPassword(r *http.Request, user *database.User, password string) error {
+	if err := h.DB.ChangePassword(user.ID, password); err != nil {
+		return err
+	}
+
+	sessionID := ""
+	if cookie, err := r.Cookie("session"); err == nil {
+		sessionID = cookie.Value
+	}
+	return h.Auth.RevokeOtherSessions(user.ID, sessionID)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChangePasswordThrottled(t *testing.T) {
+	s := newTestServer(t, "password_throttled")
+	alice := s.user("alice")
+
+	change := func(current string) int {
+		return s.do(alice, http.MethodPost, "/api/account/password", map[string]string{
+			"current_password": current,
+			"new_password":     "a much better password 2",
+			"confirm_password": "a much better password 2",
+		}).Code
+	}
+
+	// Wrong guesses count as failed logins, and soon have to wait
+	for i := 0; i < 3; i++ {
+		if code := change("guess"); code != http.StatusBadRequest {
+			t.Fatalf("Expected guess %d to be refused with 400, got %d", i+1, code)
+		}
+	}
+	change("guess")
+	if code := change("correct horse 1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected even the right password to wait after repeated guesses, got %d", code)
+	}
+
+	attempts, err := s.db.GetRecentLoginFailures(10)
+	if err != nil || len(attempts) == 0 || attempts[0].Username != "alice" {
+		t.Errorf("Expected the guesses in the login audit log, got %+v, %v", attempts, err)
+	}
+
+	// The form page is throttled the same way
+	rec := s.do(alice, http.MethodPost, "/password", "current_password=guess&new_password=x&confirm_password=x",
+		"Content-Type", "application/x-www-form-urlencoded")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After from the password page, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+	outlinetree "github.com/kristofer/composter/internal/outline"
+)
+
+// revisionJSON describes a revision in the outline history list.
+type revisionJSON struct {
+	ID        int       `json:"id"`
+	Version   int       `json:"version"`
+	Author    string    `json:"author"`
+	Title     string    `json:"title"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// queryInt reads a required integer query parameter, writing an error
+// response and returning false if it is missing or malformed.
+func queryInt(w http.ResponseWriter, r *http.Request, name, label string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		http.Error(w, label+" required", http.StatusBadRequest)
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		http.Error(w, "Invalid "+label, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// Outline revision handlers
+func (h *Handler) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	id, ok := queryInt(w, r, "id", "Outline ID")
+	if !ok {
+		return
+	}
+
+	revisions, err := h.DB.GetOutlineRevisions(id, user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving revisions", http.StatusInternalServerError)
+		return
+	}
+	if len(revisions) == 0 {
+		http.Error(w, "Outline not found", http.StatusNotFound)
+		return
+	}
+
+	list := make([]revisionJSON, len(revisions))
+	for i, rev := range revisions {
+		list[i] = revisionJSON{
+			ID:        rev.ID,
+			Version:   rev.Version,
+			Author:    rev.Author,
+			Title:     rev.Title,
+			Size:      rev.Size,
+			CreatedAt: rev.CreatedAt,
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"revisions": list,
+	})
+}
+
+// DiffRevisions reports the nodes added, removed and moved between two
+// revisions of an outline, from the older to the newer.
+func (h *Handler) DiffRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	id, ok := queryInt(w, r, "id", "Outline ID")
+	if !ok {
+		return
+	}
+	fromID, ok := queryInt(w, r, "from", "Revision ID")
+	if !ok {
+		return
+	}
+	toID, ok := queryInt(w, r, "to", "Revision ID")
+	if !ok {
+		return
+	}
+
+	from, err := h.DB.GetOutlineRevision(fromID, id, user.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	to, err := h.DB.GetOutlineRevision(toID, id, user.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	changes := outlinetree.Diff(outlinetree.Parse(from.Content), outlinetree.Parse(to.Content))
+	if changes == nil {
+		changes = []outlinetree.Change{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"title":   map[string]string{"from": from.Title, "to": to.Title},
+		"changes": changes,
+	})
+}
+
+// RestoreRevision saves an earlier revision's title and content as the
+// outline's current state. This records a new revision, so the restore can
+// itself be undone.
+func (h *Handler) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		ID       int `json:"id"`
+		Revision int `json:"revision"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	rev, err := h.DB.GetOutlineRevision(data.Revision, data.ID, user.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	version, err := h.DB.UpdateOutline(data.ID, user.ID, 0, rev.Title, rev.Content)
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(w, "You cannot edit this outline", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error restoring revision", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      data.ID,
+		"version": version,
+	})
+}
+
+func revisionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Error retrieving revision", http.StatusInternalServerError)
+}
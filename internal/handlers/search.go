@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+const (
+	// searchLimit caps how many matching nodes one search looks at.
+	searchLimit = 200
+	// searchMatchesPerItem caps how many nodes are shown for each outline or
+	// template found.
+	searchMatchesPerItem = 5
+)
+
+// searchItemJSON is an outline or template found by a search. The HTML
+// fields are escaped, with the search terms wrapped in <mark> elements.
+type searchItemJSON struct {
+	Type      string            `json:"type"`
+	ID        int               `json:"id"`
+	Title     string            `json:"title"`
+	TitleHTML string            `json:"title_html"`
+	URL       string            `json:"url"`
+	Matches   []searchMatchJSON `json:"matches"`
+	// More is how many further matching nodes were left out.
+	More int `json:"more"`
+}
+
+// searchMatchJSON is a matching node and the path of its ancestors.
+type searchMatchJSON struct {
+	PathHTML []string `json:"path_html"`
+	HTML     string   `json:"html"`
+}
+
+// Search finds outlines and templates containing every word of the q query
+// parameter. Results are grouped by item, best matches first.
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	results, err := h.DB.Search(user.ID, query, searchLimit)
+	if err != nil {
+		http.Error(w, "Error searching", http.StatusInternalServerError)
+		return
+	}
+
+	terms := database.SearchTerms(query)
+	items := []*searchItemJSON{}
+	byKey := make(map[string]*searchItemJSON)
+	for _, result := range results {
+		key := fmt.Sprintf("%s:%d", result.Type, result.ID)
+		item, ok := byKey[key]
+		if !ok {
+			item = &searchItemJSON{
+				Type:      result.Type,
+				ID:        result.ID,
+				Title:     result.Title,
+				TitleHTML: database.Highlight(result.Title, terms),
+				URL:       "/templates",
+				Matches:   []searchMatchJSON{},
+			}
+			if result.Type == database.SearchOutline {
+				item.URL = fmt.Sprintf("/editor?id=%d", result.ID)
+			}
+			byKey[key] = item
+			items = append(items, item)
+		}
+
+		// The title is shown anyway
+		if result.Node == 0 {
+			continue
+		}
+		if len(item.Matches) == searchMatchesPerItem {
+			item.More++
+			continue
+		}
+		match := searchMatchJSON{
+			PathHTML: make([]string, len(result.Path)),
+			HTML:     database.Highlight(result.Text, terms),
+		}
+		for i, text := range result.Path {
+			match.PathHTML[i] = database.Highlight(text, terms)
+		}
+		item.Matches = append(item.Matches, match)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"results": items,
+	})
+}
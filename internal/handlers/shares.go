@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// shareJSON is a user with access to an outline.
+type shareJSON struct {
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Role      string     `json:"role"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// Outline sharing handlers
+func (h *Handler) ListShares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	id, ok := queryInt(w, r, "id", "Outline ID")
+	if !ok {
+		return
+	}
+
+	shares, err := h.DB.GetOutlineShares(id, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Outline not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error retrieving shares", http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]shareJSON, len(shares))
+	for i, share := range shares {
+		list[i] = shareJSON{UserID: share.UserID, Username: share.Username, Role: share.Role}
+		if !share.CreatedAt.IsZero() {
+			list[i].CreatedAt = &shares[i].CreatedAt
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"shares":  list,
+	})
+}
+
+// ShareOutline gives the user named in the request a role on an outline, or
+// changes the role they have.
+func (h *Handler) ShareOutline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if !database.ValidRole(data.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	with, ok := h.shareUser(w, data.Username)
+	if !ok {
+		return
+	}
+
+	err := h.DB.ShareOutline(data.ID, user.ID, with.ID, data.Role)
+	if !shareError(w, err, "Error sharing outline") {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// UnshareOutline takes away a user's access to an outline. Anyone can take
+// away their own.
+func (h *Handler) UnshareOutline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		ID     int `json:"id"`
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.UnshareOutline(data.ID, user.ID, data.UserID)
+	if !shareError(w, err, "Error removing share") {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// TransferOutline makes the user named in the request the outline's owner.
+// Only its owner can, not those it is shared with as owners.
+func (h *Handler) TransferOutline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	to, ok := h.shareUser(w, data.Username)
+	if !ok {
+		return
+	}
+
+	err := h.DB.TransferOutline(data.ID, user.ID, to.ID)
+	if errors.Is(err, database.ErrForbidden) {
+		http.Error(w, "Only the outline's owner can transfer it", http.StatusForbidden)
+		return
+	}
+	if !shareError(w, err, "Error transferring outline") {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// shareUser looks up the user an outline is to be shared with or
+// transferred to, writing an error response and returning false if there
+// is no such user.
+func (h *Handler) shareUser(w http.ResponseWriter, username string) (*database.User, bool) {
+	user, err := h.DB.GetUser(strings.TrimSpace(username))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error retrieving user", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// shareError writes the response for an error from changing who has access
+// to an outline, returning true if there was no error.
+func shareError(w http.ResponseWriter, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Outline or share not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrForbidden):
+		http.Error(w, "Only an owner can change who has access to this outline", http.StatusForbidden)
+	case errors.Is(err, database.ErrShareWithOwner):
+		http.Error(w, "The outline already belongs to that user", http.StatusBadRequest)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+	return false
+}
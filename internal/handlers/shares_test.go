@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristofer/composter/internal/database"
+)
+
+func TestOutlineRoles(t *testing.T) {
+	s := newTestServer(t, "outline_roles")
+	owner := s.user("owner")
+	editor := s.user("editor")
+	coowner := s.user("coowner")
+	viewer := s.user("viewer")
+	stranger := s.user("stranger")
+
+	id64, _ := s.db.CreateOutline(owner.ID, "Plan", "<div>Step</div>")
+	id := int(id64)
+	s.db.ShareOutline(id, owner.ID, editor.ID, database.RoleEditor)
+	s.db.ShareOutline(id, owner.ID, coowner.ID, database.RoleOwner)
+	s.db.ShareOutline(id, owner.ID, viewer.ID, database.RoleViewer)
+	revisions, _ := s.db.GetOutlineRevisions(id, owner.ID)
+	revision := revisions[0].ID
+
+	// save is sent with the outline's current version, so that only the
+	// role decides the answer
+	save := func() map[string]interface{} {
+		current, _ := s.db.GetOutline(id, owner.ID)
+		return map[string]interface{}{"id": id, "version": current.Version, "title": "Plan", "content": "<div>Edited</div>"}
+	}
+	restore := map[string]interface{}{"id": id, "revision": revision}
+	remove := map[string]interface{}{"id": id}
+	share := map[string]interface{}{"id": id, "username": "stranger", "role": database.RoleViewer}
+	transfer := map[string]interface{}{"id": id, "username": "stranger"}
+	unshare := map[string]interface{}{"id": id, "user_id": editor.ID}
+	// There is no commenter role: it allowed nothing a viewer could not do
+	commenter := map[string]interface{}{"id": id, "username": "stranger", "role": "commenter"}
+
+	tests := []struct {
+		name   string
+		user   *database.User
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"viewer saves", viewer, "/api/outline/save", save(), http.StatusForbidden},
+		{"viewer restores", viewer, "/api/outline/restore", restore, http.StatusForbidden},
+		{"viewer deletes", viewer, "/api/outline/delete", remove, http.StatusForbidden},
+		{"viewer shares", viewer, "/api/outline/share", share, http.StatusForbidden},
+		{"viewer transfers", viewer, "/api/outline/transfer", transfer, http.StatusForbidden},
+		{"viewer removes another's share", viewer, "/api/outline/unshare", unshare, http.StatusForbidden},
+
+		{"owner shares as commenter", owner, "/api/outline/share", commenter, http.StatusBadRequest},
+
+		{"editor deletes", editor, "/api/outline/delete", remove, http.StatusForbidden},
+		{"editor shares", editor, "/api/outline/share", share, http.StatusForbidden},
+		{"editor transfers", editor, "/api/outline/transfer", transfer, http.StatusForbidden},
+
+		{"co-owner deletes", coowner, "/api/outline/delete", remove, http.StatusForbidden},
+		{"co-owner transfers", coowner, "/api/outline/transfer", transfer, http.StatusForbidden},
+
+		{"stranger saves", stranger, "/api/outline/save", save(), http.StatusNotFound},
+		{"stranger restores", stranger, "/api/outline/restore", restore, http.StatusNotFound},
+		{"stranger deletes", stranger, "/api/outline/delete", remove, http.StatusNotFound},
+		{"stranger shares", stranger, "/api/outline/share", share, http.StatusNotFound},
+		{"stranger transfers", stranger, "/api/outline/transfer", transfer, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := s.do(tt.user, http.MethodPost, tt.path, tt.body); rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.status, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := s.do(stranger, http.MethodGet, "/api/outline/shares?id="+strconv.Itoa(id), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected a stranger listing shares to get 404, got %d", rec.Code)
+	}
+	if rec := s.do(viewer, http.MethodGet, "/api/outline/shares?id="+strconv.Itoa(id), nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected a viewer to list shares, got %d", rec.Code)
+	}
+
+	// Nothing was changed by any of them
+	outline, _ := s.db.GetOutline(id, owner.ID)
+	if outline.Version != 1 || outline.UserID != owner.ID {
+		t.Fatalf("Expected the outline untouched, got %+v", outline)
+	}
+	if _, err := s.db.GetOutline(id, stranger.ID); err == nil {
+		t.Fatal("Expected the outline not to be shared with the stranger")
+	}
+
+	// The roles that may are let through
+	allowed := []struct {
+		name string
+		user *database.User
+		path string
+		body interface{}
+	}{
+		{"editor saves", editor, "/api/outline/save", save()},
+		{"editor restores", editor, "/api/outline/restore", restore},
+		{"co-owner shares", coowner, "/api/outline/share", share},
+		{"owner transfers", owner, "/api/outline/transfer", transfer},
+		{"viewer leaves", viewer, "/api/outline/unshare", map[string]interface{}{"id": id, "user_id": viewer.ID}},
+		{"new owner deletes", stranger, "/api/outline/delete", remove},
+	}
+	for _, tt := range allowed {
+		if rec := s.do(tt.user, http.MethodPost, tt.path, tt.body); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d: %s", tt.name, rec.Code, rec.Body)
+		}
+	}
+}
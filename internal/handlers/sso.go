@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/oidc"
+)
+
+// ssoStateCookie ties a login sent to the identity provider to the browser
+// that started it.
+const ssoStateCookie = "sso_state"
+
+// errNoSSOAccount is returned when a user the identity provider vouches for
+// has no account, or none it may be linked to, and accounts are not created
+// automatically.
+var errNoSSOAccount = errors.New("no account for this user")
+
+// SSOLogin sends the user to the identity provider to log in.
+func (h *Handler) SSOLogin(w http.ResponseWriter, r *http.Request) {
+	if h.SSO == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, authURL, err := h.SSO.Begin()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The provider redirects back from another site, so a strict cookie
+	// would not come back with it
+	cookies := h.Auth.Cookies
+	if cookies.SameSite == http.SameSiteStrictMode {
+		cookies.SameSite = http.SameSiteLaxMode
+	}
+	cookies.Set(w, r, &http.Cookie{
+		Name:  ssoStateCookie,
+		Value: state,
+		Path:  "/login/sso",
+	})
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// SSOCallback completes a login when the identity provider sends the user
+// back, then continues as if they had given the right password.
+func (h *Handler) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	if h.SSO == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	h.Auth.Cookies.Clear(w, r, ssoStateCookie, "/login/sso")
+
+	fail := func(message string) {
+		w.WriteHeader(http.StatusUnauthorized)
+		h.render(w, r, "login.html", map[string]interface{}{
+			"Error": message,
+		})
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("SSO login refused by provider: %s %s", e, q.Get("error_description"))
+		fail("Single sign-on was cancelled or refused")
+		return
+	}
+
+	cookie, err := r.Cookie(ssoStateCookie)
+	if err != nil || cookie.Value != q.Get("state") {
+		fail("Single sign-on failed. Please try again.")
+		return
+	}
+
+	claims, err := h.SSO.Finish(r.Context(), cookie.Value, q.Get("code"))
+	if err != nil {
+		log.Printf("SSO login failed: %v", err)
+		fail("Single sign-on failed. Please try again.")
+		return
+	}
+
+	user, err := h.ssoUser(claims)
+	if errors.Is(err, errNoSSOAccount) {
+		fail("There is no Composter account for " + claims.Username)
+		return
+	}
+	if err != nil {
+		log.Printf("SSO login for %q failed: %v", claims.Subject, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabled {
+		h.startSecondFactor(w, r, user)
+		return
+	}
+	h.startSession(w, r, user)
+}
+
+// ssoLinking reports whether single sign-on accounts may be linked to
+// existing users without a password.
+func (h *Handler) ssoLinking() bool {
+	return h.SSO != nil && h.SSO.Config().LinkExisting
+}
+
+// ssoUser finds the user an identity provider account belongs to. The first
+// time an account logs in, a user is created for it if the provider is
+// configured to do so and nobody has its username yet. An existing user is
+// only linked to it if linking is turned on and the user is not an admin and
+// has no password: the username comes from the provider, where users can
+// often choose it. Admin rights follow the account's groups if admin groups
+// are configured.
+func (h *Handler) ssoUser(claims *oidc.Claims) (*database.User, error) {
+	config := h.SSO.Config()
+	admin, managed := h.SSO.IsAdmin(claims)
+
+	user, err := h.DB.GetUserByIdentity(config.Issuer, claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		if claims.Username == "" {
+			return nil, errNoSSOAccount
+		}
+
+		user, err = h.DB.GetUser(claims.Username)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if !config.AutoProvision {
+				return nil, errNoSSOAccount
+			}
+			user, err = h.DB.CreateExternalUser(claims.Username, admin)
+		case err != nil:
+		case !config.LinkExisting || user.IsAdmin || user.Password != "":
+			log.Printf("SSO login for %q refused: %q is an existing user it may not be linked to", claims.Subject, claims.Username)
+			return nil, errNoSSOAccount
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := h.DB.LinkIdentity(user.ID, config.Issuer, claims.Subject); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if managed && user.IsAdmin != admin {
+		if err := h.DB.SetAdmin(user.ID, admin); err != nil {
+			return nil, err
+		}
+		user.IsAdmin = admin
+		h.Auth.UserChanged(user.ID)
+	}
+	return user, nil
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kristofer/composter/internal/oidc"
+	"github.com/kristofer/composter/internal/oidc/oidctest"
+)
+
+// newSSOServer is a test server whose users can log in through a fake
+// identity provider, with linking and provisioning set as given.
+func newSSOServer(t *testing.T, name string, autoProvision, linkExisting bool) (*testServer, *oidctest.Provider) {
+	t.Helper()
+	s := newTestServer(t, name)
+
+	fake := oidctest.NewProvider("composter", "secret")
+	t.Cleanup(fake.Close)
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:        fake.Issuer(),
+		ClientID:      fake.ClientID,
+		ClientSecret:  fake.ClientSecret,
+		RedirectURL:   "http://composter.test/login/sso/callback",
+		AutoProvision: autoProvision,
+		LinkExisting:  linkExisting,
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure single sign-on: %v", err)
+	}
+	s.h.SSO = provider
+	return s, fake
+}
+
+// ssoLogin logs in through the fake provider as the account subject called
+// username there, and returns the response to the callback.
+func (s *testServer) ssoLogin(fake *oidctest.Provider, subject, username string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	fake.Claims["sub"] = subject
+	fake.Claims["preferred_username"] = username
+
+	rec := s.do(nil, http.MethodGet, "/login/sso", nil)
+	var state string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == ssoStateCookie {
+			state = c.Value
+		}
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatalf("Failed to reach the provider: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		s.t.Fatalf("Unexpected callback %q: %v", resp.Header.Get("Location"), err)
+	}
+
+	return s.do(nil, http.MethodGet, callback.RequestURI(), nil, "Cookie", ssoStateCookie+"="+state)
+}
+
+// loggedIn reports whether a response started a session.
+func loggedIn(rec *httptest.ResponseRecorder) bool {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" && c.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSSOLogin(t *testing.T) {
+	s, fake := newSSOServer(t, "sso_login", true, false)
+
+	// A new username is provisioned, and found by subject from then on
+	if rec := s.ssoLogin(fake, "subject-1", "newcomer"); !loggedIn(rec) {
+		t.Fatalf("Expected a new user to be provisioned, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := s.ssoLogin(fake, "subject-1", "renamed"); !loggedIn(rec) {
+		t.Errorf("Expected the linked account to log in again, got %d", rec.Code)
+	}
+	if _, err := s.db.GetUser("renamed"); err == nil {
+		t.Error("Expected no second user for a linked account")
+	}
+}
+
+func TestSSONeverTakesOverExistingUsers(t *testing.T) {
+	s, fake := newSSOServer(t, "sso_takeover", true, true)
+	s.user("alice")
+	s.db.CreateUser("boss", "", true, false)
+
+	// Someone who calls themselves admin, alice or boss at the provider
+	// does not become them, even with linking turned on
+	for _, username := range []string{"admin", "alice", "boss"} {
+		rec := s.ssoLogin(fake, "attacker-"+username, username)
+		if loggedIn(rec) || !strings.Contains(rec.Body.String(), "There is no Composter account") {
+			t.Errorf("%s: expected the login to be refused, got %d", username, rec.Code)
+		}
+		if user, err := s.db.GetUserByIdentity(fake.Issuer(), "attacker-"+username); err == nil {
+			t.Errorf("%s: expected no link, got user %d", username, user.ID)
+		}
+	}
+
+	admin, _ := s.db.GetUser("admin")
+	if !admin.IsAdmin {
+		t.Error("Expected the admin to be left alone")
+	}
+}
+
+func TestSSOLinkExisting(t *testing.T) {
+	s, fake := newSSOServer(t, "sso_link", false, true)
+	admin, _ := s.db.GetUser("admin")
+
+	// An admin prepares a user with no password for someone at the provider
+	rec := s.do(admin, http.MethodPost, "/api/admin/user/create", map[string]interface{}{"username": "carol"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a user without a password to be created, got %d: %s", rec.Code, rec.Body)
+	}
+	carol, _ := s.db.GetUser("carol")
+	if carol.Password != "" || carol.MustChangePassword {
+		t.Errorf("Expected carol to have no password to change, got %+v", carol)
+	}
+	if _, err := s.db.VerifyPassword("carol", ""); err == nil {
+		t.Error("Expected an empty password not to log carol in")
+	}
+
+	if rec := s.ssoLogin(fake, "carol-subject", "carol"); !loggedIn(rec) {
+		t.Fatalf("Expected carol to be linked, got %d: %s", rec.Code, rec.Body)
+	}
+	if linked, err := s.db.GetUserByIdentity(fake.Issuer(), "carol-subject"); err != nil || linked.ID != carol.ID {
+		t.Errorf("Expected carol's account to be linked, got %+v, %v", linked, err)
+	}
+
+	// Without linking, nobody is let in as an existing user
+	s.h.SSO, _ = oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer: fake.Issuer(), ClientID: fake.ClientID, ClientSecret: fake.ClientSecret,
+		RedirectURL: "http://composter.test/login/sso/callback",
+	})
+	s.db.CreateUser("dave", "", false, false)
+	if rec := s.ssoLogin(fake, "dave-subject", "dave"); loggedIn(rec) {
+		t.Error("Expected dave not to be linked with linking off")
+	}
+	if rec := s.do(admin, http.MethodPost, "/api/admin/user/create", map[string]interface{}{"username": "erin"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a user without a password to be refused with linking off, got %d", rec.Code)
+	}
+}
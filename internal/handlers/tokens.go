@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// requireSession rejects requests authenticated with an API token, so that a
+// leaked token cannot be used to mint or revoke other credentials.
+func requireSession(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := middleware.GetAPIToken(r); ok {
+		http.Error(w, "This action requires a browser session", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// API token handlers
+func (h *Handler) TokensPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	tokens, err := h.DB.GetUserAPITokens(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving API tokens", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "tokens.html", map[string]interface{}{
+		"User":   user,
+		"Tokens": tokens,
+	})
+}
+
+func (h *Handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 for no expiry
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+
+	if data.ExpiresInDays < 0 {
+		http.Error(w, "Invalid expiry", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if data.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, data.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, id, err := h.DB.CreateAPIToken(user.ID, data.Name, data.Scopes, expiresAt)
+	if errors.Is(err, database.ErrInvalidScope) {
+		http.Error(w, "Invalid token scopes", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error creating API token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      id,
+		"token":   token,
+	})
+}
+
+func (h *Handler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		ID int `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.DeleteAPIToken(data.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Error revoking API token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/kristofer/composter/internal/middleware"
+)
+
+// trashRequest names an item in the trash: an outline or a template.
+type trashRequest struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+// Trash handlers
+func (h *Handler) TrashPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	outlines, err := h.DB.GetTrashedOutlines(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving outlines", http.StatusInternalServerError)
+		return
+	}
+
+	templates, err := h.DB.GetTrashedTemplates(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving templates", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "trash.html", map[string]interface{}{
+		"User":          user,
+		"Outlines":      outlines,
+		"Templates":     templates,
+		"RetentionDays": int(math.Ceil(h.TrashRetention.Hours() / 24)),
+	})
+}
+
+// RestoreFromTrash takes an outline or template out of the trash.
+func (h *Handler) RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	h.trashAction(w, r, h.DB.RestoreOutline, h.DB.RestoreTemplate, "Error restoring item")
+}
+
+// PurgeFromTrash deletes an outline or template in the trash for good.
+func (h *Handler) PurgeFromTrash(w http.ResponseWriter, r *http.Request) {
+	h.trashAction(w, r, h.DB.PurgeOutline, h.DB.PurgeTemplate, "Error deleting item")
+}
+
+// trashAction applies onOutline or onTemplate, depending on the type of item
+// requested, to one of the user's items in the trash.
+func (h *Handler) trashAction(w http.ResponseWriter, r *http.Request, onOutline, onTemplate func(id, userID int) error, message string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data trashRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch data.Type {
+	case "outline":
+		err = onOutline(data.ID, user.ID)
+	case "template":
+		err = onTemplate(data.ID, user.ID)
+	default:
+		http.Error(w, "Unknown item type", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Item not found in trash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+func (h *Handler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	if err := h.DB.EmptyTrash(user.ID); err != nil {
+		http.Error(w, "Error emptying trash", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RecoverDeletedUser moves a deleted user's outlines and templates to
+// another user's trash.
+func (h *Handler) RecoverDeletedUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		ID     int `json:"id"`
+		UserID int `json:"user_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.RecoverDeletedUser(data.ID, data.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error recovering content", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// PurgeDeletedUser deletes a deleted user's outlines and templates for good.
+func (h *Handler) PurgeDeletedUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		ID int `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.PurgeDeletedUser(data.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error deleting content", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
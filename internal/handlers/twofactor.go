@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/middleware"
+	"github.com/kristofer/composter/internal/totp"
+)
+
+// pendingLoginCookie holds the ID of a login waiting for its one-time code.
+const pendingLoginCookie = "login_pending"
+
+// totpIssuer names the service in authenticator apps.
+const totpIssuer = "Composter"
+
+// pendingLogin returns the pending login a request belongs to.
+func (h *Handler) pendingLogin(r *http.Request) (string, *database.User, bool) {
+	cookie, err := r.Cookie(pendingLoginCookie)
+	if err != nil {
+		return "", nil, false
+	}
+
+	userID, ok := h.Auth.Pending.Get(cookie.Value)
+	if !ok {
+		return "", nil, false
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		return "", nil, false
+	}
+	return cookie.Value, user, true
+}
+
+// startSecondFactor sends a user who has proved who they are some other way
+// on to enter a one-time code.
+func (h *Handler) startSecondFactor(w http.ResponseWriter, r *http.Request, user *database.User) {
+	pendingID, err := h.Auth.Pending.Start(user.ID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.Auth.Cookies.Set(w, r, &http.Cookie{
+		Name:  pendingLoginCookie,
+		Value: pendingID,
+		Path:  "/login",
+	})
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// Second login step handlers
+func (h *Handler) LoginTwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := h.pendingLogin(r); !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	h.render(w, r, "login_2fa.html", nil)
+}
+
+// LoginTwoFactor completes a login by checking a one-time code or recovery
+// code. Wrong codes count towards the same limits as wrong passwords.
+func (h *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pendingID, user, ok := h.pendingLogin(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	ip := middleware.ClientIP(r)
+	if !h.checkLoginLimit(w, r, "login_2fa.html", ip, user.Username) {
+		return
+	}
+
+	ok, err := h.verifySecondFactor(user, r.FormValue("code"))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		h.Auth.Pending.Fail(pendingID)
+		if err := h.Logins.Failed(ip, user.Username); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Too many wrong codes abandon the login
+		if _, still := h.Auth.Pending.Get(pendingID); !still {
+			h.Auth.Cookies.Clear(w, r, pendingLoginCookie, "/login")
+			h.render(w, r, "login.html", map[string]interface{}{
+				"Error": "Too many invalid codes. Please log in again.",
+			})
+			return
+		}
+
+		h.render(w, r, "login_2fa.html", map[string]interface{}{
+			"Error": "Invalid code",
+		})
+		return
+	}
+
+	h.Auth.Pending.Finish(pendingID)
+	h.Auth.Cookies.Clear(w, r, pendingLoginCookie, "/login")
+
+	if err := h.Logins.Succeeded(ip, user.Username); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.startSession(w, r, user)
+}
+
+// verifySecondFactor checks a code from the user's authenticator app or, if
+// it is not shaped like one, against their unused recovery codes. Each code
+// is accepted only once.
+func (h *Handler) verifySecondFactor(user *database.User, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if code == "" {
+		return false, nil
+	}
+
+	if len(code) == totp.Digits {
+		enrollment, err := h.DB.GetTOTP(user.ID)
+		if err != nil {
+			return false, err
+		}
+		if !enrollment.Enabled {
+			return false, nil
+		}
+
+		step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return h.DB.UseTOTPStep(user.ID, step)
+	}
+
+	return h.DB.UseRecoveryCode(user.ID, code)
+}
+
+// Enrollment handlers
+func (h *Handler) TwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.GetUser(r)
+
+	remaining, err := h.DB.CountRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "twofactor.html", map[string]interface{}{
+		"User":          user,
+		"Required":      h.Auth.TwoFactorRequirement().Applies(user),
+		"RecoveryCodes": remaining,
+	})
+}
+
+// SetupTwoFactor generates a new secret for the user to add to their
+// authenticator app. It takes effect once EnableTwoFactor sees a valid code.
+func (h *Handler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.DB.SetTOTPSecret(user.ID, secret); err != nil {
+		http.Error(w, "Error saving secret", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"secret":  secret,
+		"uri":     totp.URI(totpIssuer, user.Username, secret),
+	})
+}
+
+// EnableTwoFactor turns on two-factor authentication once the user proves
+// their app is set up, and returns their recovery codes.
+func (h *Handler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.DB.GetTOTP(user.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving secret", http.StatusInternalServerError)
+		return
+	}
+	if enrollment.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if enrollment.Secret == "" {
+		http.Error(w, "Start setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, strings.TrimSpace(data.Code), time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.EnableTOTP(user.ID, step); err != nil {
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	h.Auth.UserChanged(user.ID)
+
+	codes, err := h.DB.NewRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns off two-factor authentication after checking the
+// user's password, unless it is required for them.
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if status, message := h.checkOwnPassword(w, r, user, data.Password, "Password is incorrect"); message != "" {
+		http.Error(w, message, status)
+		return
+	}
+
+	if h.Auth.TwoFactorRequirement().Applies(user) {
+		http.Error(w, "Two-factor authentication is required for your account", http.StatusForbidden)
+		return
+	}
+
+	if err := h.DB.DisableTOTP(user.ID); err != nil {
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	h.Auth.UserChanged(user.ID)
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// their password.
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !requireSession(w, r) {
+		return
+	}
+
+	user, _ := middleware.GetUser(r)
+
+	var data struct {
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if status, message := h.checkOwnPassword(w, r, user, data.Password, "Password is incorrect"); message != "" {
+		http.Error(w, message, status)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.DB.NewRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// SetTwoFactorRequirement lets an admin require two-factor authentication
+// for admins or for everyone.
+func (h *Handler) SetTwoFactorRequirement(w http.ResponseWriter, r *http.Request) {
+	if !h.Features.TwoFactor {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Require string `json:"require"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req, err := middleware.ParseTwoFactorRequirement(data.Require)
+	if err != nil {
+		http.Error(w, "Invalid requirement", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.SetSetting(database.SettingRequireTwoFactor, string(req)); err != nil {
+		http.Error(w, "Error saving setting", http.StatusInternalServerError)
+		return
+	}
+	h.Auth.SetTwoFactorRequirement(req)
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
@@ -2,71 +2,261 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/kristofer/composter/internal/database"
 )
 
 type contextKey string
 
-const UserKey contextKey = "user"
+const (
+	UserKey  contextKey = "user"
+	TokenKey contextKey = "token"
+)
+
+// userCacheTTL is how long a user loaded for a session is reused before it
+// is read from the database again.
+const userCacheTTL = 5 * time.Second
+
+// Authenticator resolves the user behind each request from either a session
+// cookie or an API token.
+type Authenticator struct {
+	Sessions SessionStore
+	DB       database.Store
+
+	// Timeouts are the session lifetimes Sessions was created with.
+	Timeouts SessionTimeouts
+
+	// Pending holds logins waiting for a second factor.
+	Pending *PendingLogins
 
-type SessionStore struct {
-	sessions map[string]*database.User
+	// Cookies are the attributes session and login cookies are set with.
+	Cookies CookiePolicy
+
+	// APITokens is whether API tokens are accepted at all. When it is off,
+	// requests with a token are refused even on the API's routes.
+	APITokens bool
+
+	users *userCache
+
+	mu        sync.Mutex
+	twoFactor TwoFactorRequirement
 }
 
-func NewSessionStore() *SessionStore {
-	return &SessionStore{
-		sessions: make(map[string]*database.User),
+func NewAuthenticator(db database.Store, sessions SessionStore, timeouts SessionTimeouts) *Authenticator {
+	return &Authenticator{
+		Sessions:  sessions,
+		DB:        db,
+		Timeouts:  timeouts,
+		Pending:   NewPendingLogins(pendingLoginTTL),
+		Cookies:   DefaultCookiePolicy,
+		APITokens: true,
+		users:     newUserCache(db, userCacheTTL),
 	}
 }
 
-func (s *SessionStore) Set(sessionID string, user *database.User) {
-	s.sessions[sessionID] = user
+// TwoFactorRequirement returns which users must enroll in two-factor
+// authentication.
+func (a *Authenticator) TwoFactorRequirement() TwoFactorRequirement {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.twoFactor
 }
 
-func (s *SessionStore) Get(sessionID string) (*database.User, bool) {
-	user, ok := s.sessions[sessionID]
-	return user, ok
+// SetTwoFactorRequirement changes which users must enroll in two-factor
+// authentication. Users it newly applies to are sent to enroll on their next
+// request.
+func (a *Authenticator) SetTwoFactorRequirement(req TwoFactorRequirement) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.twoFactor = req
+}
+
+// SessionUser returns the current user for a session ID.
+func (a *Authenticator) SessionUser(sessionID string) (*database.User, bool) {
+	userID, ok := a.Sessions.Get(sessionID)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := a.users.get(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The user has been deleted
+		a.Sessions.Delete(sessionID)
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// UserChanged drops any cached copy of a user so their next request sees
+// the change.
+func (a *Authenticator) UserChanged(userID int) {
+	a.users.invalidate(userID)
+}
+
+// RevokeUser ends every session belonging to a user. Used when a user is
+// deleted, loses admin rights or has their password changed.
+func (a *Authenticator) RevokeUser(userID int) error {
+	a.users.invalidate(userID)
+	return a.Sessions.DeleteUser(userID)
+}
+
+// RevokeOtherSessions ends every session belonging to a user except the one
+// making the request. Used when a user changes their own password.
+func (a *Authenticator) RevokeOtherSessions(userID int, sessionID string) error {
+	a.users.invalidate(userID)
+	return a.Sessions.DeleteUserExcept(userID, sessionID)
 }
 
-func (s *SessionStore) Delete(sessionID string) {
-	delete(s.sessions, sessionID)
+// authResult is the outcome of authenticating a request.
+type authResult int
+
+const (
+	authOK authResult = iota
+	authMissing
+	authInvalid
+	authScope
+	authTokenRefused
+)
+
+// authenticate resolves the user behind a request. Where allowTokens is set,
+// a bearer token in the Authorization header takes precedence over the
+// session cookie, and requests made with a token also get the token in their
+// context. Elsewhere a request with an Authorization header is refused, so
+// that a token cannot reach the pages and endpoints meant for the browser.
+func (a *Authenticator) authenticate(r *http.Request, allowTokens bool) (*http.Request, *database.User, authResult) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !allowTokens || !a.APITokens {
+			return r, nil, authTokenRefused
+		}
+
+		plain, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return r, nil, authInvalid
+		}
+
+		user, token, err := a.DB.AuthenticateAPIToken(strings.TrimSpace(plain))
+		if err != nil {
+			return r, nil, authInvalid
+		}
+
+		if !tokenPermits(token, r.Method) {
+			return r, user, authScope
+		}
+
+		ctx := context.WithValue(r.Context(), UserKey, user)
+		ctx = context.WithValue(ctx, TokenKey, token)
+		return r.WithContext(ctx), user, authOK
+	}
+
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return r, nil, authMissing
+	}
+
+	user, ok := a.SessionUser(cookie.Value)
+	if !ok {
+		return r, nil, authMissing
+	}
+
+	ctx := context.WithValue(r.Context(), UserKey, user)
+	return r.WithContext(ctx), user, authOK
 }
 
-func AuthRequired(store *SessionStore) func(http.Handler) http.Handler {
+// pendingSetup returns the page a user must visit before they may do
+// anything else: the password change page if their password was chosen by
+// someone else, then two-factor setup if they are required to enroll. It
+// returns "" if there is no such page, or if the request is part of that
+// setup or a logout.
+func (a *Authenticator) pendingSetup(r *http.Request, user *database.User) string {
+	path := r.URL.Path
+	if path == "/logout" {
+		return ""
+	}
+
+	if user.MustChangePassword {
+		if path == PasswordChangePath {
+			return ""
+		}
+		return PasswordChangePath
+	}
+
+	if !user.TOTPEnabled && a.TwoFactorRequirement().Applies(user) {
+		if path == TwoFactorPath || strings.HasPrefix(path, TwoFactorAPIPrefix) {
+			return ""
+		}
+		return TwoFactorPath
+	}
+	return ""
+}
+
+// tokenPermits reports whether a token's scopes allow a request with method.
+// Read tokens may only make safe requests; write tokens may make any.
+func tokenPermits(token *database.APIToken, method string) bool {
+	if token.HasScope(database.ScopeWrite) {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return token.HasScope(database.ScopeRead)
+	}
+	return false
+}
+
+// deny responds to a request that failed authentication. Browsers without a
+// session are sent to the login page; tokens get a plain 401.
+func deny(w http.ResponseWriter, r *http.Request, result authResult) {
+	switch result {
+	case authMissing:
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	case authTokenRefused:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="composter"`)
+		http.Error(w, "API tokens are only accepted by /api/v1", http.StatusUnauthorized)
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="composter"`)
+		http.Error(w, "Invalid API token", http.StatusUnauthorized)
+	}
+}
+
+func AuthRequired(auth *Authenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session")
-			if err != nil {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			r, user, result := auth.authenticate(r, false)
+			if result != authOK {
+				deny(w, r, result)
 				return
 			}
 
-			user, ok := store.Get(cookie.Value)
-			if !ok {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			if path := auth.pendingSetup(r, user); path != "" {
+				http.Redirect(w, r, path, http.StatusSeeOther)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserKey, user)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func AdminRequired(store *SessionStore) func(http.Handler) http.Handler {
+func AdminRequired(auth *Authenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session")
-			if err != nil {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			r, user, result := auth.authenticate(r, false)
+			if result != authOK {
+				deny(w, r, result)
 				return
 			}
 
-			user, ok := store.Get(cookie.Value)
-			if !ok {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			if path := auth.pendingSetup(r, user); path != "" {
+				http.Redirect(w, r, path, http.StatusSeeOther)
 				return
 			}
 
@@ -75,13 +265,121 @@ func AdminRequired(store *SessionStore) func(http.Handler) http.Handler {
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserKey, user)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuthRequired is AuthRequired for JSON APIs: failures get an error
+// envelope instead of a redirect to the login page. It is the only one that
+// accepts API tokens.
+func APIAuthRequired(auth *Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, user, result := auth.authenticate(r, true)
+			switch result {
+			case authOK:
+				if path := auth.pendingSetup(r, user); path != "" {
+					WriteJSONError(w, http.StatusForbidden, "Account setup required at "+path)
+					return
+				}
+				next.ServeHTTP(w, r)
+			case authScope:
+				WriteJSONError(w, http.StatusForbidden, "Token scope does not allow this request")
+			case authTokenRefused:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="composter"`)
+				WriteJSONError(w, http.StatusUnauthorized, "API tokens are disabled")
+			case authInvalid:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="composter"`)
+				WriteJSONError(w, http.StatusUnauthorized, "Invalid API token")
+			default:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="composter"`)
+				WriteJSONError(w, http.StatusUnauthorized, "Authentication required")
+			}
 		})
 	}
 }
 
+// WriteJSONError sends the error envelope used by the JSON APIs:
+//
+//	{"error": {"status": 404, "message": "Outline not found"}}
+func WriteJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
 func GetUser(r *http.Request) (*database.User, bool) {
 	user, ok := r.Context().Value(UserKey).(*database.User)
 	return user, ok
 }
+
+// GetAPIToken returns the API token a request was authenticated with, if it
+// was not authenticated by session cookie.
+func GetAPIToken(r *http.Request) (*database.APIToken, bool) {
+	token, ok := r.Context().Value(TokenKey).(*database.APIToken)
+	return token, ok
+}
+
+// userCache holds recently loaded users so that authenticating a burst of
+// requests does not read the users table for each one.
+type userCache struct {
+	db  database.Store
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[int]cachedUser
+	// version is bumped on every invalidation so that a load which raced
+	// with one does not store a stale user.
+	version uint64
+}
+
+type cachedUser struct {
+	user     *database.User
+	loadedAt time.Time
+}
+
+func newUserCache(db database.Store, ttl time.Duration) *userCache {
+	return &userCache{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[int]cachedUser),
+	}
+}
+
+func (c *userCache) get(userID int) (*database.User, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	version := c.version
+	c.mu.Unlock()
+
+	if ok && time.Since(entry.loadedAt) < c.ttl {
+		return entry.user, nil
+	}
+
+	user, err := c.db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.version == version {
+		c.entries[userID] = cachedUser{user: user, loadedAt: time.Now()}
+	}
+	c.mu.Unlock()
+
+	return user, nil
+}
+
+func (c *userCache) invalidate(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.version++
+}
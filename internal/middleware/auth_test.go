@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serve runs a request with a session cookie through handler and returns the
+// response status.
+func serve(handler http.Handler, sessionID string) int {
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuthenticatorReloadsUser(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_auth_reload.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	if err := db.CreateUser("bob", "secret", false, false); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bob, err := db.GetUser("bob")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	store.Set("bob-session", bob.ID)
+
+	admin := AdminRequired(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if code := serve(admin, "bob-session"); code != http.StatusForbidden {
+		t.Fatalf("Expected non-admin to be forbidden, got %d", code)
+	}
+
+	// Promotion takes effect on the next request, without logging in again
+	if err := db.UpdateUser(bob.ID, "bob", "", true, false); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	auth.UserChanged(bob.ID)
+
+	if code := serve(admin, "bob-session"); code != http.StatusOK {
+		t.Errorf("Expected promoted user to be allowed, got %d", code)
+	}
+}
+
+func TestAuthenticatorRevokeUser(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_auth_revoke.db")
+	store := NewDatabaseStore(db, testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	store.Set("first", admin.ID)
+	store.Set("second", admin.ID)
+
+	handler := AuthRequired(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if code := serve(handler, "first"); code != http.StatusOK {
+		t.Fatalf("Expected valid session to be allowed, got %d", code)
+	}
+
+	if err := auth.RevokeUser(admin.ID); err != nil {
+		t.Fatalf("Failed to revoke sessions: %v", err)
+	}
+
+	for _, id := range []string{"first", "second"} {
+		if code := serve(handler, id); code != http.StatusSeeOther {
+			t.Errorf("Expected revoked session %s to be redirected to login, got %d", id, code)
+		}
+	}
+}
+
+func TestAuthenticatorDeletedUser(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_auth_deleted.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	if err := db.CreateUser("carol", "secret", false, false); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	carol, err := db.GetUser("carol")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	store.Set("carol-session", carol.ID)
+
+	if err := db.DeleteUser(carol.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	// Even without an explicit revoke, a session for a missing user is refused
+	if _, ok := auth.SessionUser("carol-session"); ok {
+		t.Error("Expected session of deleted user to be refused")
+	}
+	if _, ok := store.Get("carol-session"); ok {
+		t.Error("Expected session of deleted user to be removed")
+	}
+}
+
+func TestAPITokensOnlyForAPI(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_auth_tokens.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+	token, _, err := db.CreateAPIToken(admin.ID, "Script", []string{"write"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(handler http.Handler) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/user/create", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Even an admin's write token cannot reach the browser's endpoints
+	if code := request(AdminRequired(auth)(ok)); code != http.StatusUnauthorized {
+		t.Errorf("Expected a token to be refused by AdminRequired, got %d", code)
+	}
+	if code := request(AuthRequired(auth)(ok)); code != http.StatusUnauthorized {
+		t.Errorf("Expected a token to be refused by AuthRequired, got %d", code)
+	}
+	if code := request(APIAuthRequired(auth)(ok)); code != http.StatusOK {
+		t.Errorf("Expected a token to be accepted by APIAuthRequired, got %d", code)
+	}
+
+	// Nor can a token get around a password change the user still owes
+	if err := db.SetMustChangePassword(admin.ID, true); err != nil {
+		t.Fatalf("Failed to set MustChangePassword: %v", err)
+	}
+	auth.UserChanged(admin.ID)
+	if code := request(APIAuthRequired(auth)(ok)); code != http.StatusForbidden {
+		t.Errorf("Expected a token to be held up by a pending password change, got %d", code)
+	}
+}
+
+func TestAPITokensDisabled(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_auth_tokens_disabled.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+	auth.APITokens = false
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+	token, _, err := db.CreateAPIToken(admin.ID, "Script", []string{"read"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/outlines", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	APIAuthRequired(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a token to be refused while tokens are disabled, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import "net/http"
+
+// CookiePolicy holds the attributes shared by every cookie Composter sets.
+// None of them need to be read by scripts, so all are HttpOnly.
+type CookiePolicy struct {
+	// Secure restricts cookies to HTTPS even when set in reply to a plain
+	// HTTP request. Cookies set over HTTPS are always Secure.
+	Secure   bool
+	SameSite http.SameSite
+}
+
+var DefaultCookiePolicy = CookiePolicy{
+	SameSite: http.SameSiteLaxMode,
+}
+
+// ParseSameSite converts "lax", "strict" or "none" to an http.SameSite.
+func ParseSameSite(s string) (http.SameSite, bool) {
+	switch s {
+	case "lax":
+		return http.SameSiteLaxMode, true
+	case "strict":
+		return http.SameSiteStrictMode, true
+	case "none":
+		return http.SameSiteNoneMode, true
+	}
+	return 0, false
+}
+
+// Set adds cookie to the response to r with the policy's attributes.
+func (p CookiePolicy) Set(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) {
+	cookie.HttpOnly = true
+	cookie.Secure = p.Secure || IsHTTPS(r)
+	cookie.SameSite = p.SameSite
+	http.SetCookie(w, cookie)
+}
+
+// Clear removes the cookie called name that was set for path.
+func (p CookiePolicy) Clear(w http.ResponseWriter, r *http.Request, name, path string) {
+	p.Set(w, r, &http.Cookie{
+		Name:   name,
+		Value:  "",
+		Path:   path,
+		MaxAge: -1,
+	})
+}
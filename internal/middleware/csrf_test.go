@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfPost sends a POST through the CSRF middleware with the given cookie
+// token, header token and extra headers, returning the response status.
+func csrfPost(cookieToken, headerToken string, headers map[string]string) int {
+	handler := CSRF(DefaultCookiePolicy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/outline/delete", nil)
+	if cookieToken != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookieToken})
+	}
+	if headerToken != "" {
+		req.Header.Set(csrfHeaderName, headerToken)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestCSRFIssuesToken(t *testing.T) {
+	var seen string
+	handler := CSRF(DefaultCookiePolicy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = CSRFToken(r)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected GET to be allowed, got %d", rec.Code)
+	}
+	if seen == "" {
+		t.Fatal("Expected a token in the request context")
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value != seen {
+		t.Errorf("Expected the context token to be set as a cookie, got %v", cookies)
+	}
+}
+
+func TestCSRFRequiresToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookie  string
+		header  string
+		headers map[string]string
+		want    int
+	}{
+		{"matching token", "abc", "abc", nil, http.StatusOK},
+		{"missing token", "abc", "", nil, http.StatusForbidden},
+		{"wrong token", "abc", "xyz", nil, http.StatusForbidden},
+		{"no cookie", "", "abc", nil, http.StatusForbidden},
+		{"same origin", "abc", "abc", map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"cross origin", "abc", "abc", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"cross referer", "abc", "abc", map[string]string{"Referer": "http://evil.example/page"}, http.StatusForbidden},
+		{"bearer token", "", "", map[string]string{"Authorization": "Bearer cmp_x"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := csrfPost(tt.cookie, tt.header, tt.headers); code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, code)
+			}
+		})
+	}
+}
+
+func TestCSRFAcceptsFormField(t *testing.T) {
+	handler := CSRF(DefaultCookiePolicy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	form := url.Values{"username": {"admin"}, csrfFormField: {"abc"}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "abc"})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected form token to be accepted, got %d", rec.Code)
+	}
+}
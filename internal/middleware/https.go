@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	httpsKey    contextKey = "https"
+	clientIPKey contextKey = "clientIP"
+)
+
+// TrustedProxies are the addresses of reverse proxies whose
+// X-Forwarded-Proto and X-Forwarded-For headers are believed.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, s := range list {
+		if strings.Contains(s, "/") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q", s)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+// Contains reports whether a request from remoteAddr came through one of
+// the proxies.
+func (t TrustedProxies) Contains(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return t.containsAddr(addr)
+}
+
+func (t TrustedProxies) containsAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client a request from a trusted
+// proxy was made for: the last address in X-Forwarded-For that is not
+// itself a trusted proxy. It returns "" if the header names none.
+func (t TrustedProxies) clientIP(r *http.Request) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything before a hop that cannot be read may be forged
+			break
+		}
+		client = addr.Unmap().String()
+		if !t.containsAddr(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// HTTPS works out whether each request arrived over HTTPS, either directly
+// or through a trusted proxy that says so in X-Forwarded-Proto, so that
+// cookies set in reply are marked Secure. Responses to such requests carry
+// a Strict-Transport-Security header if hstsMaxAge is positive. For requests
+// from a trusted proxy it also takes the client's address from
+// X-Forwarded-For, for ClientIP to return.
+func HTTPS(trusted TrustedProxies, hstsMaxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			secure := r.TLS != nil
+			if trusted.Contains(r.RemoteAddr) {
+				if !secure {
+					secure = strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+				}
+				if client := trusted.clientIP(r); client != "" {
+					ctx = context.WithValue(ctx, clientIPKey, client)
+				}
+			}
+
+			if secure && hstsMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hstsMaxAge.Seconds())))
+			}
+
+			ctx = context.WithValue(ctx, httpsKey, secure)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// IsHTTPS reports whether a request arrived over HTTPS, as decided by the
+// HTTPS middleware, or failing that whether it arrived over TLS.
+func IsHTTPS(r *http.Request) bool {
+	if secure, ok := r.Context().Value(httpsKey).(bool); ok {
+		return secure
+	}
+	return r.TLS != nil
+}
+
+// RedirectToHTTPS answers every request with a permanent redirect to the
+// same URL over HTTPS on httpsPort.
+func RedirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
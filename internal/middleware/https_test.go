@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPS(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+
+	handler := HTTPS(proxies, 24*time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		DefaultCookiePolicy.Set(w, r, &http.Cookie{Name: "session", Value: "x"})
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		tls        bool
+		want       bool
+	}{
+		{"plain", "192.0.2.1:1234", "", false, false},
+		{"tls", "192.0.2.1:1234", "", true, true},
+		{"trusted proxy", "10.1.2.3:1234", "https", false, true},
+		{"trusted IPv6 proxy", "[::1]:1234", "HTTPS", false, true},
+		{"trusted proxy over http", "10.1.2.3:1234", "http", false, false},
+		{"untrusted proxy", "192.0.2.1:1234", "https", false, false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Secure != tt.want {
+			t.Errorf("%s: expected Secure cookie %v, got %v", tt.name, tt.want, cookies)
+		}
+
+		hsts := rec.Header().Get("Strict-Transport-Security")
+		if tt.want && hsts != "max-age=86400" {
+			t.Errorf("%s: unexpected HSTS header %q", tt.name, hsts)
+		}
+		if !tt.want && hsts != "" {
+			t.Errorf("%s: expected no HSTS header over HTTP, got %q", tt.name, hsts)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("Failed to parse proxies: %v", err)
+	}
+
+	var got string
+	handler := HTTPS(proxies, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:1234", []string{"198.51.100.7"}, "192.0.2.1"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without header", "10.1.2.3:1234", nil, "10.1.2.3"},
+		{"forged hops before the client", "10.1.2.3:1234", []string{"203.0.113.9, 198.51.100.7"}, "198.51.100.7"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.7, 10.9.9.9", "10.4.4.4"}, "198.51.100.7"},
+		{"unreadable hop", "10.1.2.3:1234", []string{"198.51.100.7, nonsense, 10.9.9.9"}, "10.9.9.9"},
+		{"IPv4-mapped", "10.1.2.3:1234", []string{"::ffff:198.51.100.7"}, "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.forwardedFor {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Error("Expected host names to be rejected")
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected invalid prefix to be rejected")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port   string
+		target string
+		want   string
+	}{
+		{"443", "http://example.com/outline/1?x=y", "https://example.com/outline/1?x=y"},
+		{"8443", "http://example.com:8080/login", "https://example.com:8443/login"},
+		{"8443", "http://[::1]:8080/", "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		RedirectToHTTPS(tt.port).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: expected %d, got %d", tt.target, http.StatusPermanentRedirect, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: expected redirect to %s, got %s", tt.target, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import "net/http"
+
+// MaxBodySize limits request bodies to n bytes. Reading beyond the limit
+// fails, so handlers reject the request as malformed, and requests that
+// declare a longer body up front are refused before reaching them.
+func MaxBodySize(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+)
+
+var (
+	ErrLoginThrottled = errors.New("too many failed login attempts")
+	ErrAccountLocked  = errors.New("account temporarily locked")
+)
+
+// LoginPolicy controls how repeated failed logins are slowed down and when
+// they lock an account.
+type LoginPolicy struct {
+	// FreeAttempts failures from one IP or for one username are allowed
+	// before backoff starts. After that each failure must be followed by a
+	// wait of BackoffBase, doubling with each further failure up to
+	// BackoffMax.
+	FreeAttempts int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+
+	// LockoutThreshold failures for a username, each within LockoutWindow of
+	// the last, lock it for LockoutDuration.
+	LockoutThreshold int
+	LockoutWindow    time.Duration
+	LockoutDuration  time.Duration
+
+	// AuditRetention is how long entries stay in the login audit log. Zero
+	// keeps them forever.
+	AuditRetention time.Duration
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	FreeAttempts:     3,
+	BackoffBase:      time.Second,
+	BackoffMax:       5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutWindow:    15 * time.Minute,
+	LockoutDuration:  15 * time.Minute,
+	AuditRetention:   90 * 24 * time.Hour,
+}
+
+// backoff returns how long to wait after the given number of failures.
+func (p LoginPolicy) backoff(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BackoffBase
+	for i := p.FreeAttempts + 1; i < failures && delay < p.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > p.BackoffMax {
+		delay = p.BackoffMax
+	}
+	return delay
+}
+
+// LoginLimiter guards the login form against password guessing. Failures
+// are counted in memory per client IP and per username to impose an
+// exponential backoff, and in the database per username to lock accounts
+// that keep failing. Every refused attempt is written to the login audit
+// log. It is safe for concurrent use.
+type LoginLimiter struct {
+	db     database.Store
+	policy LoginPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+
+	reaper *reaper
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func NewLoginLimiter(db database.Store, policy LoginPolicy) *LoginLimiter {
+	l := &LoginLimiter{
+		db:       db,
+		policy:   policy,
+		now:      time.Now,
+		failures: make(map[string]*loginFailures),
+	}
+	l.reaper = startReaper(reapInterval, l.reap)
+	return l
+}
+
+func ipKey(ip string) string         { return "ip:" + ip }
+func usernameKey(name string) string { return "user:" + name }
+
+// Check reports whether a login attempt for username from ip may go ahead.
+// If not, it returns ErrLoginThrottled or ErrAccountLocked along with how
+// long the client should wait before trying again.
+func (l *LoginLimiter) Check(ip, username string) (time.Duration, error) {
+	now := l.now()
+
+	lockout, err := l.db.GetLoginLockout(username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err == nil && lockout.Locked(now) {
+		if err := l.db.RecordLoginAttempt(username, ip, false, "account locked"); err != nil {
+			return 0, err
+		}
+		return lockout.LockedUntil.Sub(now), ErrAccountLocked
+	}
+
+	var wait time.Duration
+	l.mu.Lock()
+	for _, key := range []string{ipKey(ip), usernameKey(username)} {
+		if f, ok := l.failures[key]; ok {
+			if w := f.last.Add(l.policy.backoff(f.count)).Sub(now); w > wait {
+				wait = w
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		if err := l.db.RecordLoginAttempt(username, ip, false, "throttled"); err != nil {
+			return 0, err
+		}
+		return wait, ErrLoginThrottled
+	}
+	return 0, nil
+}
+
+// Failed records a login for username from ip that was refused because the
+// credentials were wrong, locking the username if it has now failed too
+// often.
+func (l *LoginLimiter) Failed(ip, username string) error {
+	now := l.now()
+
+	l.mu.Lock()
+	for _, key := range []string{ipKey(ip), usernameKey(username)} {
+		f, ok := l.failures[key]
+		if !ok || now.Sub(f.last) > l.policy.LockoutWindow {
+			f = &loginFailures{}
+			l.failures[key] = f
+		}
+		f.count++
+		f.last = now
+	}
+	l.mu.Unlock()
+
+	if err := l.db.RecordLoginAttempt(username, ip, false, "invalid credentials"); err != nil {
+		return err
+	}
+
+	failures, err := l.db.RecordLoginFailure(username, now, l.policy.LockoutWindow)
+	if err != nil {
+		return err
+	}
+	if failures >= l.policy.LockoutThreshold {
+		if err := l.db.LockLogin(username, now.Add(l.policy.LockoutDuration)); err != nil {
+			return err
+		}
+		return l.db.RecordLoginAttempt(username, ip, false, "account locked after repeated failures")
+	}
+	return nil
+}
+
+// Succeeded forgets the failures recorded for username and ip.
+func (l *LoginLimiter) Succeeded(ip, username string) error {
+	l.mu.Lock()
+	delete(l.failures, ipKey(ip))
+	delete(l.failures, usernameKey(username))
+	l.mu.Unlock()
+
+	return l.db.ClearLoginLockout(username)
+}
+
+// Unlock lifts any lockout or backoff on username. Used by admins.
+func (l *LoginLimiter) Unlock(username string) error {
+	l.mu.Lock()
+	delete(l.failures, usernameKey(username))
+	l.mu.Unlock()
+
+	return l.db.ClearLoginLockout(username)
+}
+
+// Close stops the limiter's background reaper.
+func (l *LoginLimiter) Close() error {
+	l.reaper.Stop()
+	return nil
+}
+
+// reap forgets failures that can no longer cause a backoff or lockout, and
+// audit log entries older than the policy keeps.
+func (l *LoginLimiter) reap() {
+	now := l.now()
+	horizon := l.policy.LockoutWindow
+	if l.policy.BackoffMax > horizon {
+		horizon = l.policy.BackoffMax
+	}
+
+	l.mu.Lock()
+	for key, f := range l.failures {
+		if now.Sub(f.last) > horizon {
+			delete(l.failures, key)
+		}
+	}
+	l.mu.Unlock()
+
+	l.db.DeleteStaleLoginLockouts(now.Add(-horizon))
+	if l.policy.AuditRetention > 0 {
+		l.db.DeleteOldLoginAttempts(now.Add(-l.policy.AuditRetention))
+	}
+}
+
+// ClientIP returns the IP address a request came from: the client's, as
+// forwarded by a trusted proxy and found by the HTTPS middleware, or else
+// the address of the connection.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
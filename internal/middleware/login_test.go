@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testLoginPolicy = LoginPolicy{
+	FreeAttempts:     2,
+	BackoffBase:      time.Second,
+	BackoffMax:       8 * time.Second,
+	LockoutThreshold: 5,
+	LockoutWindow:    time.Hour,
+	LockoutDuration:  time.Hour,
+	AuditRetention:   24 * time.Hour,
+}
+
+func newTestLimiter(t *testing.T, dbPath string) (*LoginLimiter, *fakeClock) {
+	t.Helper()
+	db := newTestDB(t, dbPath)
+	clock := &fakeClock{now: time.Now()}
+
+	limiter := NewLoginLimiter(db, testLoginPolicy)
+	limiter.now = clock.Now
+	t.Cleanup(func() { limiter.Close() })
+
+	return limiter, clock
+}
+
+func TestLoginPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{100, 8 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := testLoginPolicy.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	limiter, clock := newTestLimiter(t, "/tmp/test_composter_login_backoff.db")
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Check("10.0.0.1", "admin"); err != nil {
+			t.Fatalf("Attempt %d: expected to be allowed, got %v", i+1, err)
+		}
+		if err := limiter.Failed("10.0.0.1", "admin"); err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+	}
+
+	wait, err := limiter.Check("10.0.0.1", "admin")
+	if !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("Expected ErrLoginThrottled, got %v", err)
+	}
+	if wait != time.Second {
+		t.Errorf("Expected 1s wait, got %v", wait)
+	}
+
+	// The same IP is throttled for other usernames too
+	if _, err := limiter.Check("10.0.0.1", "bob"); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("Expected IP to be throttled for another user, got %v", err)
+	}
+	// And the username from other IPs
+	if _, err := limiter.Check("10.0.0.2", "admin"); !errors.Is(err, ErrLoginThrottled) {
+		t.Errorf("Expected username to be throttled from another IP, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := limiter.Check("10.0.0.1", "admin"); err != nil {
+		t.Errorf("Expected attempt after backoff to be allowed, got %v", err)
+	}
+
+	if err := limiter.Succeeded("10.0.0.1", "admin"); err != nil {
+		t.Fatalf("Failed to record success: %v", err)
+	}
+	if err := limiter.Failed("10.0.0.1", "admin"); err != nil {
+		t.Fatalf("Failed to record failure: %v", err)
+	}
+	if _, err := limiter.Check("10.0.0.1", "admin"); err != nil {
+		t.Errorf("Expected success to reset backoff, got %v", err)
+	}
+
+	audit, err := limiter.db.GetRecentLoginFailures(100)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	if len(audit) != 7 {
+		t.Errorf("Expected 7 audit entries, got %d", len(audit))
+	}
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	limiter, clock := newTestLimiter(t, "/tmp/test_composter_login_lockout.db")
+
+	// Spread the failures over many IPs and wait out the backoff each time,
+	// so that only the database lockout applies.
+	for i := 0; i < testLoginPolicy.LockoutThreshold; i++ {
+		clock.Advance(testLoginPolicy.BackoffMax)
+		ip := fmt.Sprintf("10.0.0.%d", i+1)
+		if _, err := limiter.Check(ip, "admin"); err != nil {
+			t.Fatalf("Attempt %d: expected to be allowed, got %v", i+1, err)
+		}
+		if err := limiter.Failed(ip, "admin"); err != nil {
+			t.Fatalf("Failed to record failure: %v", err)
+		}
+	}
+
+	clock.Advance(testLoginPolicy.BackoffMax)
+	wait, err := limiter.Check("10.0.0.9", "admin")
+	if !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected ErrAccountLocked, got %v", err)
+	}
+	if wait <= 0 || wait > testLoginPolicy.LockoutDuration {
+		t.Errorf("Unexpected lockout wait %v", wait)
+	}
+
+	lockouts, err := limiter.db.GetLockedLogins(clock.Now())
+	if err != nil {
+		t.Fatalf("Failed to list lockouts: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Username != "admin" {
+		t.Errorf("Expected admin to be listed as locked, got %v", lockouts)
+	}
+
+	// Lockouts expire on their own
+	clock.Advance(testLoginPolicy.LockoutDuration)
+	if _, err := limiter.Check("10.0.0.9", "admin"); err != nil {
+		t.Errorf("Expected lockout to have expired, got %v", err)
+	}
+
+	// And can be lifted by an admin
+	clock.Advance(time.Hour + time.Minute)
+	for i := 0; i < testLoginPolicy.LockoutThreshold; i++ {
+		limiter.Failed("10.0.0.1", "admin")
+	}
+	if _, err := limiter.Check("10.0.0.9", "admin"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Expected ErrAccountLocked, got %v", err)
+	}
+	if err := limiter.Unlock("admin"); err != nil {
+		t.Fatalf("Failed to unlock: %v", err)
+	}
+	if _, err := limiter.Check("10.0.0.9", "admin"); err != nil {
+		t.Errorf("Expected unlocked account to be allowed, got %v", err)
+	}
+}
+
+func TestLoginLimiterAuditRetention(t *testing.T) {
+	limiter, clock := newTestLimiter(t, "/tmp/test_composter_login_retention.db")
+
+	if err := limiter.Failed("10.0.0.1", "admin"); err != nil {
+		t.Fatalf("Failed to record failure: %v", err)
+	}
+	limiter.reap()
+	if attempts, _ := limiter.db.GetRecentLoginFailures(10); len(attempts) != 1 {
+		t.Fatalf("Expected the failure to be kept, got %v", attempts)
+	}
+
+	clock.Advance(testLoginPolicy.AuditRetention + time.Minute)
+	limiter.reap()
+	if attempts, _ := limiter.db.GetRecentLoginFailures(10); len(attempts) != 0 {
+		t.Errorf("Expected the failure to be pruned after the retention period, got %v", attempts)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordChangePath is the page users who must change their password are
+// sent to.
+const PasswordChangePath = "/password"
+
+// PasswordPolicy is the set of rules a password chosen by a user must meet.
+type PasswordPolicy struct {
+	MinLength int
+
+	// MinClasses is how many of the character classes lower case, upper
+	// case, digits and symbols the password must draw from.
+	MinClasses int
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  10,
+	MinClasses: 2,
+}
+
+// Check returns an error describing why password is not acceptable for the
+// named user, or nil if it is.
+func (p PasswordPolicy) Check(username, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters long", p.MinLength)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("Password must mix at least %d of lower case, upper case, digits and symbols", p.MinClasses)
+	}
+
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("Password must not contain the username")
+	}
+	return nil
+}
+
+// Describe summarises the policy for display next to a password form.
+func (p PasswordPolicy) Describe() string {
+	s := fmt.Sprintf("At least %d characters", p.MinLength)
+	if p.MinClasses > 1 {
+		s += fmt.Sprintf(", mixing at least %d of lower case, upper case, digits and symbols", p.MinClasses)
+	}
+	return s + ", not containing your username."
+}
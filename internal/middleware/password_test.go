@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, MinClasses: 2}
+
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"short1", false},
+		{"alllowercaseletters", false},
+		{"lowercase and spaces", true},
+		{"correcthorse42", true},
+		{"Alice-is-great", false}, // contains the username
+	}
+
+	for _, tt := range tests {
+		err := policy.Check("alice", tt.password)
+		if (err == nil) != tt.ok {
+			t.Errorf("Check(%q): got error %v, want ok = %v", tt.password, err, tt.ok)
+		}
+	}
+}
+
+func TestPasswordChangeRequired(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_password_change.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	if err := db.CreateUser("bob", "secret", false, false); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bob, err := db.GetUser("bob")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if err := db.SetMustChangePassword(bob.ID, true); err != nil {
+		t.Fatalf("Failed to set MustChangePassword: %v", err)
+	}
+	store.Set("bob-session", bob.ID)
+
+	handler := AuthRequired(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "bob-session"})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != PasswordChangePath {
+		t.Errorf("Expected redirect to %s, got %d %s", PasswordChangePath, rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, path := range []string{PasswordChangePath, "/logout"} {
+		if rec := get(path); rec.Code != http.StatusOK {
+			t.Errorf("Expected %s to be reachable, got %d", path, rec.Code)
+		}
+	}
+
+	// Once changed, the user is let through
+	if err := db.ChangePassword(bob.ID, "a better password 1"); err != nil {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+	auth.UserChanged(bob.ID)
+
+	if rec := get("/"); rec.Code != http.StatusOK {
+		t.Errorf("Expected request to be allowed after password change, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+)
+
+const (
+	// renewInterval limits how often a session's idle expiry is pushed back,
+	// so that busy sessions do not write to the store on every request.
+	renewInterval = time.Minute
+
+	// reapInterval is how often expired sessions are purged.
+	reapInterval = 10 * time.Minute
+)
+
+// SessionTimeouts control how long a session lasts. A session ends once it
+// has gone unused for Idle, and once Absolute has passed since login no
+// matter how active it is.
+type SessionTimeouts struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+var DefaultSessionTimeouts = SessionTimeouts{
+	Idle:     24 * time.Hour,
+	Absolute: 7 * 24 * time.Hour,
+}
+
+// newSession starts a session for userID at time now.
+func (t SessionTimeouts) newSession(userID int, now time.Time) *database.Session {
+	return &database.Session{
+		UserID:            userID,
+		IdleExpiresAt:     now.Add(t.Idle),
+		AbsoluteExpiresAt: now.Add(t.Absolute),
+	}
+}
+
+// renewal returns the new idle expiry for a session used at time now, and
+// whether it is worth recording.
+func (t SessionTimeouts) renewal(session *database.Session, now time.Time) (time.Time, bool) {
+	idle := now.Add(t.Idle)
+	if idle.After(session.AbsoluteExpiresAt) {
+		idle = session.AbsoluteExpiresAt
+	}
+	return idle, idle.Sub(session.IdleExpiresAt) >= renewInterval
+}
+
+// SessionStore maps the session ID held in a browser's cookie to the ID of
+// the user who logged in. Stores hold no other user data, so changes to a
+// user take effect on their next request. Each Get renews the session's idle
+// timeout. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	Set(sessionID string, userID int) error
+	Get(sessionID string) (userID int, ok bool)
+	Delete(sessionID string) error
+
+	// DeleteUser ends every session belonging to userID.
+	DeleteUser(userID int) error
+
+	// DeleteUserExcept ends every session belonging to userID other than
+	// sessionID.
+	DeleteUserExcept(userID int, sessionID string) error
+
+	// Close stops the store's background reaper.
+	Close() error
+}
+
+// NewStore returns the session store selected by kind: "memory" keeps
+// sessions in process and loses them on restart, "database" (the default)
+// persists them in db. "sqlite" is an older name for "database".
+func NewStore(kind string, db database.Store, timeouts SessionTimeouts) (SessionStore, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStore(timeouts), nil
+	case "", "database", "sqlite":
+		return NewDatabaseStore(db, timeouts), nil
+	}
+	return nil, fmt.Errorf("unknown session store %q", kind)
+}
+
+// reaper runs a purge function in the background at a fixed interval.
+type reaper struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func startReaper(interval time.Duration, purge func()) *reaper {
+	r := &reaper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				purge()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// Stop stops the reaper, waiting for a purge in progress to finish so that
+// nothing touches the database once it is closed.
+func (r *reaper) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+}
+
+// MemoryStore is a SessionStore held in process memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*database.Session
+	timeouts SessionTimeouts
+	now      func() time.Time
+	reaper   *reaper
+}
+
+func NewMemoryStore(timeouts SessionTimeouts) *MemoryStore {
+	s := &MemoryStore{
+		sessions: make(map[string]*database.Session),
+		timeouts: timeouts,
+		now:      time.Now,
+	}
+	s.reaper = startReaper(reapInterval, s.reap)
+	return s
+}
+
+func (s *MemoryStore) Set(sessionID string, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[sessionID] = s.timeouts.newSession(userID, s.now())
+	return nil
+}
+
+func (s *MemoryStore) Get(sessionID string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return 0, false
+	}
+
+	now := s.now()
+	if session.Expired(now) {
+		delete(s.sessions, sessionID)
+		return 0, false
+	}
+
+	session.IdleExpiresAt, _ = s.timeouts.renewal(session, now)
+	return session.UserID, true
+}
+
+func (s *MemoryStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserExcept(userID int, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != sessionID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.reaper.Stop()
+	return nil
+}
+
+// reap removes expired sessions.
+func (s *MemoryStore) reap() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, session := range s.sessions {
+		if session.Expired(now) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// DatabaseStore is a SessionStore persisted in the sessions table, so
+// sessions survive restarts and are shared by every server using the
+// database.
+type DatabaseStore struct {
+	db       database.Store
+	timeouts SessionTimeouts
+	now      func() time.Time
+	reaper   *reaper
+}
+
+func NewDatabaseStore(db database.Store, timeouts SessionTimeouts) *DatabaseStore {
+	s := &DatabaseStore{
+		db:       db,
+		timeouts: timeouts,
+		now:      time.Now,
+	}
+	s.reaper = startReaper(reapInterval, s.reap)
+	return s
+}
+
+func (s *DatabaseStore) Set(sessionID string, userID int) error {
+	return s.db.CreateSession(sessionID, s.timeouts.newSession(userID, s.now()))
+}
+
+func (s *DatabaseStore) Get(sessionID string) (int, bool) {
+	session, err := s.db.GetSession(sessionID)
+	if err != nil {
+		return 0, false
+	}
+
+	now := s.now()
+	if session.Expired(now) {
+		s.db.DeleteSession(sessionID)
+		return 0, false
+	}
+
+	if idle, ok := s.timeouts.renewal(session, now); ok {
+		s.db.RenewSession(sessionID, idle)
+	}
+	return session.UserID, true
+}
+
+func (s *DatabaseStore) Delete(sessionID string) error {
+	return s.db.DeleteSession(sessionID)
+}
+
+func (s *DatabaseStore) DeleteUser(userID int) error {
+	return s.db.DeleteUserSessions(userID)
+}
+
+func (s *DatabaseStore) DeleteUserExcept(userID int, sessionID string) error {
+	return s.db.DeleteUserSessionsExcept(userID, sessionID)
+}
+
+func (s *DatabaseStore) Close() error {
+	s.reaper.Stop()
+	return nil
+}
+
+// reap removes expired sessions.
+func (s *DatabaseStore) reap() {
+	s.db.DeleteExpiredSessions(s.now())
+}
@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+)
+
+// fakeClock is a settable time source for exercising expiry.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var testTimeouts = SessionTimeouts{Idle: time.Hour, Absolute: 24 * time.Hour}
+
+func newTestDB(t *testing.T, dbPath string) *database.DB {
+	t.Helper()
+	os.Remove(dbPath)
+	t.Cleanup(func() { os.Remove(dbPath) })
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Otherwise the seeded admin could only reach the password change page
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+	if err := db.SetMustChangePassword(admin.ID, false); err != nil {
+		t.Fatalf("Failed to clear MustChangePassword: %v", err)
+	}
+	return db
+}
+
+// testStores returns one store of each kind sharing clock, along with the
+// admin user from the backing database.
+func testStores(t *testing.T, clock *fakeClock, timeouts SessionTimeouts) (map[string]SessionStore, *database.User) {
+	t.Helper()
+	db := newTestDB(t, "/tmp/test_composter_sessions.db")
+
+	admin, err := db.GetUser("admin")
+	if err != nil {
+		t.Fatalf("Failed to get admin user: %v", err)
+	}
+
+	memory := NewMemoryStore(timeouts)
+	memory.now = clock.Now
+	persistent := NewDatabaseStore(db, timeouts)
+	persistent.now = clock.Now
+
+	t.Cleanup(func() {
+		memory.Close()
+		persistent.Close()
+	})
+
+	return map[string]SessionStore{"memory": memory, "database": persistent}, admin
+}
+
+func TestSessionStoreSetGetDelete(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	for name, store := range stores {
+		if err := store.Set("abc", admin.ID); err != nil {
+			t.Fatalf("%s: failed to set session: %v", name, err)
+		}
+
+		userID, ok := store.Get("abc")
+		if !ok {
+			t.Fatalf("%s: expected session to exist", name)
+		}
+		if userID != admin.ID {
+			t.Errorf("%s: expected user %d, got %d", name, admin.ID, userID)
+		}
+
+		if _, ok := store.Get("missing"); ok {
+			t.Errorf("%s: expected unknown session to be rejected", name)
+		}
+
+		if err := store.Delete("abc"); err != nil {
+			t.Fatalf("%s: failed to delete session: %v", name, err)
+		}
+		if _, ok := store.Get("abc"); ok {
+			t.Errorf("%s: expected deleted session to be rejected", name)
+		}
+	}
+}
+
+func TestSessionStoreSlidingExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	for name, store := range stores {
+		if err := store.Set("active", admin.ID); err != nil {
+			t.Fatalf("%s: failed to set session: %v", name, err)
+		}
+		if err := store.Set("idle", admin.ID); err != nil {
+			t.Fatalf("%s: failed to set session: %v", name, err)
+		}
+	}
+
+	// Keep one session in use past its original expiry
+	for i := 0; i < 3; i++ {
+		clock.Advance(40 * time.Minute)
+		for name, store := range stores {
+			if _, ok := store.Get("active"); !ok {
+				t.Fatalf("%s: expected active session to be renewed (step %d)", name, i)
+			}
+		}
+	}
+
+	for name, store := range stores {
+		if _, ok := store.Get("idle"); ok {
+			t.Errorf("%s: expected idle session to expire", name)
+		}
+	}
+
+	clock.Advance(2 * time.Hour)
+	for name, store := range stores {
+		if _, ok := store.Get("active"); ok {
+			t.Errorf("%s: expected session to expire once unused", name)
+		}
+	}
+}
+
+func TestSessionStoreAbsoluteExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, SessionTimeouts{Idle: time.Hour, Absolute: 90 * time.Minute})
+
+	for name, store := range stores {
+		if err := store.Set("abc", admin.ID); err != nil {
+			t.Fatalf("%s: failed to set session: %v", name, err)
+		}
+	}
+
+	clock.Advance(50 * time.Minute)
+	for name, store := range stores {
+		if _, ok := store.Get("abc"); !ok {
+			t.Fatalf("%s: expected session to be valid", name)
+		}
+	}
+
+	// Still within the renewed idle timeout, but past the absolute one
+	clock.Advance(50 * time.Minute)
+	for name, store := range stores {
+		if _, ok := store.Get("abc"); ok {
+			t.Errorf("%s: expected session to end at its absolute timeout", name)
+		}
+	}
+}
+
+func TestSessionStoreDeleteUser(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	for name, store := range stores {
+		store.Set("mine-1", admin.ID)
+		store.Set("mine-2", admin.ID)
+		store.Set("theirs", admin.ID+1)
+
+		if err := store.DeleteUser(admin.ID); err != nil {
+			t.Fatalf("%s: failed to delete user sessions: %v", name, err)
+		}
+
+		if _, ok := store.Get("mine-1"); ok {
+			t.Errorf("%s: expected user's sessions to be deleted", name)
+		}
+		if _, ok := store.Get("mine-2"); ok {
+			t.Errorf("%s: expected user's sessions to be deleted", name)
+		}
+		if _, ok := store.Get("theirs"); !ok {
+			t.Errorf("%s: expected other users' sessions to remain", name)
+		}
+	}
+}
+
+func TestSessionStoreDeleteUserExcept(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	for name, store := range stores {
+		store.Set("current", admin.ID)
+		store.Set("other", admin.ID)
+		store.Set("theirs", admin.ID+1)
+
+		if err := store.DeleteUserExcept(admin.ID, "current"); err != nil {
+			t.Fatalf("%s: failed to delete other sessions: %v", name, err)
+		}
+
+		if _, ok := store.Get("current"); !ok {
+			t.Errorf("%s: expected the kept session to remain", name)
+		}
+		if _, ok := store.Get("other"); ok {
+			t.Errorf("%s: expected the user's other session to be deleted", name)
+		}
+		if _, ok := store.Get("theirs"); !ok {
+			t.Errorf("%s: expected other users' sessions to remain", name)
+		}
+	}
+}
+
+func TestSessionStoreReaper(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	memory := stores["memory"].(*MemoryStore)
+	persistent := stores["database"].(*DatabaseStore)
+
+	for _, store := range stores {
+		store.Set("old", admin.ID)
+	}
+	clock.Advance(2 * time.Hour)
+	for _, store := range stores {
+		store.Set("new", admin.ID)
+	}
+
+	memory.reap()
+	persistent.reap()
+
+	if len(memory.sessions) != 1 {
+		t.Errorf("memory: expected 1 session after reaping, got %d", len(memory.sessions))
+	}
+
+	if _, err := persistent.db.GetSession("old"); err == nil {
+		t.Error("database: expected old session to be reaped")
+	}
+	if _, err := persistent.db.GetSession("new"); err != nil {
+		t.Errorf("database: expected new session to survive reaping, got %v", err)
+	}
+}
+
+// TestSessionStoreConcurrency is meant to be run with -race.
+func TestSessionStoreConcurrency(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	stores, admin := testStores(t, clock, testTimeouts)
+
+	for name, store := range stores {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := fmt.Sprintf("session-%d", i)
+				for j := 0; j < 10; j++ {
+					if err := store.Set(id, admin.ID); err != nil {
+						t.Errorf("%s: failed to set session: %v", name, err)
+						return
+					}
+					if _, ok := store.Get(id); !ok {
+						t.Errorf("%s: expected session %s to exist", name, id)
+					}
+					if err := store.Delete(id); err != nil {
+						t.Errorf("%s: failed to delete session: %v", name, err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_new_store.db")
+
+	for kind, expected := range map[string]string{
+		"":         "*middleware.DatabaseStore",
+		"database": "*middleware.DatabaseStore",
+		"sqlite":   "*middleware.DatabaseStore",
+		"memory":   "*middleware.MemoryStore",
+	} {
+		store, err := NewStore(kind, db, testTimeouts)
+		if err != nil {
+			t.Fatalf("NewStore(%q): %v", kind, err)
+		}
+		if got := fmt.Sprintf("%T", store); got != expected {
+			t.Errorf("NewStore(%q): expected %s, got %s", kind, expected, got)
+		}
+		store.Close()
+	}
+
+	if _, err := NewStore("redis", db, testTimeouts); err == nil {
+		t.Error("Expected error for unknown session store")
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kristofer/composter/internal/database"
+)
+
+const (
+	// TwoFactorPath is the page where users enroll in two-factor
+	// authentication, and TwoFactorAPIPrefix the endpoints it calls.
+	TwoFactorPath      = "/2fa"
+	TwoFactorAPIPrefix = "/api/2fa/"
+
+	// pendingLoginTTL is how long a user has to enter their one-time code
+	// after giving their password.
+	pendingLoginTTL = 5 * time.Minute
+
+	// pendingLoginAttempts is how many wrong codes a pending login survives.
+	pendingLoginAttempts = 5
+)
+
+// TwoFactorRequirement says which users must enroll in two-factor
+// authentication. It is stored in the database.SettingRequireTwoFactor
+// setting.
+type TwoFactorRequirement string
+
+const (
+	TwoFactorOptional TwoFactorRequirement = ""
+	TwoFactorAdmins   TwoFactorRequirement = "admins"
+	TwoFactorAll      TwoFactorRequirement = "all"
+)
+
+// ParseTwoFactorRequirement checks a stored or submitted requirement.
+func ParseTwoFactorRequirement(s string) (TwoFactorRequirement, error) {
+	switch req := TwoFactorRequirement(s); req {
+	case TwoFactorOptional, TwoFactorAdmins, TwoFactorAll:
+		return req, nil
+	}
+	return "", fmt.Errorf("unknown two-factor requirement %q", s)
+}
+
+// Applies reports whether user must enroll.
+func (req TwoFactorRequirement) Applies(user *database.User) bool {
+	switch req {
+	case TwoFactorAll:
+		return true
+	case TwoFactorAdmins:
+		return user.IsAdmin
+	}
+	return false
+}
+
+// PendingLogins holds logins that have passed the password check and are
+// waiting for a one-time code. Each is identified by a random ID kept in a
+// short-lived cookie. It is safe for concurrent use.
+type PendingLogins struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]*pendingLogin
+}
+
+type pendingLogin struct {
+	userID    int
+	expiresAt time.Time
+	failures  int
+}
+
+func NewPendingLogins(ttl time.Duration) *PendingLogins {
+	return &PendingLogins{
+		ttl:     ttl,
+		now:     time.Now,
+		pending: make(map[string]*pendingLogin),
+	}
+}
+
+// Start records that userID has given the right password and returns the ID
+// of the pending login.
+func (p *PendingLogins) Start(userID int) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for other, login := range p.pending {
+		if !now.Before(login.expiresAt) {
+			delete(p.pending, other)
+		}
+	}
+	p.pending[id] = &pendingLogin{userID: userID, expiresAt: now.Add(p.ttl)}
+	return id, nil
+}
+
+// Get returns the user behind a pending login that has not expired.
+func (p *PendingLogins) Get(id string) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	login, ok := p.pending[id]
+	if !ok {
+		return 0, false
+	}
+	if !p.now().Before(login.expiresAt) {
+		delete(p.pending, id)
+		return 0, false
+	}
+	return login.userID, true
+}
+
+// Fail records a wrong code, abandoning the login once too many have been
+// tried so that the password must be given again.
+func (p *PendingLogins) Fail(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if login, ok := p.pending[id]; ok {
+		login.failures++
+		if login.failures >= pendingLoginAttempts {
+			delete(p.pending, id)
+		}
+	}
+}
+
+// Finish removes a pending login once it has completed.
+func (p *PendingLogins) Finish(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, id)
+}
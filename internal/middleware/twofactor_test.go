@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPendingLogins(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	pending := NewPendingLogins(time.Minute)
+	pending.now = clock.Now
+
+	id, err := pending.Start(7)
+	if err != nil {
+		t.Fatalf("Failed to start login: %v", err)
+	}
+	if userID, ok := pending.Get(id); !ok || userID != 7 {
+		t.Fatalf("Expected pending login for user 7, got %d, %v", userID, ok)
+	}
+
+	// Too many wrong codes abandon the login
+	for i := 0; i < pendingLoginAttempts-1; i++ {
+		pending.Fail(id)
+	}
+	if _, ok := pending.Get(id); !ok {
+		t.Fatal("Expected pending login to survive a few wrong codes")
+	}
+	pending.Fail(id)
+	if _, ok := pending.Get(id); ok {
+		t.Error("Expected pending login to be abandoned")
+	}
+
+	// Pending logins expire
+	id, err = pending.Start(7)
+	if err != nil {
+		t.Fatalf("Failed to start login: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := pending.Get(id); ok {
+		t.Error("Expected pending login to expire")
+	}
+
+	id, err = pending.Start(7)
+	if err != nil {
+		t.Fatalf("Failed to start login: %v", err)
+	}
+	pending.Finish(id)
+	if _, ok := pending.Get(id); ok {
+		t.Error("Expected finished login to be removed")
+	}
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	db := newTestDB(t, "/tmp/test_composter_twofactor_required.db")
+	store := NewMemoryStore(testTimeouts)
+	defer store.Close()
+	auth := NewAuthenticator(db, store, testTimeouts)
+
+	if err := db.CreateUser("bob", "secret", false, false); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	bob, err := db.GetUser("bob")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	store.Set("bob-session", bob.ID)
+
+	handler := AuthRequired(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "bob-session"})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Requiring it of admins leaves other users alone
+	auth.SetTwoFactorRequirement(TwoFactorAdmins)
+	if rec := get("/"); rec.Code != http.StatusOK {
+		t.Errorf("Expected non-admin to be let through, got %d", rec.Code)
+	}
+
+	auth.SetTwoFactorRequirement(TwoFactorAll)
+	rec := get("/")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != TwoFactorPath {
+		t.Errorf("Expected redirect to %s, got %d %s", TwoFactorPath, rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, path := range []string{TwoFactorPath, TwoFactorAPIPrefix + "setup", "/logout"} {
+		if rec := get(path); rec.Code != http.StatusOK {
+			t.Errorf("Expected %s to be reachable, got %d", path, rec.Code)
+		}
+	}
+
+	// Once enrolled, the user is let through
+	if err := db.SetTOTPSecret(bob.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if err := db.EnableTOTP(bob.ID, 1); err != nil {
+		t.Fatalf("Failed to enable TOTP: %v", err)
+	}
+	auth.UserChanged(bob.ID)
+
+	if rec := get("/"); rec.Code != http.StatusOK {
+		t.Errorf("Expected request to be allowed after enrolling, got %d", rec.Code)
+	}
+}
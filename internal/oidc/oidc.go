@@ -0,0 +1,317 @@
+// Package oidc implements the OpenID Connect authorization code flow for
+// logging in through an external identity provider. It uses discovery to
+// find the provider's endpoints, PKCE to protect the code exchange, and
+// checks ID tokens against the provider's published RSA keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// flowTTL is how long a user has to log in at the provider and come
+	// back.
+	flowTTL = 10 * time.Minute
+
+	// keyRefreshInterval limits how often an unknown key ID makes us fetch
+	// the provider's keys again.
+	keyRefreshInterval = time.Minute
+
+	// clockSkew is how far the provider's clock may be from ours.
+	clockSkew = time.Minute
+)
+
+var (
+	// ErrUnknownState is returned by Finish for a state that was never
+	// issued, has been used or has expired.
+	ErrUnknownState = errors.New("oidc: unknown or expired login state")
+
+	// ErrInvalidToken is returned when an ID token fails verification.
+	ErrInvalidToken = errors.New("oidc: invalid ID token")
+)
+
+// Config describes a provider and how its claims map to Composter users.
+type Config struct {
+	// Issuer is the provider's issuer URL. Discovery fetches
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the callback URL registered with the provider.
+	RedirectURL string
+
+	// Scopes requested in addition to "openid". Defaults to profile and
+	// email.
+	Scopes []string
+
+	// UsernameClaim names the claim matched against usernames. Defaults to
+	// "preferred_username".
+	UsernameClaim string
+
+	// GroupsClaim names the claim listing the user's groups. Defaults to
+	// "groups".
+	GroupsClaim string
+
+	// AdminGroups lists groups whose members are admins. If empty, admin
+	// rights are managed in Composter instead.
+	AdminGroups []string
+
+	// AutoProvision creates users the first time they log in, under a
+	// username nobody has yet. Otherwise only users already linked to an
+	// account at the provider may log in this way.
+	AutoProvision bool
+
+	// LinkExisting links an account logging in for the first time to the
+	// user with the same username, if that user is not an admin and has no
+	// password. An admin creates such a user to let someone in through the
+	// provider. Usernames are often chosen at the provider, so users with a
+	// password are never linked.
+	LinkExisting bool
+
+	// HTTPClient is used to talk to the provider. Defaults to a client with
+	// a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// Claims are the parts of a verified ID token Composter uses.
+type Claims struct {
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// Provider is a configured identity provider. It is safe for concurrent
+// use.
+type Provider struct {
+	config Config
+	now    func() time.Time
+
+	authURL  string
+	tokenURL string
+	jwksURL  string
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	flows       map[string]*flow
+}
+
+// flow is a login that has been sent to the provider.
+type flow struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// discovery is the part of the provider's configuration document we use.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider fetches the provider's configuration and keys.
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+	if config.Scopes == nil {
+		config.Scopes = []string{"profile", "email"}
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	p := &Provider{
+		config: config,
+		now:    time.Now,
+		flows:  make(map[string]*flow),
+	}
+
+	var doc discovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if doc.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", doc.Issuer, config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: missing endpoints")
+	}
+	p.authURL, p.tokenURL, p.jwksURL = doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.JWKSURI
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Config returns the provider's configuration, with defaults filled in.
+func (p *Provider) Config() Config {
+	return p.config
+}
+
+// Begin starts a login, returning the state that identifies it and the URL
+// to send the user to. The state should also be kept in a cookie and
+// checked against the callback, so that a login started in one browser
+// cannot be finished in another.
+func (p *Provider) Begin() (state, authURL string, err error) {
+	state, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	now := p.now()
+	for other, f := range p.flows {
+		if !now.Before(f.expiresAt) {
+			delete(p.flows, other)
+		}
+	}
+	p.flows[state] = &flow{nonce: nonce, verifier: verifier, expiresAt: now.Add(flowTTL)}
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return state, p.authURL + sep + q.Encode(), nil
+}
+
+// Finish completes the login identified by state, exchanging the code the
+// provider sent back for a verified ID token.
+func (p *Provider) Finish(ctx context.Context, state, code string) (*Claims, error) {
+	p.mu.Lock()
+	f, ok := p.flows[state]
+	delete(p.flows, state)
+	p.mu.Unlock()
+
+	if !ok || !p.now().Before(f.expiresAt) {
+		return nil, ErrUnknownState
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {f.verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc: token exchange: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc: token exchange: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token exchange: no ID token")
+	}
+
+	return p.Verify(ctx, token.IDToken, f.nonce)
+}
+
+// IsAdmin reports whether claims put the user in one of the admin groups.
+// managed is false if no admin groups are configured, in which case admin
+// rights are left alone.
+func (p *Provider) IsAdmin(claims *Claims) (admin, managed bool) {
+	if len(p.config.AdminGroups) == 0 {
+		return false, false
+	}
+	for _, group := range claims.Groups {
+		for _, adminGroup := range p.config.AdminGroups {
+			if group == adminGroup {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// randomString returns a random value suitable for a state, nonce or PKCE
+// verifier.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,209 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kristofer/composter/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T, config Config) (*Provider, *oidctest.Provider) {
+	t.Helper()
+	fake := oidctest.NewProvider("composter", "s3cret")
+	t.Cleanup(fake.Close)
+
+	config.Issuer = fake.Issuer()
+	config.ClientID = "composter"
+	config.ClientSecret = "s3cret"
+	config.RedirectURL = "http://composter.test/login/sso/callback"
+
+	p, err := NewProvider(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return p, fake
+}
+
+func TestLogin(t *testing.T) {
+	p, fake := newTestProvider(t, Config{AdminGroups: []string{"composter-admins"}})
+	fake.Claims["sub"] = "user-1"
+	fake.Claims["preferred_username"] = "alice"
+	fake.Claims["email"] = "alice@example.com"
+	fake.Claims["groups"] = []string{"staff", "composter-admins"}
+
+	state, authURL, err := p.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Bad auth URL: %v", err)
+	}
+	if got := u.Query().Get("scope"); got != "openid profile email" {
+		t.Errorf("Unexpected scope %q", got)
+	}
+
+	code, returnedState, err := fake.Approve(authURL)
+	if err != nil {
+		t.Fatalf("Failed to approve login: %v", err)
+	}
+	if returnedState != state {
+		t.Fatalf("Expected state %q, got %q", state, returnedState)
+	}
+
+	claims, err := p.Finish(context.Background(), state, code)
+	if err != nil {
+		t.Fatalf("Failed to finish login: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Username != "alice" || claims.Email != "alice@example.com" {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+	if admin, managed := p.IsAdmin(claims); !admin || !managed {
+		t.Errorf("Expected admin group to grant admin, got %v, %v", admin, managed)
+	}
+
+	// Each state can be used once
+	if _, err := p.Finish(context.Background(), state, code); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Expected ErrUnknownState, got %v", err)
+	}
+}
+
+func TestLoginExpires(t *testing.T) {
+	p, fake := newTestProvider(t, Config{})
+
+	state, authURL, err := p.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	code, _, err := fake.Approve(authURL)
+	if err != nil {
+		t.Fatalf("Failed to approve login: %v", err)
+	}
+
+	later := time.Now().Add(flowTTL)
+	p.now = func() time.Time { return later }
+	if _, err := p.Finish(context.Background(), state, code); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Expected ErrUnknownState, got %v", err)
+	}
+}
+
+func TestLoginRejectsWrongCode(t *testing.T) {
+	p, fake := newTestProvider(t, Config{})
+
+	state, authURL, err := p.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin login: %v", err)
+	}
+	if _, _, err := fake.Approve(authURL); err != nil {
+		t.Fatalf("Failed to approve login: %v", err)
+	}
+
+	if _, err := p.Finish(context.Background(), state, "not-a-code"); err == nil {
+		t.Error("Expected token exchange to fail")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, fake := newTestProvider(t, Config{})
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   fake.Issuer(),
+			"sub":   "user-1",
+			"aud":   "composter",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "n",
+		}
+	}
+
+	if _, err := p.Verify(context.Background(), fake.Sign(valid()), "n"); err != nil {
+		t.Fatalf("Expected valid token to verify, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(map[string]interface{})
+	}{
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other" }},
+		{"other authorized party", func(c map[string]interface{}) {
+			c["aud"] = []string{"composter", "other"}
+			c["azp"] = "other"
+		}},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{"wrong nonce", func(c map[string]interface{}) { c["nonce"] = "other" }},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		claims := valid()
+		tt.change(claims)
+		if _, err := p.Verify(context.Background(), fake.Sign(claims), "n"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", tt.name, err)
+		}
+	}
+
+	// A token signed with a key the provider does not publish
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p.mu.Lock()
+	var kid string
+	for k := range p.keys {
+		kid = k
+	}
+	p.mu.Unlock()
+	if _, err := p.Verify(context.Background(), oidctest.SignWith(other, kid, valid()), "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected forged token to be rejected, got %v", err)
+	}
+
+	// Tampered payload
+	token := fake.Sign(valid())
+	if _, err := p.Verify(context.Background(), token[:len(token)-4]+"AAAA", "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected tampered token to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyAfterKeyRotation(t *testing.T) {
+	p, fake := newTestProvider(t, Config{})
+	fake.RotateKey()
+
+	claims := map[string]interface{}{
+		"iss":   fake.Issuer(),
+		"sub":   "user-1",
+		"aud":   "composter",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "n",
+	}
+
+	// Keys were fetched moments ago, so the new one is not looked up yet
+	if _, err := p.Verify(context.Background(), fake.Sign(claims), "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected unknown key to be rejected, got %v", err)
+	}
+
+	later := time.Now().Add(keyRefreshInterval)
+	p.now = func() time.Time { return later }
+	if _, err := p.Verify(context.Background(), fake.Sign(claims), "n"); err != nil {
+		t.Errorf("Expected rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	p, _ := newTestProvider(t, Config{})
+	if _, managed := p.IsAdmin(&Claims{Groups: []string{"admins"}}); managed {
+		t.Error("Expected admin rights to be unmanaged without admin groups")
+	}
+
+	p, _ = newTestProvider(t, Config{AdminGroups: []string{"admins"}})
+	if admin, managed := p.IsAdmin(&Claims{Groups: []string{"staff"}}); admin || !managed {
+		t.Errorf("Expected non-member to lose admin, got %v, %v", admin, managed)
+	}
+}
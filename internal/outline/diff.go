@@ -0,0 +1,254 @@
+package outline
+
+import "strings"
+
+// ChangeKind says how a node differs between two versions of an outline.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Moved   ChangeKind = "moved"
+)
+
+// Change is one difference between two versions of an outline.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	Text string     `json:"text"`
+	// Path is the text of the node's ancestors, outermost first, in the
+	// version the node appears in: the newer one unless it was removed.
+	Path []string `json:"path"`
+	// From is where a moved node was in the older version. It equals Path
+	// if the node was only reordered among its siblings.
+	From []string `json:"from,omitempty"`
+}
+
+// diffNode is a node together with what Diff needs to know about its place
+// in the tree.
+type diffNode struct {
+	*Node
+	parent   *diffNode
+	children []*diffNode
+	siblings []*diffNode // including itself
+	index    int         // position among its siblings
+	path     []string
+}
+
+// offset is how much deeper the node is indented than its parent.
+func (n *diffNode) offset() int {
+	if n.parent == nil {
+		return n.Depth
+	}
+	return n.Depth - n.parent.Depth
+}
+
+func diffNodes(d *Document) []*diffNode {
+	var nodes []*diffNode
+	var add func(children []*Node, parent *diffNode, path []string) []*diffNode
+	add = func(children []*Node, parent *diffNode, path []string) []*diffNode {
+		var siblings []*diffNode
+		for i, n := range children {
+			dn := &diffNode{Node: n, parent: parent, index: i, path: path}
+			nodes = append(nodes, dn)
+			siblings = append(siblings, dn)
+			dn.children = add(n.Children, dn, append(path[:len(path):len(path)], n.Text))
+		}
+		for _, dn := range siblings {
+			dn.siblings = siblings
+		}
+		return siblings
+	}
+	add(d.Roots, nil, []string{})
+	return nodes
+}
+
+// pairs maps nodes of one version of an outline to the same nodes in
+// another, in both directions.
+type pairs map[*diffNode]*diffNode
+
+// match pairs the nodes of two versions by text, preferring nodes that kept
+// their ID: those have the same text under the same ancestors. A node's ID
+// changes with its text, and so do the IDs of everything under it, so a node
+// with children that kept no ID is then paired with a node in its place in
+// the other version, under the same parent, that has a child with the same
+// text. Renaming a heading thus leaves what is under it where it was. The
+// rest are paired by text in document order.
+func match(before, after []*diffNode) pairs {
+	m := make(pairs)
+
+	byID := make(map[string]*diffNode)
+	for _, n := range before {
+		byID[n.ID] = n
+	}
+	for _, n := range after {
+		if o := byID[n.ID]; o != nil {
+			m[o], m[n] = n, o
+		}
+	}
+
+	var roots []*diffNode
+	if len(before) > 0 {
+		roots = before[0].siblings
+	}
+	for _, n := range after {
+		if m[n] != nil || len(n.children) == 0 {
+			continue
+		}
+		siblings := roots
+		if n.parent != nil {
+			if m[n.parent] == nil {
+				continue
+			}
+			siblings = m[n.parent].children
+		}
+		for _, o := range siblings {
+			if m[o] == nil && shareChild(o, n, m) {
+				m[o], m[n] = n, o
+				for _, c := range n.children {
+					for _, oc := range o.children {
+						if m[c] == nil && m[oc] == nil && oc.Text == c.Text {
+							m[oc], m[c] = c, oc
+						}
+					}
+				}
+				break
+			}
+		}
+	}
+
+	byText := make(map[string][]*diffNode)
+	for _, o := range before {
+		if m[o] == nil {
+			byText[o.Text] = append(byText[o.Text], o)
+		}
+	}
+	for _, n := range after {
+		if candidates := byText[n.Text]; m[n] == nil && len(candidates) > 0 {
+			m[n], m[candidates[0]] = candidates[0], n
+			byText[n.Text] = candidates[1:]
+		}
+	}
+	return m
+}
+
+// shareChild reports whether two unpaired nodes have an unpaired child with
+// the same text.
+func shareChild(o, n *diffNode, m pairs) bool {
+	for _, c := range n.children {
+		for _, oc := range o.children {
+			if m[c] == nil && m[oc] == nil && oc.Text == c.Text && !blank(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// movedNodes returns the nodes of the newer version that are paired with a
+// node of the older one but whose parent is no longer the same node, or
+// that changed places with their siblings. When siblings are reordered, as
+// few as possible are counted as moved.
+func movedNodes(after []*diffNode, m pairs) map[*diffNode]bool {
+	moved := make(map[*diffNode]bool)
+	siblings := make(map[*diffNode][]*diffNode)
+	var parents []*diffNode
+	for _, n := range after {
+		if m[n] == nil {
+			continue
+		}
+		if !sameParent(n, m) {
+			moved[n] = true
+			continue
+		}
+		if _, ok := siblings[n.parent]; !ok {
+			parents = append(parents, n.parent)
+		}
+		siblings[n.parent] = append(siblings[n.parent], n)
+	}
+	for _, parent := range parents {
+		group := siblings[parent]
+		inOrder := longestInOrder(group, m)
+		for _, n := range group {
+			if !inOrder[n] {
+				moved[n] = true
+			}
+		}
+	}
+	return moved
+}
+
+// Diff compares two versions of an outline and lists the nodes added,
+// removed and moved between them. Nodes are matched by text, preferring a
+// node in the same place, so a node whose text was edited shows up as one
+// removed and one added. A node with children is also matched to one in its
+// place with some of the same children, so that renaming it does not count
+// its children as moved; it too shows up as removed and added. A matched
+// node counts as moved if its parent is no longer the same node, or if it
+// changed places with its siblings; when siblings are reordered, as few as
+// possible are reported as moved.
+//
+// Removed nodes are listed first, in the older version's order, followed by
+// added and moved nodes in the newer version's order. Blank lines are
+// matched like any other node but never reported.
+func Diff(older, newer *Document) []Change {
+	before, after := diffNodes(older), diffNodes(newer)
+	m := match(before, after)
+	moved := movedNodes(after, m)
+
+	var changes []Change
+	for _, o := range before {
+		if (m[o] == nil || m[o].Text != o.Text) && !blank(o) {
+			changes = append(changes, Change{Kind: Removed, Text: o.Text, Path: o.path})
+		}
+	}
+	for _, n := range after {
+		switch {
+		case blank(n):
+		case m[n] == nil || m[n].Text != n.Text:
+			changes = append(changes, Change{Kind: Added, Text: n.Text, Path: n.path})
+		case moved[n]:
+			changes = append(changes, Change{Kind: Moved, Text: n.Text, Path: n.path, From: m[n].path})
+		}
+	}
+	return changes
+}
+
+func blank(n *diffNode) bool {
+	return strings.TrimSpace(n.Text) == ""
+}
+
+// sameParent reports whether a paired node's parent is paired with its
+// partner's parent.
+func sameParent(n *diffNode, m pairs) bool {
+	if n.parent == nil {
+		return m[n].parent == nil
+	}
+	return m[n.parent] != nil && m[n.parent] == m[n].parent
+}
+
+// longestInOrder returns the largest set of siblings whose order is the same
+// in both versions. The rest are the ones that moved.
+func longestInOrder(group []*diffNode, m pairs) map[*diffNode]bool {
+	// length[i] is the length of the longest run ending at group[i], and
+	// prev[i] the element before it in that run
+	length := make([]int, len(group))
+	prev := make([]int, len(group))
+	best := -1
+	for i, n := range group {
+		length[i], prev[i] = 1, -1
+		for j := 0; j < i; j++ {
+			if m[group[j]].index < m[n].index && length[j]+1 > length[i] {
+				length[i], prev[i] = length[j]+1, j
+			}
+		}
+		if best < 0 || length[i] > length[best] {
+			best = i
+		}
+	}
+
+	inOrder := make(map[*diffNode]bool)
+	for i := best; i >= 0; i = prev[i] {
+		inOrder[group[i]] = true
+	}
+	return inOrder
+}
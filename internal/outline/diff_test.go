@@ -0,0 +1,89 @@
+package outline_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kristofer/composter/internal/outline"
+)
+
+func TestDiff(t *testing.T) {
+	older := outline.Parse(`<div>Project</div>
+<div style="margin-left: 30px">Models</div>
+<div style="margin-left: 60px">Schema</div>
+<div style="margin-left: 30px">Views</div>
+<div style="margin-left: 30px">Tests</div>
+<div>Notes</div>
+<div style="margin-left: 30px">Old idea</div>`)
+
+	newer := outline.Parse(`<div>Project</div>
+<div style="margin-left: 30px">Tests</div>
+<div style="margin-left: 30px">Models</div>
+<div style="margin-left: 30px">Views</div>
+<div style="margin-left: 60px">Schema</div>
+<div style="margin-left: 60px">Templates</div>
+<div><br></div>
+<div>Notes</div>`)
+
+	expected := []outline.Change{
+		{Kind: outline.Removed, Text: "Old idea", Path: []string{"Notes"}},
+		{Kind: outline.Moved, Text: "Tests", Path: []string{"Project"}, From: []string{"Project"}},
+		{Kind: outline.Moved, Text: "Schema", Path: []string{"Project", "Views"}, From: []string{"Project", "Models"}},
+		{Kind: outline.Added, Text: "Templates", Path: []string{"Project", "Views"}},
+	}
+
+	if got := outline.Diff(older, newer); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, got)
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	content := `<div>A</div>
+<div style="margin-left: 30px">B</div>
+<div style="margin-left: 30px">B</div>
+<div>C</div>`
+
+	if changes := outline.Diff(outline.Parse(content), outline.Parse(content)); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+}
+
+func TestDiffEditedText(t *testing.T) {
+	changes := outline.Diff(outline.Parse("<div>Draft</div>"), outline.Parse("<div>Final</div>"))
+
+	expected := []outline.Change{
+		{Kind: outline.Removed, Text: "Draft", Path: []string{}},
+		{Kind: outline.Added, Text: "Final", Path: []string{}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, changes)
+	}
+}
+
+func TestDiffRenamedParent(t *testing.T) {
+	older := outline.Parse(`<div>Notes</div>
+<div>Plan</div>
+<div style="margin-left: 30px">Phase 1</div>
+<div style="margin-left: 60px">Task</div>
+<div style="margin-left: 30px">Phase 2</div>`)
+
+	// Renaming headings does not move what is under them, even with a new
+	// node before them
+	newer := outline.Parse(`<div>Notes</div>
+<div>New</div>
+<div>Roadmap</div>
+<div style="margin-left: 30px">Phase one</div>
+<div style="margin-left: 60px">Task</div>
+<div style="margin-left: 30px">Phase 2</div>`)
+
+	expected := []outline.Change{
+		{Kind: outline.Removed, Text: "Plan", Path: []string{}},
+		{Kind: outline.Removed, Text: "Phase 1", Path: []string{"Plan"}},
+		{Kind: outline.Added, Text: "New", Path: []string{}},
+		{Kind: outline.Added, Text: "Roadmap", Path: []string{}},
+		{Kind: outline.Added, Text: "Phase one", Path: []string{"Roadmap"}},
+	}
+	if got := outline.Diff(older, newer); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected:\n%+v\ngot:\n%+v", expected, got)
+	}
+}
@@ -0,0 +1,71 @@
+package outline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// markdownInline escapes characters that have inline meaning anywhere in a
+// line of Markdown.
+var markdownInline = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	`*`, `\*`,
+	`_`, `\_`,
+	`[`, `\[`,
+	`]`, `\]`,
+	`<`, `\<`,
+	`>`, `\>`,
+	`|`, `\|`,
+	`~`, `\~`,
+	`&`, `\&`,
+)
+
+// markdownOrdered matches text that would start an ordered list item.
+var markdownOrdered = regexp.MustCompile(`^(\d+)([.)])`)
+
+// EscapeMarkdown escapes text so that it renders literally as the content of
+// a Markdown list item.
+func EscapeMarkdown(text string) string {
+	text = markdownInline.Replace(text)
+
+	// Block-level markers only matter at the start of the item
+	if m := markdownOrdered.FindStringSubmatchIndex(text); m != nil {
+		return text[:m[4]] + `\` + text[m[4]:]
+	}
+	if text != "" && strings.ContainsRune("#+-=", rune(text[0])) {
+		return `\` + text
+	}
+	return text
+}
+
+// Markdown renders the document as a nested Markdown bullet list under a
+// level-one heading holding title. Nesting follows the tree rather than the
+// stored depth, so skipped indentation levels still produce a valid list.
+// Blank lines without children are omitted.
+func (d *Document) Markdown(title string) string {
+	var b strings.Builder
+	if title != "" {
+		b.WriteString("# ")
+		b.WriteString(markdownInline.Replace(title))
+		b.WriteString("\n\n")
+	}
+	writeMarkdown(&b, d.Roots, 0)
+	return b.String()
+}
+
+func writeMarkdown(b *strings.Builder, nodes []*Node, level int) {
+	for _, n := range nodes {
+		if strings.TrimSpace(n.Text) == "" && len(n.Children) == 0 {
+			continue
+		}
+		b.WriteString(strings.Repeat("  ", level))
+		b.WriteString("-")
+		if text := strings.TrimSpace(n.Text); text != "" {
+			b.WriteString(" ")
+			b.WriteString(EscapeMarkdown(text))
+		}
+		b.WriteString("\n")
+		writeMarkdown(b, n.Children, level+1)
+	}
+}
@@ -0,0 +1,51 @@
+package outline_test
+
+import (
+	"testing"
+
+	"github.com/kristofer/composter/internal/outline"
+)
+
+func TestMarkdown(t *testing.T) {
+	doc := outline.Parse(`<div>Project</div>
+<div style="margin-left: 30px">Models</div>
+<div style="margin-left: 90px">Skipped a level</div>
+<div style="margin-left: 30px"><br></div>
+<div>Notes</div>`)
+
+	expected := `# My Plan
+
+- Project
+  - Models
+    - Skipped a level
+- Notes
+`
+	if got := doc.Markdown("My Plan"); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"plain text", "plain text"},
+		{"*bold* and _em_", `\*bold\* and \_em\_`},
+		{"[link](url)", `\[link\](url)`},
+		{"a <b> & `c`", "a \\<b\\> \\& \\`c\\`"},
+		{`back\slash`, `back\\slash`},
+		{"# not a heading", `\# not a heading`},
+		{"- not a bullet", `\- not a bullet`},
+		{"+ not a bullet", `\+ not a bullet`},
+		{"1. not ordered", `1\. not ordered`},
+		{"42) not ordered", `42\) not ordered`},
+		{"version 1.2 - done", "version 1.2 - done"},
+	}
+
+	for _, tt := range tests {
+		if got := outline.EscapeMarkdown(tt.input); got != tt.expected {
+			t.Errorf("EscapeMarkdown(%q): expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
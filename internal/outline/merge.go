@@ -0,0 +1,256 @@
+package outline
+
+// Conflict is an edit that Merge could not combine with the other version.
+type Conflict struct {
+	Text string `json:"text"`
+	// Path is the text of the node's ancestors, outermost first.
+	Path   []string `json:"path"`
+	Reason string   `json:"reason"`
+}
+
+// mergeNode is a node of the outline Merge is building.
+type mergeNode struct {
+	text, note string
+	offset     int // indentation relative to the parent, as in its source
+	parent     *mergeNode
+	children   []*mergeNode
+	base       *diffNode // the same node in base, if it was there
+	added      bool      // added by ours
+	deleted    bool
+}
+
+func (n *mergeNode) detach() {
+	siblings := n.parent.children
+	for i, c := range siblings {
+		if c == n {
+			n.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *mergeNode) insert(parent *mergeNode, at int) {
+	n.parent = parent
+	parent.children = append(parent.children[:at:at], append([]*mergeNode{n}, parent.children[at:]...)...)
+}
+
+// Merge combines two versions of an outline, ours and theirs, that were
+// both edited from base. The result starts from ours, and the nodes theirs
+// added, removed and moved relative to base (as Diff would report them) are
+// then added, removed and moved in it. A node with children that theirs
+// renamed is renamed in the result, keeping what ours has under it.
+//
+// Edits that overlap are not merged and are returned as conflicts instead,
+// with a nil Document: both versions adding different nodes at the same
+// place (which includes both editing the same line), one version moving or
+// adding children to a node the other removed or renaming it, the two
+// versions renaming a node differently, and the two versions moving a node
+// to different parents.
+func Merge(base, ours, theirs *Document) (*Document, []Conflict) {
+	b, o, t := diffNodes(base), diffNodes(ours), diffNodes(theirs)
+	mo, mt := match(b, o), match(b, t)
+	movedOurs, movedTheirs := movedNodes(o, mo), movedNodes(t, mt)
+
+	root := &mergeNode{}
+	fromBase := make(map[*diffNode]*mergeNode)
+	fromTheirs := make(map[*diffNode]*mergeNode)
+
+	var build func(nodes []*diffNode, parent *mergeNode)
+	build = func(nodes []*diffNode, parent *mergeNode) {
+		for _, n := range nodes {
+			mn := &mergeNode{text: n.Text, note: n.Note, offset: n.offset(), parent: parent, base: mo[n], added: mo[n] == nil}
+			parent.children = append(parent.children, mn)
+			if mn.base != nil {
+				fromBase[mn.base] = mn
+			}
+			build(n.children, mn)
+		}
+	}
+	var roots []*diffNode
+	for _, n := range o {
+		if n.parent == nil {
+			roots = append(roots, n)
+		}
+	}
+	build(roots, root)
+
+	// inResult returns where a node of theirs is in the result, or nil if it
+	// is not there. A nil node is the root.
+	inResult := func(n *diffNode) *mergeNode {
+		if n == nil {
+			return root
+		}
+		if bn := mt[n]; bn != nil {
+			return fromBase[bn]
+		}
+		return fromTheirs[n]
+	}
+
+	var conflicts []Conflict
+	conflict := func(n *diffNode, reason string) {
+		conflicts = append(conflicts, Conflict{Text: n.Text, Path: n.path, Reason: reason})
+	}
+
+	// place puts mn where n is in theirs: under the same parent, after the
+	// nearest preceding sibling that is in the result
+	place := func(n *diffNode, mn *mergeNode) bool {
+		parent := inResult(n.parent)
+		if parent == nil || parent.deleted {
+			conflict(n, "its parent was removed in the other version")
+			return false
+		}
+		at := 0
+		for i := n.index - 1; i >= 0; i-- {
+			if prev := inResult(n.siblings[i]); prev != nil && prev.parent == parent {
+				for j, c := range parent.children {
+					if c == prev {
+						at = j + 1
+					}
+				}
+				break
+			}
+		}
+		mn.offset = n.offset()
+		mn.insert(parent, at)
+		return true
+	}
+
+	// retext gives mn the text n has in theirs, if theirs changed it from
+	// base. Both versions changing it differently is a conflict.
+	retext := func(n, bn *diffNode, mn *mergeNode) {
+		if n.Text == bn.Text || mn.text == n.Text {
+			return
+		}
+		if mn.text != bn.Text {
+			conflict(n, "the other version changed the same place")
+			return
+		}
+		mn.text = n.Text
+	}
+
+	for _, n := range t {
+		bn := mt[n]
+		switch {
+		case bn == nil:
+			// Added by theirs. If ours added a node at the same place, they
+			// are the same edit or overlapping ones.
+			mn := &mergeNode{text: n.Text, note: n.Note}
+			if !place(n, mn) {
+				continue
+			}
+			siblings := mn.parent.children
+			if i := indexOf(siblings, mn); i+1 < len(siblings) && siblings[i+1].added {
+				mn.detach()
+				if next := siblings[i+1]; next.text == n.Text {
+					fromTheirs[n] = next
+					next.added = false
+				} else {
+					conflict(n, "the other version changed the same place")
+				}
+				continue
+			}
+			fromTheirs[n] = mn
+
+		case movedTheirs[n]:
+			mn := fromBase[bn]
+			if mn == nil {
+				conflict(n, "it was removed in the other version")
+				continue
+			}
+			if movedOurs[mo[bn]] {
+				if inResult(n.parent) == mn.parent {
+					continue // both moved it under the same parent; keep ours
+				}
+				conflict(n, "it was moved somewhere else in the other version")
+				continue
+			}
+			mn.detach()
+			if place(n, mn) {
+				retext(n, bn, mn)
+			}
+
+		default:
+			// Kept in place, but perhaps renamed or indented differently
+			mn := fromBase[bn]
+			if mn == nil {
+				if n.Text != bn.Text {
+					conflict(n, "it was removed in the other version")
+				}
+				continue
+			}
+			retext(n, bn, mn)
+			if n.offset() != bn.offset() {
+				mn.offset = n.offset()
+			}
+		}
+	}
+
+	for _, bn := range b {
+		if mt[bn] != nil || fromBase[bn] == nil {
+			continue
+		}
+		if movedOurs[mo[bn]] {
+			conflict(bn, "it was moved in the other version")
+			continue
+		}
+		fromBase[bn].deleted = true
+	}
+	prune(root, &conflicts)
+
+	if len(conflicts) > 0 {
+		return nil, conflicts
+	}
+
+	var nodes []*Node
+	var flatten func(children []*mergeNode, depth int)
+	flatten = func(children []*mergeNode, depth int) {
+		prev := -1
+		for _, c := range children {
+			// Siblings must not be indented more than the one before them,
+			// or they would become its children
+			d := depth + c.offset
+			if prev >= 0 && d > prev {
+				d = prev
+			}
+			prev = d
+			nodes = append(nodes, &Node{Text: c.text, Depth: d, Note: c.note})
+			flatten(c.children, d)
+		}
+	}
+	flatten(root.children, 0)
+	return Build(nodes), nil
+}
+
+// prune removes deleted nodes from the tree. A deleted node whose children
+// are not all deleted too is a conflict: the other version added or kept
+// something under it.
+func prune(n *mergeNode, conflicts *[]Conflict) {
+	var kept []*mergeNode
+	for _, c := range n.children {
+		prune(c, conflicts)
+		switch {
+		case !c.deleted:
+			kept = append(kept, c)
+		case len(c.children) > 0:
+			*conflicts = append(*conflicts, Conflict{Text: c.text, Path: c.path(), Reason: "it was removed, but the other version changed what is under it"})
+		}
+	}
+	n.children = kept
+}
+
+func (n *mergeNode) path() []string {
+	path := []string{}
+	for p := n.parent; p != nil && p.parent != nil; p = p.parent {
+		path = append([]string{p.text}, path...)
+	}
+	return path
+}
+
+func indexOf(nodes []*mergeNode, n *mergeNode) int {
+	for i, c := range nodes {
+		if c == n {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,145 @@
+package outline_test
+
+import (
+	"testing"
+
+	"github.com/kristofer/composter/internal/outline"
+)
+
+func TestMerge(t *testing.T) {
+	base := outline.Parse(`<div>A</div>
+<div style="margin-left: 30px">a1</div>
+<div style="margin-left: 30px">a2</div>
+<div>B</div>
+<div style="margin-left: 30px">b1</div>
+<div>C</div>`)
+
+	// Ours edits b1 and adds a3; theirs moves B first, removes a1 and adds c1
+	ours := outline.Parse(`<div>A</div>
+<div style="margin-left: 30px">a1</div>
+<div style="margin-left: 30px">a2</div>
+<div style="margin-left: 30px">a3</div>
+<div>B</div>
+<div style="margin-left: 30px">b1 edited</div>
+<div>C</div>`)
+	theirs := outline.Parse(`<div>B</div>
+<div style="margin-left: 30px">b1</div>
+<div>A</div>
+<div style="margin-left: 30px">a2</div>
+<div>C</div>
+<div style="margin-left: 30px">c1</div>`)
+
+	merged, conflicts := outline.Merge(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %+v", conflicts)
+	}
+
+	expected := `<div>B</div>
+<div style="margin-left: 30px">b1 edited</div>
+<div>A</div>
+<div style="margin-left: 30px">a2</div>
+<div style="margin-left: 30px">a3</div>
+<div>C</div>
+<div style="margin-left: 30px">c1</div>`
+	if got := merged.HTML(); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestMergeSameEdit(t *testing.T) {
+	base := outline.Parse("<div>X</div><div>Y</div>")
+	edited := outline.Parse("<div>X</div><div>New</div><div>Y</div>")
+
+	merged, conflicts := outline.Merge(base, edited, outline.Parse(edited.HTML()))
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %+v", conflicts)
+	}
+	if merged.HTML() != edited.HTML() {
+		t.Errorf("Expected the edit once, got:\n%s", merged.HTML())
+	}
+}
+
+func TestMergeRenamedParent(t *testing.T) {
+	base := outline.Parse(`<div>Plan</div>
+<div style="margin-left: 30px">Step 1</div>
+<div>Later</div>`)
+	ours := outline.Parse(`<div>Plan</div>
+<div style="margin-left: 30px">Step 1</div>
+<div style="margin-left: 30px">Step 2</div>
+<div>Later</div>`)
+	theirs := outline.Parse(`<div>Roadmap</div>
+<div style="margin-left: 30px">Step 1</div>
+<div>Later</div>`)
+
+	merged, conflicts := outline.Merge(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %+v", conflicts)
+	}
+
+	expected := `<div>Roadmap</div>
+<div style="margin-left: 30px">Step 1</div>
+<div style="margin-left: 30px">Step 2</div>
+<div>Later</div>`
+	if got := merged.HTML(); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	tests := []struct {
+		name         string
+		base         string
+		ours, theirs string
+		text         string
+	}{
+		{
+			name:   "same line edited",
+			base:   "<div>X</div><div>Y</div>",
+			ours:   "<div>X mine</div><div>Y</div>",
+			theirs: "<div>X theirs</div><div>Y</div>",
+			text:   "X theirs",
+		},
+		{
+			name:   "child added to removed node",
+			base:   `<div>A</div><div style="margin-left: 30px">a1</div><div>B</div>`,
+			ours:   "<div>B</div>",
+			theirs: `<div>A</div><div style="margin-left: 30px">a1</div><div style="margin-left: 30px">a2</div><div>B</div>`,
+			text:   "a2",
+		},
+		{
+			name:   "removed node given a child",
+			base:   `<div>A</div><div style="margin-left: 30px">a1</div><div>B</div>`,
+			ours:   `<div>A</div><div style="margin-left: 30px">a1</div><div style="margin-left: 30px">a2</div><div>B</div>`,
+			theirs: "<div>B</div>",
+			text:   "A",
+		},
+		{
+			name:   "parent renamed differently",
+			base:   `<div>A</div><div style="margin-left: 30px">a1</div>`,
+			ours:   `<div>A mine</div><div style="margin-left: 30px">a1</div>`,
+			theirs: `<div>A theirs</div><div style="margin-left: 30px">a1</div>`,
+			text:   "A theirs",
+		},
+		{
+			name:   "removed node renamed",
+			base:   `<div>A</div><div style="margin-left: 30px">a1</div><div>B</div>`,
+			ours:   "<div>B</div>",
+			theirs: `<div>A2</div><div style="margin-left: 30px">a1</div><div>B</div>`,
+			text:   "A2",
+		},
+		{
+			name:   "moved to different parents",
+			base:   `<div>A</div><div>B</div><div>C</div>`,
+			ours:   `<div>A</div><div style="margin-left: 30px">C</div><div>B</div>`,
+			theirs: `<div>A</div><div>B</div><div style="margin-left: 30px">C</div>`,
+			text:   "C",
+		},
+	}
+
+	for _, tt := range tests {
+		merged, conflicts := outline.Merge(outline.Parse(tt.base), outline.Parse(tt.ours), outline.Parse(tt.theirs))
+		if merged != nil || len(conflicts) != 1 || conflicts[0].Text != tt.text {
+			t.Errorf("%s: expected one conflict on %q, got %+v", tt.name, tt.text, conflicts)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package outline
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotOPML is returned by ParseOPML when the input is well-formed XML but
+// not an OPML document.
+var ErrNotOPML = errors.New("outline: not an OPML document")
+
+type opmlDocument struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Note     string        `xml:"_note,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// OPML renders the document as an OPML 2.0 file with title in its head.
+func (d *Document) OPML(title string) ([]byte, error) {
+	doc := opmlDocument{
+		Version: "2.0",
+		Title:   title,
+		Body:    toOPML(d.Roots),
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func toOPML(nodes []*Node) []opmlOutline {
+	var outlines []opmlOutline
+	for _, n := range nodes {
+		outlines = append(outlines, opmlOutline{
+			Text:     n.Text,
+			Note:     n.Note,
+			Outlines: toOPML(n.Children),
+		})
+	}
+	return outlines
+}
+
+// ParseOPML reads an OPML file, returning the title from its head and its
+// body as a Document. Nesting of <outline> elements becomes node depth.
+func ParseOPML(r io.Reader) (string, *Document, error) {
+	var doc opmlDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		var wrongRoot xml.UnmarshalError
+		if errors.As(err, &wrongRoot) {
+			return "", nil, ErrNotOPML
+		}
+		return "", nil, err
+	}
+
+	var nodes []*Node
+	var flatten func(outlines []opmlOutline, depth int)
+	flatten = func(outlines []opmlOutline, depth int) {
+		for _, o := range outlines {
+			nodes = append(nodes, &Node{
+				Text:  strings.TrimSpace(o.Text),
+				Depth: depth,
+				Note:  o.Note,
+			})
+			flatten(o.Outlines, depth+1)
+		}
+	}
+	flatten(doc.Body, 0)
+
+	return strings.TrimSpace(doc.Title), Build(nodes), nil
+}
@@ -0,0 +1,96 @@
+package outline_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kristofer/composter/internal/outline"
+)
+
+func TestOPMLRoundTrip(t *testing.T) {
+	doc := outline.Parse(`<div>Project</div>
+<div style="margin-left: 30px" data-note="Keep it &amp; simple">Models</div>
+<div style="margin-left: 60px">Schema &lt;v2&gt;</div>
+<div>Notes</div>`)
+
+	data, err := doc.OPML("My Plan")
+	if err != nil {
+		t.Fatalf("Failed to render OPML: %v", err)
+	}
+
+	if !bytes.Contains(data, []byte(`<opml version="2.0">`)) {
+		t.Error("Expected an OPML 2.0 root element")
+	}
+	if !bytes.Contains(data, []byte(`<title>My Plan</title>`)) {
+		t.Error("Expected the title in the OPML head")
+	}
+
+	title, parsed, err := outline.ParseOPML(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse OPML: %v", err)
+	}
+
+	if title != "My Plan" {
+		t.Errorf("Expected title 'My Plan', got '%s'", title)
+	}
+
+	if got := parsed.HTML(); got != doc.HTML() {
+		t.Errorf("Expected HTML to survive OPML round trip:\n%s\ngot:\n%s", doc.HTML(), got)
+	}
+}
+
+func TestParseOPML(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head><title> Imported </title></head>
+  <body>
+    <outline text="Root" _note="A note">
+      <outline text="Child">
+        <outline text="Grandchild"/>
+      </outline>
+    </outline>
+    <outline text="Second"/>
+  </body>
+</opml>`
+
+	title, doc, err := outline.ParseOPML(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to parse OPML: %v", err)
+	}
+
+	if title != "Imported" {
+		t.Errorf("Expected title 'Imported', got '%s'", title)
+	}
+
+	nodes := doc.Nodes()
+	if len(nodes) != 4 {
+		t.Fatalf("Expected 4 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Note != "A note" {
+		t.Errorf("Expected note to be preserved, got '%s'", nodes[0].Note)
+	}
+	if nodes[2].Text != "Grandchild" || nodes[2].Depth != 2 {
+		t.Errorf("Unexpected node: %+v", nodes[2])
+	}
+
+	expected := `<div data-note="A note">Root</div>
+<div style="margin-left: 30px">Child</div>
+<div style="margin-left: 60px">Grandchild</div>
+<div>Second</div>`
+	if got := doc.HTML(); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestParseOPMLRejectsOtherXML(t *testing.T) {
+	_, _, err := outline.ParseOPML(strings.NewReader(`<html><body></body></html>`))
+	if err != outline.ErrNotOPML {
+		t.Errorf("Expected ErrNotOPML, got %v", err)
+	}
+
+	_, _, err = outline.ParseOPML(strings.NewReader(`<opml><body>`))
+	if err == nil {
+		t.Error("Expected error for truncated OPML")
+	}
+}
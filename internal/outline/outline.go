@@ -0,0 +1,219 @@
+// Package outline converts the HTML stored in outlines and templates into a
+// typed tree of nodes and back.
+//
+// The editor stores an outline as a flat run of <div> elements, one per line,
+// where hierarchy is encoded only as an inline margin-left of IndentWidth
+// pixels per level:
+//
+//	<div>Project</div>
+//	<div style="margin-left: 30px">Models</div>
+//	<div style="margin-left: 60px">Data structures</div>
+package outline
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IndentWidth is the number of pixels of margin-left per indentation level,
+// matching the editor in static/outliner.js.
+const IndentWidth = 30
+
+// Node is a single line of an outline.
+type Node struct {
+	// ID identifies the node within its document. It is derived from the
+	// node's text and its parent's ID, so it survives edits elsewhere in the
+	// outline, but not to the node's own text or an ancestor's. Diff and
+	// Merge make up for that when matching nodes.
+	ID    string
+	Text  string
+	Depth int
+	// Note is free-form text attached to the node, kept in a data-note
+	// attribute. The editor does not display it, but imports such as OPML
+	// carry notes that exports should not lose.
+	Note     string
+	Children []*Node
+}
+
+// Document is a parsed outline. Roots holds the top-level nodes in order.
+type Document struct {
+	Roots []*Node
+}
+
+var (
+	divPattern    = regexp.MustCompile(`(?is)<div\b([^>]*)>(.*?)</div>`)
+	stylePattern  = regexp.MustCompile(`(?is)\bstyle\s*=\s*("([^"]*)"|'([^']*)')`)
+	notePattern   = regexp.MustCompile(`(?is)\bdata-note\s*=\s*("([^"]*)"|'([^']*)')`)
+	marginPattern = regexp.MustCompile(`(?i)margin-left\s*:\s*(\d+)(?:\.\d+)?px`)
+	tagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Parse builds a Document from stored outline HTML. Content without any
+// <div> elements is treated as plain text, one node per line, indented by
+// two spaces per level as in the editor.
+func Parse(content string) *Document {
+	matches := divPattern.FindAllStringSubmatch(content, -1)
+	if len(matches) == 0 {
+		return parsePlainText(content)
+	}
+
+	var nodes []*Node
+	for _, m := range matches {
+		nodes = append(nodes, &Node{
+			Text:  divText(m[2]),
+			Depth: marginDepth(m[1]),
+			Note:  divNote(m[1]),
+		})
+	}
+	return Build(nodes)
+}
+
+func parsePlainText(content string) *Document {
+	if strings.TrimSpace(content) == "" {
+		return &Document{}
+	}
+
+	var nodes []*Node
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		nodes = append(nodes, &Node{
+			Text:  strings.TrimSpace(trimmed),
+			Depth: (len(line) - len(trimmed)) / 2,
+		})
+	}
+	return Build(nodes)
+}
+
+// divText returns the text content of a div's inner HTML.
+func divText(inner string) string {
+	return html.UnescapeString(tagPattern.ReplaceAllString(inner, ""))
+}
+
+// marginDepth returns the indentation level encoded in a div's attributes.
+func marginDepth(attrs string) int {
+	style := stylePattern.FindStringSubmatch(attrs)
+	if style == nil {
+		return 0
+	}
+	margin := marginPattern.FindStringSubmatch(style[2] + style[3])
+	if margin == nil {
+		return 0
+	}
+	px, err := strconv.Atoi(margin[1])
+	if err != nil {
+		return 0
+	}
+	return px / IndentWidth
+}
+
+// divNote returns the note stored in a div's attributes, if any.
+func divNote(attrs string) string {
+	note := notePattern.FindStringSubmatch(attrs)
+	if note == nil {
+		return ""
+	}
+	return html.UnescapeString(note[2] + note[3])
+}
+
+// Build assembles a Document from nodes listed in document order, using each
+// node's Depth to attach it to the nearest preceding node that is shallower.
+// Any existing Children are discarded and IDs are reassigned.
+func Build(nodes []*Node) *Document {
+	doc := &Document{}
+	var stack []*Node
+	for _, n := range nodes {
+		n.Children = nil
+		for len(stack) > 0 && stack[len(stack)-1].Depth >= n.Depth {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			doc.Roots = append(doc.Roots, n)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, n)
+		}
+		stack = append(stack, n)
+	}
+	doc.AssignIDs()
+	return doc
+}
+
+// AssignIDs recomputes the ID of every node in the document. Siblings with
+// identical text are told apart by their position among those duplicates.
+func (d *Document) AssignIDs() {
+	assignIDs("", d.Roots)
+}
+
+func assignIDs(parentID string, nodes []*Node) {
+	seen := make(map[string]int)
+	for _, n := range nodes {
+		dup := seen[n.Text]
+		seen[n.Text]++
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%d", parentID, n.Text, dup)))
+		n.ID = hex.EncodeToString(sum[:6])
+		assignIDs(n.ID, n.Children)
+	}
+}
+
+// Walk calls fn for every node in document order. If fn returns false the
+// node's children are skipped.
+func (d *Document) Walk(fn func(n *Node) bool) {
+	walk(d.Roots, fn)
+}
+
+func walk(nodes []*Node, fn func(n *Node) bool) {
+	for _, n := range nodes {
+		if fn(n) {
+			walk(n.Children, fn)
+		}
+	}
+}
+
+// Nodes returns every node in document order.
+func (d *Document) Nodes() []*Node {
+	var nodes []*Node
+	d.Walk(func(n *Node) bool {
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+// HTML renders the document in the storage format used by the seeded system
+// templates. Empty lines are rendered as <br> so the editor keeps them.
+func (d *Document) HTML() string {
+	var b strings.Builder
+	for i, n := range d.Nodes() {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString("<div")
+		if n.Depth > 0 {
+			fmt.Fprintf(&b, ` style="margin-left: %dpx"`, n.Depth*IndentWidth)
+		}
+		if n.Note != "" {
+			fmt.Fprintf(&b, ` data-note="%s"`, html.EscapeString(n.Note))
+		}
+		b.WriteString(">")
+		if n.Text == "" {
+			b.WriteString("<br>")
+		} else {
+			b.WriteString(escapeText(n.Text))
+		}
+		b.WriteString("</div>")
+	}
+	return b.String()
+}
+
+// textEscaper escapes the same characters a browser escapes when serializing
+// a text node, so text typed in the editor keeps its quotes and apostrophes.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\u00a0", "&nbsp;")
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
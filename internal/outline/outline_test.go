@@ -0,0 +1,168 @@
+package outline_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/kristofer/composter/internal/database"
+	"github.com/kristofer/composter/internal/outline"
+)
+
+func TestParse(t *testing.T) {
+	content := `<div>Project</div>
+<div style="margin-left: 30px">Models</div>
+<div style="margin-left: 60px">Schema</div>
+<div style="margin-left: 30px">Views</div>
+<div>Notes</div>`
+
+	doc := outline.Parse(content)
+
+	if len(doc.Roots) != 2 {
+		t.Fatalf("Expected 2 roots, got %d", len(doc.Roots))
+	}
+
+	project := doc.Roots[0]
+	if project.Text != "Project" || project.Depth != 0 {
+		t.Errorf("Unexpected root node: %+v", project)
+	}
+	if len(project.Children) != 2 {
+		t.Fatalf("Expected 2 children of Project, got %d", len(project.Children))
+	}
+	if project.Children[0].Text != "Models" || project.Children[0].Depth != 1 {
+		t.Errorf("Unexpected child node: %+v", project.Children[0])
+	}
+	if len(project.Children[0].Children) != 1 || project.Children[0].Children[0].Text != "Schema" {
+		t.Error("Expected Schema to be a child of Models")
+	}
+	if doc.Roots[1].Text != "Notes" {
+		t.Errorf("Expected second root 'Notes', got '%s'", doc.Roots[1].Text)
+	}
+}
+
+func TestParseEditorFormat(t *testing.T) {
+	// The editor writes every line with an explicit margin, a trailing
+	// semicolon, no separators and <br> for blank lines.
+	content := `<div style="margin-left: 0px;">Root</div><div style="margin-left: 30px;">Fish &amp; chips</div><div style="margin-left: 30px;"><br></div><div style="margin-left: 90px;">Deep</div>`
+
+	nodes := outline.Parse(content).Nodes()
+
+	expected := []struct {
+		text  string
+		depth int
+	}{
+		{"Root", 0},
+		{"Fish & chips", 1},
+		{"", 1},
+		{"Deep", 3},
+	}
+
+	if len(nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes, got %d", len(expected), len(nodes))
+	}
+	for i, want := range expected {
+		if nodes[i].Text != want.text || nodes[i].Depth != want.depth {
+			t.Errorf("Node %d: expected (%q, %d), got (%q, %d)", i, want.text, want.depth, nodes[i].Text, nodes[i].Depth)
+		}
+	}
+
+	// A skipped level still nests under the nearest shallower node
+	if len(nodes[2].Children) != 1 || nodes[2].Children[0] != nodes[3] {
+		t.Error("Expected Deep to be a child of the blank line")
+	}
+}
+
+func TestParsePlainText(t *testing.T) {
+	doc := outline.Parse("Item\n  Sub item")
+
+	nodes := doc.Nodes()
+	if len(nodes) != 2 {
+		t.Fatalf("Expected 2 nodes, got %d", len(nodes))
+	}
+	if nodes[1].Text != "Sub item" || nodes[1].Depth != 1 {
+		t.Errorf("Unexpected node: %+v", nodes[1])
+	}
+
+	if len(outline.Parse("").Roots) != 0 {
+		t.Error("Expected empty content to parse to an empty document")
+	}
+}
+
+func TestNodeIDsAreStable(t *testing.T) {
+	before := outline.Parse(`<div>A</div>
+<div style="margin-left: 30px">Child</div>
+<div>B</div>
+<div>B</div>`)
+	after := outline.Parse(`<div>New</div>
+<div>A</div>
+<div style="margin-left: 30px">Child</div>
+<div>B</div>
+<div>B</div>`)
+
+	ids := make(map[string]bool)
+	for _, n := range before.Nodes() {
+		if ids[n.ID] {
+			t.Errorf("Duplicate node ID %s for '%s'", n.ID, n.Text)
+		}
+		ids[n.ID] = true
+	}
+
+	for _, n := range after.Nodes() {
+		if n.Text != "New" && !ids[n.ID] {
+			t.Errorf("Expected ID of '%s' to survive inserting a sibling", n.Text)
+		}
+	}
+}
+
+func TestHTMLEscapesText(t *testing.T) {
+	doc := outline.Build([]*outline.Node{
+		{Text: `a < b & "c"`},
+		{Text: "", Depth: 1},
+	})
+
+	expected := `<div>a &lt; b &amp; "c"</div>
+<div style="margin-left: 30px"><br></div>`
+	if got := doc.HTML(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	if got := outline.Parse(doc.HTML()).Nodes()[0].Text; got != `a < b & "c"` {
+		t.Errorf("Expected text to round-trip, got %q", got)
+	}
+}
+
+func TestSystemTemplatesRoundTrip(t *testing.T) {
+	dbPath := "/tmp/test_composter_outline_roundtrip.db"
+	defer os.Remove(dbPath)
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Init("admin"); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	templates, err := db.GetSystemTemplates()
+	if err != nil {
+		t.Fatalf("Failed to get system templates: %v", err)
+	}
+
+	if len(templates) == 0 {
+		t.Fatal("Expected seeded system templates")
+	}
+
+	for _, tmpl := range templates {
+		doc := outline.Parse(tmpl.Content)
+
+		if len(doc.Roots) == 0 {
+			t.Errorf("%s: expected at least one root node", tmpl.Name)
+			continue
+		}
+
+		if got := doc.HTML(); got != tmpl.Content {
+			t.Errorf("%s: rendered HTML does not match stored content", tmpl.Name)
+		}
+	}
+}
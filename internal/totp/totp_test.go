@@ -0,0 +1,80 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B, SHA1 mode
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got := hotp(key, uint64(tt.unix/Period), 8)
+		if got != tt.want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatalf("Failed to compute code: %v", err)
+	}
+	if len(code) != Digits {
+		t.Fatalf("Expected %d digit code, got %q", Digits, code)
+	}
+
+	step, ok := Validate(secret, code, now)
+	if !ok || step != Step(now) {
+		t.Errorf("Expected code to validate at step %d, got %d, %v", Step(now), step, ok)
+	}
+
+	// One step of drift is tolerated, two are not
+	if _, ok := Validate(secret, code, now.Add(Period*time.Second)); !ok {
+		t.Error("Expected code to validate one step later")
+	}
+	if _, ok := Validate(secret, code, now.Add(2*Period*time.Second)); ok {
+		t.Error("Expected code to be rejected two steps later")
+	}
+
+	if _, ok := Validate(secret, "000000", now); ok && code != "000000" {
+		t.Error("Expected wrong code to be rejected")
+	}
+	if _, ok := Validate("not base32!", code, now); ok {
+		t.Error("Expected invalid secret to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Composter", "alice", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Composter:alice?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	for _, part := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Composter", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("Expected URI to contain %s: %s", part, uri)
+		}
+	}
+}
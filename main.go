@@ -1,32 +1,154 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/kristofer/composter/internal/config"
 	"github.com/kristofer/composter/internal/database"
 	"github.com/kristofer/composter/internal/handlers"
 	"github.com/kristofer/composter/internal/middleware"
+	"github.com/kristofer/composter/internal/oidc"
+	"github.com/kristofer/composter/internal/tlscert"
 )
 
 func main() {
+	// "composter migrate status|up [flags]" manages the schema and exits
+	args, action := os.Args[1:], ""
+	if len(args) > 0 && args[0] == "migrate" {
+		if len(args) < 2 {
+			config.Usage(os.Stderr)
+			os.Exit(2)
+		}
+		args, action = args[2:], args[1]
+	}
+
+	cfg, err := config.Load(args, os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		config.Usage(os.Stdout)
+		return
+	}
+	if err != nil {
+		log.Fatal("Error loading configuration: ", err)
+	}
+
+	if action != "" {
+		if err := migrate(action, cfg, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Stop cleanly on Ctrl-C or when the service manager asks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves Composter until ctx is cancelled. Everything it opens is
+// closed before it returns, whether or not it fails.
+func run(ctx context.Context, cfg *config.Config) error {
+
 	// Initialize database
-	db, err := database.New("composter.db")
+	db, err := database.Open(cfg.Database.Driver, cfg.Database.DSN)
 	if err != nil {
-		log.Fatal("Error opening database:", err)
+		return fmt.Errorf("opening database: %w", err)
 	}
 	defer db.Close()
 
-	if err := db.Init(); err != nil {
-		log.Fatal("Error initializing database:", err)
+	// The first admin's password comes from the configuration, or is
+	// generated and printed once
+	if err := db.Init(cfg.Auth.AdminPassword); err != nil {
+		return fmt.Errorf("initializing database: %w", err)
+	}
+
+	// Purge old items from the trash, stopping before the database closes
+	if cfg.Trash.Retention > 0 {
+		purgeCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			purgeTrash(purgeCtx, db, cfg.Trash.Retention)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
 	}
 
 	// Create session store
-	store := middleware.NewSessionStore()
+	timeouts := middleware.SessionTimeouts{
+		Idle:     cfg.Session.IdleTimeout,
+		Absolute: cfg.Session.AbsoluteTimeout,
+	}
+	store, err := middleware.NewStore(cfg.Session.Store, db, timeouts)
+	if err != nil {
+		return fmt.Errorf("creating session store: %w", err)
+	}
+	defer store.Close()
+
+	auth := middleware.NewAuthenticator(db, store, timeouts)
+	auth.Cookies.Secure = cfg.Cookies.Secure
+	auth.Cookies.SameSite, _ = middleware.ParseSameSite(cfg.Cookies.SameSite)
+	auth.APITokens = cfg.Features.API
+
+	if cfg.Features.TwoFactor {
+		setting, err := db.GetSetting(database.SettingRequireTwoFactor)
+		if err != nil {
+			return fmt.Errorf("reading settings: %w", err)
+		}
+		twoFactor, err := middleware.ParseTwoFactorRequirement(setting)
+		if err != nil {
+			return fmt.Errorf("reading settings: %w", err)
+		}
+		auth.SetTwoFactorRequirement(twoFactor)
+	}
+
+	logins := middleware.NewLoginLimiter(db, middleware.DefaultLoginPolicy)
+	defer logins.Close()
 
 	// Create handlers
-	h := handlers.New(db, store)
+	templates := assets(embeddedTemplates, "templates", cfg.Server.TemplatesDir)
+	h := handlers.New(db, auth, logins, templates, cfg.Server.TemplatesDir != "")
+	h.Features = cfg.Features
+	h.TrashRetention = cfg.Trash.Retention
+	h.Passwords = middleware.PasswordPolicy{
+		MinLength:  cfg.Auth.PasswordMinLength,
+		MinClasses: cfg.Auth.PasswordMinClasses,
+	}
+
+	// Single sign-on, off unless an issuer is given
+	if cfg.OIDC.Issuer != "" {
+		discoverCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		h.SSO, err = oidc.NewProvider(discoverCtx, oidc.Config{
+			Issuer:        cfg.OIDC.Issuer,
+			ClientID:      cfg.OIDC.ClientID,
+			ClientSecret:  cfg.OIDC.ClientSecret,
+			RedirectURL:   cfg.OIDC.RedirectURL,
+			UsernameClaim: cfg.OIDC.UsernameClaim,
+			GroupsClaim:   cfg.OIDC.GroupsClaim,
+			AdminGroups:   cfg.OIDC.AdminGroups,
+			AutoProvision: cfg.OIDC.AutoProvision,
+			LinkExisting:  cfg.OIDC.LinkExisting,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("configuring single sign-on: %w", err)
+		}
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -40,20 +162,62 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/login/2fa", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.LoginTwoFactorPage(w, r)
+		} else {
+			h.LoginTwoFactor(w, r)
+		}
+	})
+
+	mux.HandleFunc("/login/sso", h.SSOLogin)
+	mux.HandleFunc("/login/sso/callback", h.SSOCallback)
+
 	// Protected routes
 	authMux := http.NewServeMux()
 	authMux.HandleFunc("/", h.ListOutlines)
 	authMux.HandleFunc("/logout", h.Logout)
+	authMux.HandleFunc("/password", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			h.ChangePasswordPage(w, r)
+		} else {
+			h.ChangePassword(w, r)
+		}
+	})
 	authMux.HandleFunc("/editor", h.ViewOutline)
 	authMux.HandleFunc("/templates", h.ListTemplates)
 	authMux.HandleFunc("/api/outline/save", h.SaveOutline)
 	authMux.HandleFunc("/api/outline/delete", h.DeleteOutline)
+	authMux.HandleFunc("/api/outline/export", h.ExportOutline)
+	authMux.HandleFunc("/api/outline/import", h.ImportOutline)
+	authMux.HandleFunc("/api/outline/revisions", h.ListRevisions)
+	authMux.HandleFunc("/api/outline/diff", h.DiffRevisions)
+	authMux.HandleFunc("/api/outline/restore", h.RestoreRevision)
+	authMux.HandleFunc("/api/outline/shares", h.ListShares)
+	authMux.HandleFunc("/api/outline/share", h.ShareOutline)
+	authMux.HandleFunc("/api/outline/unshare", h.UnshareOutline)
+	authMux.HandleFunc("/api/outline/transfer", h.TransferOutline)
 	authMux.HandleFunc("/api/template/instantiate", h.InstantiateTemplate)
 	authMux.HandleFunc("/api/template/create", h.CreateTemplateFromOutline)
 	authMux.HandleFunc("/api/template/update", h.UpdateTemplate)
 	authMux.HandleFunc("/api/template/delete", h.DeleteTemplate)
 	authMux.HandleFunc("/api/template/export", h.ExportTemplate)
 	authMux.HandleFunc("/api/template/import", h.ImportTemplate)
+	authMux.HandleFunc("/trash", h.TrashPage)
+	authMux.HandleFunc("/api/trash/restore", h.RestoreFromTrash)
+	authMux.HandleFunc("/api/trash/purge", h.PurgeFromTrash)
+	authMux.HandleFunc("/api/trash/empty", h.EmptyTrash)
+	authMux.HandleFunc("/api/search", h.Search)
+	authMux.HandleFunc("/account", h.AccountPage)
+	authMux.HandleFunc("/api/account/password", h.ChangeAccountPassword)
+	authMux.HandleFunc("/2fa", h.TwoFactorPage)
+	authMux.HandleFunc("/api/2fa/setup", h.SetupTwoFactor)
+	authMux.HandleFunc("/api/2fa/enable", h.EnableTwoFactor)
+	authMux.HandleFunc("/api/2fa/disable", h.DisableTwoFactor)
+	authMux.HandleFunc("/api/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+	authMux.HandleFunc("/tokens", h.TokensPage)
+	authMux.HandleFunc("/api/token/create", h.CreateAPIToken)
+	authMux.HandleFunc("/api/token/revoke", h.RevokeAPIToken)
 
 	// Admin routes
 	adminMux := http.NewServeMux()
@@ -61,24 +225,180 @@ func main() {
 	adminMux.HandleFunc("/api/admin/user/create", h.CreateUser)
 	adminMux.HandleFunc("/api/admin/user/update", h.UpdateUser)
 	adminMux.HandleFunc("/api/admin/user/delete", h.DeleteUser)
+	adminMux.HandleFunc("/api/admin/deleted-user/recover", h.RecoverDeletedUser)
+	adminMux.HandleFunc("/api/admin/deleted-user/purge", h.PurgeDeletedUser)
+	adminMux.HandleFunc("/api/admin/lockout/clear", h.ClearLockout)
+	adminMux.HandleFunc("/api/admin/settings/2fa", h.SetTwoFactorRequirement)
+
+	// REST API v1
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("GET /api/v1/outlines", h.APIListOutlines)
+	apiMux.HandleFunc("POST /api/v1/outlines", h.APICreateOutline)
+	apiMux.HandleFunc("GET /api/v1/outlines/{id}", h.APIGetOutline)
+	apiMux.HandleFunc("PUT /api/v1/outlines/{id}", h.APIUpdateOutline)
+	apiMux.HandleFunc("DELETE /api/v1/outlines/{id}", h.APIDeleteOutline)
+	apiMux.HandleFunc("GET /api/v1/outlines/{id}/export", h.APIExportOutline)
 
 	// Apply middleware
-	mux.Handle("/", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/logout", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/editor", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/templates", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/api/outline/", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/api/template/", middleware.AuthRequired(store)(authMux))
-	mux.Handle("/admin", middleware.AdminRequired(store)(adminMux))
-	mux.Handle("/api/admin/", middleware.AdminRequired(store)(adminMux))
+	mux.Handle("/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/logout", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/password", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/editor", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/templates", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/outline/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/template/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/trash", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/trash/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/search", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/account", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/api/account/", middleware.AuthRequired(auth)(authMux))
+	mux.Handle("/2fa", feature(cfg.Features.TwoFactor, middleware.AuthRequired(auth)(authMux)))
+	mux.Handle("/api/2fa/", feature(cfg.Features.TwoFactor, middleware.AuthRequired(auth)(authMux)))
+	mux.Handle("/tokens", feature(cfg.Features.API, middleware.AuthRequired(auth)(authMux)))
+	mux.Handle("/api/token/", feature(cfg.Features.API, middleware.AuthRequired(auth)(authMux)))
+	mux.Handle("/admin", middleware.AdminRequired(auth)(adminMux))
+	mux.Handle("/api/admin/", middleware.AdminRequired(auth)(adminMux))
+	mux.Handle("/api/v1/", feature(cfg.Features.API, middleware.APIAuthRequired(auth)(apiMux)))
 
 	// Static files
-	fs := http.FileServer(http.Dir("static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	static := assets(embeddedStatic, "static", cfg.Server.StaticDir)
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServerFS(static)))
+
+	proxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("reading trusted proxies: %w", err)
+	}
+	var handler http.Handler = mux
+	handler = middleware.CSRF(auth.Cookies)(handler)
+	handler = middleware.HTTPS(proxies, cfg.TLS.HSTSMaxAge)(handler)
+	handler = middleware.MaxBodySize(cfg.Server.MaxBodyBytes)(handler)
 
 	// Start server
-	port := ":8080"
-	fmt.Printf("Starting server on http://localhost%s\n", port)
-	fmt.Println("Default admin login: admin / admin")
-	log.Fatal(http.ListenAndServe(port, mux))
+	server := newServer(cfg, handler)
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	listeners := []listener{{server, ln}}
+
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		certs, err := tlscert.New(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		server.TLSConfig = certs.Config()
+		listeners[0].ln = tls.NewListener(ln, server.TLSConfig)
+		scheme = "https"
+
+		// Pick up renewed certificates on SIGHUP
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				if err := certs.Reload(); err != nil {
+					log.Printf("Keeping previous TLS certificate: %v", err)
+				} else {
+					log.Println("Reloaded TLS certificate")
+				}
+			}
+		}()
+
+		if cfg.TLS.RedirectListen != "" {
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			redirect := newServer(cfg, middleware.RedirectToHTTPS(port))
+			redirect.Addr = cfg.TLS.RedirectListen
+			redirectLn, err := net.Listen("tcp", redirect.Addr)
+			if err != nil {
+				ln.Close()
+				return err
+			}
+			fmt.Printf("Redirecting http://%s to HTTPS\n", redirectLn.Addr())
+			listeners = append(listeners, listener{redirect, redirectLn})
+		}
+	}
+
+	fmt.Printf("Starting server on %s://%s\n", scheme, ln.Addr())
+	return serve(ctx, cfg.Server.ShutdownTimeout, listeners...)
+}
+
+// newServer returns a server for handler with the configured limits.
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+}
+
+// listener is a server and the listener it serves.
+type listener struct {
+	server *http.Server
+	ln     net.Listener
+}
+
+// serve runs each server until ctx is cancelled or one of them fails, then
+// stops them all accepting connections and waits up to timeout for requests
+// in progress to finish.
+func serve(ctx context.Context, timeout time.Duration, listeners ...listener) error {
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errc <- l.server.Serve(l.ln) }()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		log.Println("Shutting down: waiting for requests in progress")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, l := range listeners {
+		if shutdownErr := l.server.Shutdown(shutdownCtx); shutdownErr != nil && err == nil {
+			err = fmt.Errorf("shutting down: %w", shutdownErr)
+		}
+	}
+	return err
+}
+
+// trashPurgeInterval is how often the trash is checked for items older than
+// the configured retention.
+const trashPurgeInterval = time.Hour
+
+// purgeTrash deletes items that have been in the trash longer than retention,
+// at once and then every trashPurgeInterval, until ctx is cancelled.
+func purgeTrash(ctx context.Context, db database.Store, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := db.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Error purging trash: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d items from the trash", n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// feature serves routes belonging to an optional feature only if it is
+// enabled.
+func feature(enabled bool, h http.Handler) http.Handler {
+	if !enabled {
+		return http.NotFoundHandler()
+	}
+	return h
 }
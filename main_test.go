@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kristofer/composter/internal/config"
+)
+
+func TestServeDrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			io.WriteString(w, "done")
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, 5*time.Second, listener{server, ln}) }()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	cancel()
+
+	// New connections are refused while the request in progress finishes
+	time.Sleep(50 * time.Millisecond)
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("Expected new connections to be refused during shutdown")
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("Expected request in progress to complete, got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, 50*time.Millisecond, listener{server, ln}) }()
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	cancel()
+	if err := <-served; err == nil {
+		t.Error("Expected shutdown to time out on a stuck request")
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	cfg := config.Default()
+	cfg.Database.DSN = filepath.Join(t.TempDir(), "composter.db")
+
+	var out strings.Builder
+	if err := migrate("status", cfg, &out); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if !strings.Contains(out.String(), "0001_baseline") || !strings.Contains(out.String(), "pending") {
+		t.Errorf("Expected baseline to be pending, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := migrate("up", cfg, &out); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if !strings.Contains(out.String(), "Applied 0001_baseline") {
+		t.Errorf("Expected baseline to be applied, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := migrate("up", cfg, &out); err != nil {
+		t.Fatalf("Failed to migrate again: %v", err)
+	}
+	if out.String() != "Database is up to date\n" {
+		t.Errorf("Expected nothing to do, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := migrate("status", cfg, &out); err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if strings.Contains(out.String(), "pending") {
+		t.Errorf("Expected every migration to be applied, got:\n%s", out.String())
+	}
+
+	if err := migrate("down", cfg, &out); err == nil {
+		t.Error("Expected unknown command to fail")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kristofer/composter/internal/config"
+	"github.com/kristofer/composter/internal/database"
+)
+
+// migrate runs "composter migrate status" or "composter migrate up" against
+// the configured database.
+func migrate(action string, cfg *config.Config, out io.Writer) error {
+	if action != "status" && action != "up" {
+		return fmt.Errorf("unknown migrate command %q: want status or up", action)
+	}
+
+	db, err := database.Open(cfg.Database.Driver, cfg.Database.DSN)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer db.Close()
+
+	if action == "up" {
+		applied, err := db.Migrate()
+		for _, m := range applied {
+			fmt.Fprintf(out, "Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "Database is up to date")
+		}
+		return nil
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		state := "pending"
+		if s.Applied {
+			state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%04d_%-30s %s\n", s.Version, s.Name, state)
+	}
+	return nil
+}
@@ -4,10 +4,12 @@
  */
 
 class OutlineManager {
-    constructor(editorElement, titleElement, outlineId) {
+    constructor(editorElement, titleElement, outlineId, version = 0, readOnly = false) {
         this.editor = editorElement;
         this.titleInput = titleElement;
         this.outlineId = outlineId;
+        this.version = version;
+        this.readOnly = readOnly;
         this.collapsedLines = new Set();
         this.fullContent = '';
         
@@ -805,9 +807,15 @@ class OutlineManager {
     }
 
     /**
-     * Save the outline to the server
+     * Save the outline to the server. With merge set, a save based on an
+     * outdated version is merged into the current one if possible.
      */
-    save(shouldClose = false) {
+    save(shouldClose = false, merge = false) {
+        if (this.readOnly) {
+            this.showMessage('You cannot edit this outline', 'info');
+            return;
+        }
+
         const title = this.titleInput.value.trim();
         if (!title) {
             alert('Please enter a title');
@@ -822,21 +830,31 @@ class OutlineManager {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
+                'X-CSRF-Token': window.csrfToken,
             },
             body: JSON.stringify({
                 id: this.outlineId,
+                version: this.version,
                 title: title,
-                content: htmlContent
+                content: htmlContent,
+                merge: merge
             })
         })
         .then(response => response.json())
         .then(data => {
             if (data.success) {
+                this.outlineId = data.id;
+                this.version = data.version;
+                if (data.merged) {
+                    this.loadContent(data.title, data.content);
+                }
                 if (shouldClose) {
                     window.location.href = '/';
                 } else {
-                    this.showMessage('Saved!', 'success');
+                    this.showMessage(data.merged ? 'Merged with changes saved elsewhere' : 'Saved!', 'success');
                 }
+            } else if (data.conflict) {
+                this.showConflict(data, shouldClose);
             } else {
                 alert('Error saving outline');
             }
@@ -847,6 +865,386 @@ class OutlineManager {
         });
     }
 
+    /**
+     * Replace the editor's title and content with a copy from the server
+     */
+    loadContent(title, htmlContent) {
+        this.titleInput.value = title;
+        this.collapsedLines.clear();
+        this.fullContent = this.htmlToPlainText(htmlContent);
+        this.updateDisplay();
+    }
+
+    /**
+     * Explain that the outline was saved elsewhere since it was loaded, and
+     * let the user merge, overwrite or take the other copy
+     */
+    showConflict(data, shouldClose) {
+        const overlay = document.createElement('div');
+        overlay.id = 'save-conflict';
+        overlay.className = 'shortcut-help-overlay';
+        overlay.innerHTML = `
+            <div class="shortcut-help-content">
+                <h2>Outline changed elsewhere</h2>
+                <p>This outline was saved in another window or by someone else since you opened it.</p>
+                <ul class="history-changes" id="conflict-list"></ul>
+                <div class="editor-actions">
+                    <button class="btn-primary" id="conflict-merge">Merge changes</button>
+                    <button class="btn-secondary" id="conflict-overwrite">Keep mine</button>
+                    <button class="btn-secondary" id="conflict-theirs">Use theirs</button>
+                    <button class="btn-secondary" id="conflict-cancel">Cancel</button>
+                </div>
+            </div>
+        `;
+
+        const list = overlay.querySelector('#conflict-list');
+        if (data.conflicts.length) {
+            data.conflicts.forEach(conflict => {
+                list.appendChild(this.changeItem('removed', `${conflict.text}: ${conflict.reason}`, conflict.path));
+            });
+            overlay.querySelector('#conflict-merge').remove();
+        } else {
+            list.remove();
+        }
+
+        const close = () => overlay.remove();
+        const merge = overlay.querySelector('#conflict-merge');
+        if (merge) {
+            merge.onclick = () => {
+                close();
+                this.save(shouldClose, true);
+            };
+        }
+        overlay.querySelector('#conflict-overwrite').onclick = () => {
+            close();
+            this.version = data.version;
+            this.save(shouldClose);
+        };
+        overlay.querySelector('#conflict-theirs').onclick = () => {
+            close();
+            this.version = data.version;
+            this.loadContent(data.title, data.content);
+        };
+        overlay.querySelector('#conflict-cancel').onclick = close;
+
+        document.body.appendChild(overlay);
+    }
+
+    /**
+     * Show the outline's saved revisions, with a diff between any two
+     */
+    showHistory() {
+        if (!this.outlineId) {
+            this.showMessage('Save the outline to start its history', 'info');
+            return;
+        }
+
+        fetch(`/api/outline/revisions?id=${this.outlineId}`)
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                throw new Error('Error loading history');
+            }
+            this.renderHistory(data.revisions);
+        })
+        .catch(error => {
+            console.error('History error:', error);
+            alert('Error loading history');
+        });
+    }
+
+    /**
+     * Render the history overlay for a list of revisions, newest first
+     */
+    renderHistory(revisions) {
+        this.hideHistory();
+
+        const overlay = document.createElement('div');
+        overlay.id = 'revision-history';
+        overlay.className = 'shortcut-help-overlay';
+        overlay.innerHTML = `
+            <div class="shortcut-help-content history-content">
+                <h2>History</h2>
+                <div class="history-compare">
+                    <select id="history-from"></select>
+                    <span>&rarr;</span>
+                    <select id="history-to"></select>
+                    <button class="btn-secondary btn-small" id="history-compare">Compare</button>
+                </div>
+                <ul class="history-changes" id="history-changes"></ul>
+                <ul class="shortcut-list" id="history-list"></ul>
+                <button class="close-help" id="history-close">Close</button>
+            </div>
+        `;
+
+        const list = overlay.querySelector('#history-list');
+        const from = overlay.querySelector('#history-from');
+        const to = overlay.querySelector('#history-to');
+
+        revisions.forEach((revision, i) => {
+            const label = `${new Date(revision.created_at).toLocaleString()} - ${revision.author || 'deleted user'}`;
+            from.add(new Option(label, revision.id, false, i === Math.min(1, revisions.length - 1)));
+            to.add(new Option(label, revision.id, false, i === 0));
+
+            const item = document.createElement('li');
+            item.className = 'shortcut-item';
+            const description = document.createElement('span');
+            description.textContent = `${label} (${revision.size} bytes)${i === 0 ? ', current' : ''}`;
+            item.appendChild(description);
+            if (i > 0 && !this.readOnly) {
+                const restore = document.createElement('button');
+                restore.className = 'btn-secondary btn-small';
+                restore.textContent = 'Restore';
+                restore.onclick = () => this.restoreRevision(revision.id);
+                item.appendChild(restore);
+            }
+            list.appendChild(item);
+        });
+
+        overlay.querySelector('#history-compare').onclick = () => this.compareRevisions(from.value, to.value);
+        overlay.querySelector('#history-close').onclick = () => this.hideHistory();
+        overlay.addEventListener('click', (e) => {
+            if (e.target === overlay) {
+                this.hideHistory();
+            }
+        });
+
+        document.body.appendChild(overlay);
+        if (revisions.length > 1) {
+            this.compareRevisions(from.value, to.value);
+        }
+    }
+
+    hideHistory() {
+        const overlay = document.getElementById('revision-history');
+        if (overlay) {
+            overlay.remove();
+        }
+    }
+
+    /**
+     * Show the nodes added, removed and moved between two revisions
+     */
+    compareRevisions(fromId, toId) {
+        fetch(`/api/outline/diff?id=${this.outlineId}&from=${fromId}&to=${toId}`)
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                throw new Error('Error comparing revisions');
+            }
+            const changes = document.getElementById('history-changes');
+            if (!changes) {
+                return;
+            }
+            changes.innerHTML = '';
+            if (data.title.from !== data.title.to) {
+                changes.appendChild(this.changeItem('moved', `Title: ${data.title.from} → ${data.title.to}`, []));
+            }
+            data.changes.forEach(change => {
+                let text = change.text;
+                if (change.kind === 'moved' && change.from.join(' › ') !== change.path.join(' › ')) {
+                    text += `  (from ${change.from.join(' › ') || 'top level'})`;
+                }
+                changes.appendChild(this.changeItem(change.kind, text, change.path));
+            });
+            if (!changes.children.length) {
+                changes.appendChild(this.changeItem('', 'No changes', []));
+            }
+        })
+        .catch(error => {
+            console.error('Diff error:', error);
+            alert('Error comparing revisions');
+        });
+    }
+
+    /**
+     * Build one line of a diff, indented under its path
+     */
+    changeItem(kind, text, path) {
+        const markers = { added: '+', removed: '−', moved: '↷' };
+        const item = document.createElement('li');
+        item.className = `history-change ${kind}`;
+        item.style.paddingLeft = `${path.length * 20}px`;
+        item.title = path.join(' › ');
+        item.textContent = `${markers[kind] || ''} ${text}`.trim();
+        return item;
+    }
+
+    /**
+     * Make an earlier revision the outline's current state
+     */
+    restoreRevision(revisionId) {
+        if (!confirm('Restore this revision? The current version stays in the history.')) {
+            return;
+        }
+
+        fetch('/api/outline/restore', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': window.csrfToken,
+            },
+            body: JSON.stringify({
+                id: this.outlineId,
+                revision: Number(revisionId)
+            })
+        })
+        .then(response => response.json())
+        .then(data => {
+            if (data.success) {
+                window.location.reload();
+            } else {
+                alert('Error restoring revision');
+            }
+        })
+        .catch(error => {
+            console.error('Restore error:', error);
+            alert('Error restoring revision');
+        });
+    }
+
+    /**
+     * Show who the outline is shared with, for its owner to change
+     */
+    showSharing() {
+        fetch(`/api/outline/shares?id=${this.outlineId}`)
+        .then(response => response.json())
+        .then(data => {
+            if (!data.success) {
+                throw new Error('Error loading shares');
+            }
+            this.renderSharing(data.shares);
+        })
+        .catch(error => {
+            console.error('Sharing error:', error);
+            alert('Error loading shares');
+        });
+    }
+
+    /**
+     * Render the sharing overlay for a list of shares, owner first
+     */
+    renderSharing(shares) {
+        this.hideSharing();
+
+        const roles = ['viewer', 'editor', 'owner'];
+        const roleSelect = (selected) => {
+            const select = document.createElement('select');
+            roles.forEach(role => select.add(new Option(role, role, false, role === selected)));
+            return select;
+        };
+
+        const overlay = document.createElement('div');
+        overlay.id = 'outline-sharing';
+        overlay.className = 'shortcut-help-overlay';
+        overlay.innerHTML = `
+            <div class="shortcut-help-content">
+                <h2>Sharing</h2>
+                <ul class="shortcut-list" id="share-list"></ul>
+                <h3>Share with</h3>
+                <div class="share-form" id="share-form">
+                    <input type="text" id="share-username" placeholder="Username">
+                    <button class="btn-primary btn-small" id="share-add">Share</button>
+                </div>
+                <h3>Transfer ownership</h3>
+                <div class="share-form">
+                    <input type="text" id="transfer-username" placeholder="Username">
+                    <button class="btn-secondary btn-small" id="transfer">Transfer</button>
+                </div>
+                <button class="close-help" id="sharing-close">Close</button>
+            </div>
+        `;
+
+        const list = overlay.querySelector('#share-list');
+        shares.forEach((share, i) => {
+            const item = document.createElement('li');
+            item.className = 'shortcut-item';
+            const name = document.createElement('span');
+            name.textContent = share.username || 'deleted user';
+            item.appendChild(name);
+
+            if (i === 0) {
+                const owner = document.createElement('span');
+                owner.textContent = 'owner';
+                item.appendChild(owner);
+            } else {
+                const actions = document.createElement('span');
+                const select = roleSelect(share.role);
+                select.onchange = () => this.shareRequest('/api/outline/share', { id: this.outlineId, username: share.username, role: select.value });
+                const remove = document.createElement('button');
+                remove.className = 'btn-danger btn-small';
+                remove.textContent = 'Remove';
+                remove.onclick = () => this.shareRequest('/api/outline/unshare', { id: this.outlineId, user_id: share.user_id });
+                actions.appendChild(select);
+                actions.appendChild(remove);
+                item.appendChild(actions);
+            }
+            list.appendChild(item);
+        });
+
+        const addRole = roleSelect('viewer');
+        const username = overlay.querySelector('#share-username');
+        overlay.querySelector('#share-form').insertBefore(addRole, overlay.querySelector('#share-add'));
+        overlay.querySelector('#share-add').onclick = () => {
+            if (username.value.trim()) {
+                this.shareRequest('/api/outline/share', { id: this.outlineId, username: username.value.trim(), role: addRole.value });
+            }
+        };
+
+        const transferTo = overlay.querySelector('#transfer-username');
+        overlay.querySelector('#transfer').onclick = () => {
+            const to = transferTo.value.trim();
+            if (to && confirm(`Make ${to} the owner of this outline? You keep access as an owner until you leave it.`)) {
+                this.shareRequest('/api/outline/transfer', { id: this.outlineId, username: to });
+            }
+        };
+
+        overlay.querySelector('#sharing-close').onclick = () => this.hideSharing();
+        overlay.addEventListener('click', (e) => {
+            if (e.target === overlay) {
+                this.hideSharing();
+            }
+        });
+
+        document.body.appendChild(overlay);
+    }
+
+    hideSharing() {
+        const overlay = document.getElementById('outline-sharing');
+        if (overlay) {
+            overlay.remove();
+        }
+    }
+
+    /**
+     * Send a change to who the outline is shared with, then show the
+     * updated list
+     */
+    shareRequest(url, body) {
+        fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'X-CSRF-Token': window.csrfToken,
+            },
+            body: JSON.stringify(body)
+        })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(() => {
+            this.showMessage('Sharing updated', 'success');
+            this.showSharing();
+        })
+        .catch(error => {
+            console.error('Sharing error:', error);
+            alert(error.message || 'Error updating sharing');
+        });
+    }
+
     /**
      * Save outline as a template
      */
@@ -914,6 +1312,7 @@ class OutlineManager {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
+                    'X-CSRF-Token': window.csrfToken,
                 },
                 body: JSON.stringify({
                     name: name,
@@ -944,14 +1343,18 @@ function initializeOutliner() {
     const editor = document.getElementById('outline-editor');
     const titleInput = document.getElementById('title');
     const outlineId = window.outlineId || 0;
+    const version = window.outlineVersion || 0;
+    const readOnly = window.outlineReadOnly || false;
     
     if (editor && titleInput) {
-        const manager = new OutlineManager(editor, titleInput, outlineId);
+        const manager = new OutlineManager(editor, titleInput, outlineId, version, readOnly);
         
         // Expose globally for button clicks and help
         window.outlinerManager = manager;
         window.saveOutline = (shouldClose) => manager.save(shouldClose);
         window.saveAsTemplate = () => manager.saveAsTemplate();
+        window.showHistory = () => manager.showHistory();
+        window.showSharing = () => manager.showSharing();
         
         // Focus the editor on load if title is filled
         if (titleInput.value) {
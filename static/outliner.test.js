@@ -566,4 +566,132 @@ describe('OutlineManager', () => {
             expect(manager.getCurrentLineIndex()).toBe(2);
         });
     });
+
+    describe('Versions and Read-Only Mode', () => {
+        afterEach(() => {
+            delete global.fetch;
+        });
+
+        test('should default to version 0 and be editable', () => {
+            expect(manager.version).toBe(0);
+            expect(manager.readOnly).toBe(false);
+        });
+
+        test('should keep the version and read-only flag it is given', () => {
+            const viewer = new OutlineManager(editor, titleInput, 5, 3, true);
+            expect(viewer.outlineId).toBe(5);
+            expect(viewer.version).toBe(3);
+            expect(viewer.readOnly).toBe(true);
+        });
+
+        test('should send its version when saving', () => {
+            global.fetch = jest.fn(() => new Promise(() => {}));
+            titleInput.value = 'Plan';
+            const editable = new OutlineManager(editor, titleInput, 5, 3);
+
+            editable.save();
+
+            expect(global.fetch).toHaveBeenCalledTimes(1);
+            const body = JSON.parse(global.fetch.mock.calls[0][1].body);
+            expect(body).toMatchObject({ id: 5, version: 3, title: 'Plan', merge: false });
+        });
+
+        test('should not save a read-only outline', () => {
+            global.fetch = jest.fn();
+            titleInput.value = 'Plan';
+            const viewer = new OutlineManager(editor, titleInput, 5, 3, true);
+
+            viewer.save();
+
+            expect(global.fetch).not.toHaveBeenCalled();
+            expect(document.body.textContent).toContain('You cannot edit this outline');
+        });
+    });
+
+    describe('Save Conflicts', () => {
+        const conflict = {
+            success: false,
+            conflict: true,
+            id: 5,
+            version: 4,
+            title: 'Theirs',
+            content: '<div>Other</div>',
+            conflicts: [],
+        };
+
+        afterEach(() => {
+            delete global.fetch;
+        });
+
+        test('should show the conflict dialog when a save is refused', async () => {
+            global.fetch = jest.fn(() => Promise.resolve({ json: () => Promise.resolve(conflict) }));
+            titleInput.value = 'Plan';
+            const editable = new OutlineManager(editor, titleInput, 5, 3);
+            const show = jest.spyOn(editable, 'showConflict');
+
+            editable.save();
+            await new Promise(resolve => setTimeout(resolve, 0));
+
+            expect(show).toHaveBeenCalledWith(conflict, false);
+            expect(document.getElementById('save-conflict')).not.toBeNull();
+            expect(editable.version).toBe(3);
+        });
+
+        test('should offer to merge when nothing overlaps', () => {
+            const save = jest.spyOn(manager, 'save').mockImplementation(() => {});
+            manager.showConflict(conflict, false);
+
+            expect(document.getElementById('conflict-list')).toBeNull();
+            document.getElementById('conflict-merge').click();
+
+            expect(save).toHaveBeenCalledWith(false, true);
+            expect(document.getElementById('save-conflict')).toBeNull();
+        });
+
+        test('should list overlapping edits instead of offering to merge', () => {
+            manager.showConflict({
+                ...conflict,
+                conflicts: [{ text: 'Mine', path: ['Plan'], reason: 'the other version changed the same place' }],
+            }, false);
+
+            expect(document.getElementById('conflict-merge')).toBeNull();
+            const items = document.querySelectorAll('#conflict-list li');
+            expect(items.length).toBe(1);
+            expect(items[0].textContent).toContain('Mine: the other version changed the same place');
+        });
+
+        test('should overwrite from the current version when keeping mine', () => {
+            const save = jest.spyOn(manager, 'save').mockImplementation(() => {});
+            manager.showConflict(conflict, true);
+
+            document.getElementById('conflict-overwrite').click();
+
+            expect(manager.version).toBe(4);
+            expect(save).toHaveBeenCalledWith(true);
+            expect(document.getElementById('save-conflict')).toBeNull();
+        });
+
+        test('should load the current version when using theirs', () => {
+            const save = jest.spyOn(manager, 'save');
+            manager.showConflict(conflict, false);
+
+            document.getElementById('conflict-theirs').click();
+
+            expect(save).not.toHaveBeenCalled();
+            expect(manager.version).toBe(4);
+            expect(titleInput.value).toBe('Theirs');
+            expect(manager.fullContent).toContain('Other');
+        });
+
+        test('should leave everything as it was when cancelled', () => {
+            const save = jest.spyOn(manager, 'save');
+            manager.showConflict(conflict, false);
+
+            document.getElementById('conflict-cancel').click();
+
+            expect(save).not.toHaveBeenCalled();
+            expect(manager.version).toBe(0);
+            expect(document.getElementById('save-conflict')).toBeNull();
+        });
+    });
 });